@@ -1,76 +1,189 @@
+//go:build ignore
+
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"net"
-	"sync"
-	"sync/atomic"
+	"os"
+	"strconv"
 	"time"
-)
 
-func worker(addr string, duration time.Duration, wg *sync.WaitGroup, counter *atomic.Int64) {
-	defer wg.Done()
+	"benchmarks/internal/cli"
+	"benchmarks/internal/connstat"
+	"benchmarks/internal/echoload"
+	"benchmarks/internal/payload"
+	"benchmarks/internal/report"
+	"benchmarks/internal/retry"
+)
 
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		fmt.Printf("Connection error: %v\n", err)
-		return
+func main() {
+	opts := cli.Options{
+		URL:         "localhost:8070",
+		Concurrency: 100,
+		Duration:    10 * time.Second,
+		Timeout:     5 * time.Second,
 	}
-	defer conn.Close()
-
-	message := []byte("BENCH\n")
-	buffer := make([]byte, 1024)
-
-	start := time.Now()
-	for time.Since(start) < duration {
-		// Send message
-		_, err := conn.Write(message)
-		if err != nil {
-			return
-		}
-
-		// Read echo response
-		n, err := conn.Read(buffer)
-		if err != nil {
-			return
-		}
-
-		if n > 0 {
-			counter.Add(1)
+	unix := flag.String("unix", "", "connect to this Unix domain socket instead of -url")
+	churn := flag.Bool("no-keepalive", false, "open a new connection for every round trip, to measure accept and connection setup cost")
+	pipeline := flag.Int("pipeline", 1, "messages kept in flight per connection, to exercise the server's buffering and batching")
+	size := flag.Int("size", echoload.DefaultSize, "mean message size in bytes, trailing newline included")
+	dist := flag.String("size-dist", payload.Fixed, "message size distribution: fixed, uniform (1 to 2x -size) or lognormal (long tail, capped at 16x -size)")
+	seed := flag.Uint64("seed", 0, "seed the -size-dist draws so runs repeat the same message sizes (0 = random)")
+	udp := flag.Bool("udp", false, "echo UDP datagrams, each carrying a sequence number, and report loss and reordering; a datagram not echoed within -timeout is lost")
+	validate := flag.Bool("validate", false, "check every echo byte for byte against the message sent; differences count as mismatch errors")
+	var requests int64
+	flag.Func("n", "stop after this many successful round trips, e.g. 1_000_000, instead of after -d (an explicit -d still caps the run)", func(s string) error {
+		n, err := strconv.ParseInt(s, 0, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid round trip count %q", s)
 		}
+		requests = n
+		return nil
+	})
+	var policy retry.Policy
+	policy.Register(flag.CommandLine)
+	var socket echoload.Socket
+	socket.Register(flag.CommandLine)
+	cli.Parse(&opts)
+	if *pipeline < 1 {
+		cli.Check(errors.New("-pipeline must be at least 1"))
 	}
-}
-
-func main() {
-	addr := "localhost:8070"
-	concurrency := 100
-	duration := 10 * time.Second
-
-	fmt.Printf("Benchmarking echo server at %s\n", addr)
-	fmt.Printf("Concurrency: %d connections\n", concurrency)
-	fmt.Printf("Duration: %v\n", duration)
-	fmt.Println("Starting benchmark...")
-
-	var counter atomic.Int64
-	var wg sync.WaitGroup
-
-	start := time.Now()
+	cfg := echoload.Config{
+		Network:     "tcp",
+		Addr:        opts.Addr(),
+		Concurrency: opts.Concurrency,
+		Duration:    opts.Duration,
+		Requests:    requests,
+		Timeout:     opts.Timeout,
+		Churn:       *churn,
+		Pipeline:    *pipeline,
+		Size:        *size,
+		Dist:        *dist,
+		Seed:        *seed,
+		Check:       *validate,
+		Retry:       policy,
+		Socket:      socket,
+	}
+	if cfg.Requests > 0 && !cli.Given("d") {
+		cfg.Duration = 0
+	}
+	target := cfg.Addr
+	switch {
+	case *udp && *unix != "":
+		cli.Check(errors.New("-udp and -unix are mutually exclusive"))
+	case (*udp || *unix != "") && opts.Resolve.Proxy != nil:
+		cli.Check(errors.New("-proxy tunnels TCP only; drop -udp and -unix"))
+	case *udp:
+		cfg.Network, target = "udp", "udp:"+cfg.Addr
+	case *unix != "":
+		cfg.Network, cfg.Addr, target = "unix", *unix, "unix:"+*unix
+	}
+	cli.Check(cfg.Validate())
 
-	// Launch concurrent workers
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go worker(addr, duration, &wg, &counter)
+	info := opts.Format.Info()
+	fmt.Fprintf(info, "Benchmarking echo server at %s\n", target)
+	if *udp {
+		fmt.Fprintf(info, "Concurrency: %d sockets\n", cfg.Concurrency)
+	} else {
+		fmt.Fprintf(info, "Concurrency: %d connections\n", cfg.Concurrency)
+	}
+	switch {
+	case cfg.Requests > 0 && cfg.Duration > 0:
+		fmt.Fprintf(info, "Round trips: %d successful, within %v\n", cfg.Requests, cfg.Duration)
+	case cfg.Requests > 0:
+		fmt.Fprintf(info, "Round trips: %d successful\n", cfg.Requests)
+	default:
+		fmt.Fprintf(info, "Duration: %v\n", cfg.Duration)
+	}
+	if cfg.Churn {
+		fmt.Fprintln(info, "Keep-alive: off (new connection per round trip)")
 	}
+	if cfg.Pipeline > 1 {
+		fmt.Fprintf(info, "Pipeline: %d messages in flight per connection\n", cfg.Pipeline)
+	}
+	fmt.Fprintf(info, "Message size: %d bytes (%s)\n", cfg.Size, cfg.Dist)
+	if cfg.Seed != 0 {
+		fmt.Fprintf(info, "Seed: %d\n", cfg.Seed)
+	}
+	if cfg.Retry.Enabled() {
+		fmt.Fprintf(info, "Retries: %s\n", cfg.Retry.String())
+	}
+	cfg.Socket.Describe(info)
+	opts.Resolve.Describe(info)
+	fmt.Fprintln(info, "Starting benchmark...")
+	cfg.Context = cli.Interrupt(info)
 
-	// Wait for all workers to finish
-	wg.Wait()
-	elapsed := time.Since(start)
+	cfg.Dial = opts.Resolve.Dial(cfg.Socket.Dialer())
+	var setup *connstat.Stats
+	if cfg.Churn {
+		setup = connstat.New()
+		cfg.Dial = setup.DialContext(cfg.Dial)
+	}
 
-	totalRequests := counter.Load()
-	rps := float64(totalRequests) / elapsed.Seconds()
+	cfg.Progress = opts.Meter(cfg.Concurrency)
+	cfg.Metrics = opts.ServeMetrics("bench_echo", info)
+	defer cfg.Metrics.Close()
+	cfg.Raw = opts.RawLog()
+	prof := opts.Profile()
+	server := opts.SampleServer()
+	stop := cfg.Progress.Start(info, opts.Progress)
+	run := echoload.Run(cfg)
+	stop()
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	server.Stop()
+	if err := cfg.Raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 
-	fmt.Println("\nResults:")
-	fmt.Printf("Total requests: %d\n", totalRequests)
-	fmt.Printf("Time elapsed: %v\n", elapsed)
-	fmt.Printf("Requests/sec: %.2f\n", rps)
+	result := report.NewResult("bench_echo", target, run.Concurrency, run.Elapsed, run.Requests, run.Errors, run.Latency)
+	result.ErrorClasses = run.ErrorClasses
+	result.Retried, result.Retries = run.Retried, run.Retries
+	result.Workers = report.NewFairness(run.Workers)
+	result.Config = map[string]string{"duration": cfg.Duration.String(), "timeout": cfg.Timeout.String()}
+	cfg.Retry.Record(result.Config)
+	cfg.Socket.Record(result.Config)
+	result.Config["size"] = strconv.Itoa(cfg.Size)
+	if cfg.Seed != 0 {
+		result.Config["seed"] = strconv.FormatUint(cfg.Seed, 10)
+	}
+	if cfg.Requests > 0 {
+		result.Config["n"] = strconv.FormatInt(cfg.Requests, 10)
+	}
+	result.Config["size_dist"] = cfg.Dist
+	result.Config["validate"] = strconv.FormatBool(cfg.Check)
+	result.Config["echoed_bytes"] = strconv.FormatInt(run.Echoed, 10)
+	if cfg.Pipeline > 1 {
+		result.Config["pipeline"] = strconv.Itoa(cfg.Pipeline)
+	}
+	fmt.Fprintf(info, "\nEchoed: %.2f MB/s each way\n", float64(run.Echoed)/run.Elapsed.Seconds()/1e6)
+	if *udp {
+		lossPct := 100 * float64(run.Lost) / float64(max(run.Sent, 1))
+		reorderPct := 100 * float64(run.Reordered) / float64(max(run.Requests, 1))
+		fmt.Fprintf(info, "Datagrams: %d sent, %d lost (%.3f%%), %d reordered (%.3f%% of echoes), %d late or duplicate\n",
+			run.Sent, run.Lost, lossPct, run.Reordered, reorderPct, run.Late)
+		result.Config["udp"] = "true"
+		result.Config["sent"] = strconv.FormatInt(run.Sent, 10)
+		result.Config["lost"] = strconv.FormatInt(run.Lost, 10)
+		result.Config["loss_pct"] = strconv.FormatFloat(lossPct, 'f', 3, 64)
+		result.Config["reordered"] = strconv.FormatInt(run.Reordered, 10)
+		result.Config["reorder_pct"] = strconv.FormatFloat(reorderPct, 'f', 3, 64)
+		result.Config["late"] = strconv.FormatInt(run.Late, 10)
+	}
+	opts.Resolve.Annotate(info, []report.Result{result})
+	cli.MarkInterrupted(cfg.Context, []report.Result{result})
+	setup.Annotate(info, []report.Result{result})
+	cfg.Raw.Annotate(info, []report.Result{result})
+	server.Annotate(info, []report.Result{result})
+	prof.Annotate(info, []report.Result{result}, result.Requests+result.Errors+result.Retries)
+	if err := report.Write(os.Stdout, opts.Format, result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -1,76 +1,150 @@
+//go:build ignore
+
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net"
-	"sync"
-	"sync/atomic"
+	"os"
+	"path/filepath"
 	"time"
+
+	"benchmarks/internal/cli"
+	"benchmarks/internal/connstat"
+	"benchmarks/internal/echoload"
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/progress"
+	"benchmarks/internal/rawlog"
+	"benchmarks/internal/report"
+	"benchmarks/internal/retry"
 )
 
-func worker(addr string, duration time.Duration, wg *sync.WaitGroup, counter *atomic.Int64) {
-	defer wg.Done()
+func runBench(ctx context.Context, dial connstat.DialFunc, addr string, concurrency int, duration, timeout time.Duration, policy retry.Policy, meter *progress.Meter, exported *metrics.Metrics, raw *rawlog.Writer) report.Result {
+	run := echoload.Run(echoload.Config{
+		Dial:        dial,
+		Addr:        addr,
+		Concurrency: concurrency,
+		Duration:    duration,
+		Timeout:     timeout,
+		Retry:       policy,
+		Progress:    meter,
+		Metrics:     exported,
+		Raw:         raw,
+		Context:     ctx,
+	})
+	result := report.NewResult("bench_echo_stress", addr, concurrency, run.Elapsed, run.Requests, run.Errors, run.Latency)
+	result.ErrorClasses = run.ErrorClasses
+	result.Retried, result.Retries = run.Retried, run.Retries
+	result.Workers = report.NewFairness(run.Workers)
+	result.Config = map[string]string{"duration": duration.String(), "timeout": timeout.String()}
+	policy.Record(result.Config)
+	return result
+}
 
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return
+func main() {
+	opts := cli.Options{
+		URL:      "localhost:8070",
+		Duration: 10 * time.Second,
+		Timeout:  5 * time.Second,
+		Levels:   cli.IntList{50, 100, 200, 500, 1000},
+	}
+	matrix := flag.String("matrix", "", "also write concurrency x RPS x latency percentiles to this file for plotting (.json for JSON, otherwise CSV)")
+	var policy retry.Policy
+	policy.Register(flag.CommandLine)
+	cli.Parse(&opts)
+	cli.Check(policy.Validate())
+
+	// Created up front so a bad path fails before a long sweep, not after
+	var matrixFile *os.File
+	if *matrix != "" {
+		f, err := os.Create(*matrix)
+		cli.Check(err)
+		matrixFile = f
 	}
-	defer conn.Close()
-
-	message := []byte("BENCH\n")
-	buffer := make([]byte, 1024)
 
-	start := time.Now()
-	for time.Since(start) < duration {
-		_, err := conn.Write(message)
-		if err != nil {
-			return
+	info := opts.Format.Info()
+	fmt.Fprintln(info, "Echo Server Performance Benchmark")
+	fmt.Fprintln(info, "Testing different concurrency levels...")
+	opts.Resolve.Describe(info)
+	fmt.Fprintln(info)
+
+	// One endpoint for the whole sweep so dashboards see a continuous series
+	exported := opts.ServeMetrics("bench_echo_stress", info)
+	defer exported.Close()
+
+	ctx := cli.Interrupt(info)
+	var results []report.Result
+	var sent int64
+	// One file for the whole sweep; timestamps tell the levels apart
+	raw := opts.RawLog()
+	prof := opts.Profile()
+	for _, c := range opts.Levels {
+		meter := opts.Meter(c)
+		server := opts.SampleServer()
+		stop := meter.Start(info, opts.Progress)
+		r := runBench(ctx, opts.Resolve.Dial(&net.Dialer{}), opts.Addr(), c, opts.Duration, opts.Timeout, policy, meter, exported, raw)
+		stop()
+		server.Stop()
+		fmt.Fprintf(info, "Concurrency %4d: %10d requests in %v = %10.2f req/s  p50=%v p99=%v p99.9=%v  errors=%d",
+			r.Concurrency, r.Requests, r.Elapsed.Round(time.Millisecond), r.RPS, r.Latency.P50, r.Latency.P99, r.Latency.P999, r.Errors)
+		if r.Errors > 0 {
+			fmt.Fprintf(info, " (%s)", r.ErrorClasses)
 		}
-
-		n, err := conn.Read(buffer)
-		if err != nil {
-			return
+		fmt.Fprintln(info)
+		// Sampled per level, so the cost of each step up shows
+		server.Annotate(info, []report.Result{r})
+		results = append(results, r)
+		sent += r.Requests + r.Errors + r.Retries
+		if ctx.Err() != nil {
+			// Report the levels done and the one cut short
+			cli.MarkInterrupted(ctx, results[len(results)-1:])
+			break
 		}
+		time.Sleep(1 * time.Second)
+	}
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	opts.Resolve.Annotate(info, results)
+	raw.Annotate(info, results)
+	prof.Annotate(info, results, sent)
 
-		if n > 0 {
-			counter.Add(1)
+	if i := report.Knee(results); i >= 0 {
+		r := results[i]
+		fmt.Fprintf(info, "\nKnee: concurrency %d (%.2f req/s at p99=%v); higher levels mostly add queueing\n", r.Concurrency, r.RPS, r.Latency.P99)
+	}
+	if matrixFile != nil {
+		if err := writeMatrix(matrixFile, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		fmt.Fprintf(info, "Matrix written to %s\n", *matrix)
 	}
-}
-
-func runBench(concurrency int) {
-	addr := "localhost:8070"
-	duration := 10 * time.Second
-
-	var counter atomic.Int64
-	var wg sync.WaitGroup
-
-	start := time.Now()
 
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go worker(addr, duration, &wg, &counter)
+	if opts.Format != report.Text {
+		if err := report.Write(os.Stdout, opts.Format, results...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
-
-	wg.Wait()
-	elapsed := time.Since(start)
-
-	totalRequests := counter.Load()
-	rps := float64(totalRequests) / elapsed.Seconds()
-
-	fmt.Printf("Concurrency %4d: %10d requests in %v = %10.2f req/s\n",
-		concurrency, totalRequests, elapsed.Round(time.Millisecond), rps)
 }
 
-func main() {
-	fmt.Println("Echo Server Performance Benchmark")
-	fmt.Println("Testing different concurrency levels...")
-	fmt.Println()
-
-	concurrencyLevels := []int{50, 100, 200, 500, 1000}
-
-	for _, c := range concurrencyLevels {
-		runBench(c)
-		time.Sleep(1 * time.Second)
+func writeMatrix(f *os.File, results []report.Result) error {
+	format := report.CSV
+	if filepath.Ext(f.Name()) == ".json" {
+		format = report.JSON
+	}
+	if err := report.WriteMatrix(f, format, results); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
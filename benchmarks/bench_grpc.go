@@ -0,0 +1,181 @@
+//go:build ignore
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/cli"
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/grpcecho"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/progress"
+	"benchmarks/internal/rawlog"
+	"benchmarks/internal/report"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func worker(interrupt context.Context, cc *grpc.ClientConn, payload []byte, duration, timeout time.Duration, wg *sync.WaitGroup, counter *atomic.Int64, errs errclass.Counts, latency *hdr.Histogram, meter *progress.Meter, exported *metrics.Metrics, raw *rawlog.Buffer, id int) {
+	defer wg.Done()
+	defer raw.Flush()
+
+	req := &grpcecho.Message{Payload: payload}
+	var reply grpcecho.Message
+
+	start := time.Now()
+	for time.Since(start) < duration && interrupt.Err() == nil {
+		reqStart := time.Now()
+		ctx, cancel := interrupt, context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		exported.Begin()
+		err := grpcecho.Call(ctx, cc, req, &reply)
+		cancel()
+		d := time.Since(reqStart)
+
+		class := ""
+		switch {
+		case err != nil:
+			// gRPC reports failures as status codes; name them after the
+			// code so Unavailable and DeadlineExceeded stay distinct
+			class = "grpc_" + status.Code(err).String()
+		case !bytes.Equal(reply.Payload, payload):
+			class = "mismatch"
+		}
+		exported.End(d, class)
+		if class != "" && interrupt.Err() != nil {
+			// Cut short by the interrupt, not the server's failure
+			return
+		}
+		raw.Record(reqStart, d, class == "")
+		if class != "" {
+			errs[class]++
+			meter.Error(id)
+			continue
+		}
+		counter.Add(1)
+		latency.Record(d)
+		meter.Record(id, d)
+	}
+}
+
+func main() {
+	opts := cli.Options{
+		URL:         "localhost:8080",
+		Concurrency: 100,
+		Duration:    10 * time.Second,
+		Timeout:     5 * time.Second,
+	}
+	size := flag.Int("size", 64, "echo payload size in bytes")
+	conns := flag.Int("conns", 1, "HTTP/2 connections to spread workers across (gRPC multiplexes streams on each)")
+	cli.Parse(&opts)
+	switch {
+	case *size < 0:
+		cli.Check(errors.New("-size must not be negative"))
+	case *conns < 1 || *conns > opts.Concurrency:
+		cli.Check(errors.New("-conns must be between 1 and -c"))
+	}
+	addr, concurrency, duration := opts.Addr(), opts.Concurrency, opts.Duration
+
+	info := opts.Format.Info()
+	fmt.Fprintf(info, "Benchmarking gRPC server at %s (%s)\n", addr, grpcecho.Method)
+	fmt.Fprintf(info, "Concurrency: %d workers over %d connection(s)\n", concurrency, *conns)
+	fmt.Fprintf(info, "Payload: %d bytes\n", *size)
+	fmt.Fprintf(info, "Duration: %v\n", duration)
+	opts.Resolve.Describe(info)
+	fmt.Fprintln(info, "Starting benchmark...")
+	interrupt := cli.Interrupt(info)
+
+	// Plaintext HTTP/2 (h2c), the same transport bench_http2 uses, so a
+	// gRPC handler and a plain handler on one port compare like for like
+	target, dialOpts := addr, []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if opts.Resolve.Enabled() {
+		// Dial the address as given so the override sees the host name;
+		// :authority stays addr
+		dial := opts.Resolve.Dial(&net.Dialer{})
+		target = "passthrough:///" + addr
+		dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, a string) (net.Conn, error) {
+			return dial(ctx, "tcp", a)
+		}))
+	}
+	clients := make([]*grpc.ClientConn, *conns)
+	for i := range clients {
+		cc, err := grpc.NewClient(target, dialOpts...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer cc.Close()
+		clients[i] = cc
+	}
+
+	payload := bytes.Repeat([]byte{'x'}, *size)
+	var counter atomic.Int64
+	var wg sync.WaitGroup
+	histograms := make([]*hdr.Histogram, concurrency)
+	classes := make([]errclass.Counts, concurrency)
+
+	meter := opts.Meter(concurrency)
+	exported := opts.ServeMetrics("bench_grpc", info)
+	defer exported.Close()
+	raw := opts.RawLog()
+	prof := opts.Profile()
+	server := opts.SampleServer()
+	stop := meter.Start(info, opts.Progress)
+	start := time.Now()
+
+	for i := 0; i < concurrency; i++ {
+		histograms[i] = hdr.New()
+		classes[i] = make(errclass.Counts)
+		wg.Add(1)
+		go worker(interrupt, clients[i%len(clients)], payload, duration, opts.Timeout, &wg, &counter, classes[i], histograms[i], meter, exported, raw.Worker(i), i)
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+	stop()
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	server.Stop()
+	if err := raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	errs := errclass.Merged(classes)
+	result := report.NewResult("bench_grpc", addr, concurrency, elapsed, counter.Load(), errs.Total(), hdr.Merged(histograms))
+	result.ErrorClasses = errs
+	result.Workers = report.NewFairness(hdr.Counts(histograms))
+	result.Config = map[string]string{
+		"duration": duration.String(),
+		"timeout":  opts.Timeout.String(),
+		"size":     strconv.Itoa(*size),
+		"conns":    strconv.Itoa(*conns),
+	}
+	opts.Resolve.Annotate(info, []report.Result{result})
+	cli.MarkInterrupted(interrupt, []report.Result{result})
+	raw.Annotate(info, []report.Result{result})
+	server.Annotate(info, []report.Result{result})
+	prof.Annotate(info, []report.Result{result}, result.Requests+result.Errors)
+	if err := report.Write(os.Stdout, opts.Format, result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
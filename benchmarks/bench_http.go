@@ -1,75 +1,212 @@
+//go:build ignore
+
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"sync"
-	"sync/atomic"
+	"os"
+	"strings"
 	"time"
+
+	"benchmarks/internal/cli"
+	"benchmarks/internal/compare"
+	"benchmarks/internal/connstat"
+	"benchmarks/internal/httpload"
+	"benchmarks/internal/report"
+	"benchmarks/internal/resolve"
+	"benchmarks/internal/tlsbench"
 )
 
-func worker(client *http.Client, url string, duration time.Duration, wg *sync.WaitGroup, counter *atomic.Int64) {
-	defer wg.Done()
+func main() {
+	opts := cli.Options{
+		URL:         "http://localhost:8070/",
+		Concurrency: 100,
+		Duration:    10 * time.Second,
+		Timeout:     5 * time.Second,
+	}
+	var cfg httpload.Config
+	var cluster httpload.Cluster
+	var tlsOpts tlsbench.Options
+	cfg.Register(flag.CommandLine)
+	cluster.Register(flag.CommandLine)
+	tlsOpts.Register(flag.CommandLine)
+	noKeepAlive := flag.Bool("no-keepalive", false, "open a new connection for every request, to measure accept, connect and TLS handshake cost")
+	ab := flag.Bool("ab-keepalive", false, "run the workload twice, with and without keep-alive, and compare the two side by side")
+	unix := flag.String("unix", "", "connect to this Unix domain socket instead of the URL's host (the URL still sets Host and path)")
+	cli.Parse(&opts)
+	switch {
+	case *ab && *noKeepAlive:
+		cli.Check(errors.New("-ab-keepalive and -no-keepalive are mutually exclusive"))
+	case *ab && (cluster.Coordinator != "" || cluster.Worker != ""):
+		cli.Check(errors.New("-ab-keepalive cannot run distributed"))
+	case *unix != "" && opts.Resolve.Enabled():
+		cli.Check(errors.New("-unix cannot be combined with -resolve, -4, -6, -fallback-delay or -proxy"))
+	}
+	cfg.URL, cfg.Concurrency, cfg.Duration = tlsOpts.URL(opts.URL), opts.Concurrency, opts.Duration
+	if cfg.Requests > 0 && !cli.Given("d") {
+		cfg.Duration = 0
+	}
+	cfg.Timeouts.Request = opts.Timeout
 
-	start := time.Now()
-	for time.Since(start) < duration {
-		resp, err := client.Get(url)
-		if err != nil {
-			continue
-		}
+	info := opts.Format.Info()
+	cli.Check(cluster.Join(&cfg, info))
+	cli.Check(cfg.Validate())
+	fmt.Fprintf(info, "Benchmarking HTTP server at %s\n", cfg.URL)
+	cfg.Describe(info)
+	tlsOpts.Describe(info)
+	opts.Resolve.Describe(info)
+	if *unix != "" {
+		fmt.Fprintf(info, "Unix socket: %s\n", *unix)
+	}
+	switch {
+	case *noKeepAlive:
+		fmt.Fprintln(info, "Keep-alive: off (new connection per request)")
+	case *ab:
+		fmt.Fprintln(info, "Keep-alive: A/B, persistent connections first, then a new connection per request")
+	}
+	fmt.Fprintln(info, "Starting benchmark...")
+	cfg.Context = cli.Interrupt(info)
 
-		// Read and discard body
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
+	if !cluster.Coordinating() {
+		cfg.Progress = opts.Meter(cfg.MaxConcurrency())
+	}
 
-		if resp.StatusCode == 200 {
-			counter.Add(1)
+	cfg.Metrics = opts.ServeMetrics("bench_http", info)
+	defer cfg.Metrics.Close()
+	cfg.Raw = opts.RawLog()
+	prof := opts.Profile()
+	server := opts.SampleServer()
+	stop := cfg.Progress.Start(info, opts.Progress)
+	var results []report.Result
+	var sent int64
+	if *ab {
+		results, sent = keepAliveAB(cfg, &tlsOpts, *unix, &opts.Resolve, info)
+		stop()
+	} else {
+		var setup *connstat.Stats
+		cfg.Client, setup = newClient(cfg, &tlsOpts, *unix, &opts.Resolve, !*noKeepAlive)
+		runs, err := cluster.Run(cfg, info)
+		stop()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		results, sent = cfg.Reports("bench_http", runs), httpload.Sent(runs)
+		cfg.Summarize(info, runs)
+		cluster.Annotate(results)
+		setup.Annotate(info, results)
+	}
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	server.Stop()
+	if err := cfg.Raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	tlsOpts.Annotate(info, results)
+	if *unix != "" {
+		for _, r := range results {
+			r.Config["unix"] = *unix
+		}
+	}
+	opts.Resolve.Annotate(info, results)
+	cli.MarkInterrupted(cfg.Context, results)
+	cfg.Raw.Annotate(info, results)
+	server.Annotate(info, results)
+	prof.Annotate(info, results, sent)
+	if err := report.Write(os.Stdout, opts.Format, results...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
-func main() {
-	url := "http://localhost:8070/"
-	concurrency := 100
-	duration := 10 * time.Second
-
-	fmt.Printf("Benchmarking HTTP server at %s\n", url)
-	fmt.Printf("Concurrency: %d connections\n", concurrency)
-	fmt.Printf("Duration: %v\n", duration)
-	fmt.Println("Starting benchmark...")
-
+// newClient builds the client for one run, dialing as dialing says.
+// Without keepAlive every request dials afresh and the returned stats time
+// each connect and handshake.
+func newClient(cfg httpload.Config, tlsOpts *tlsbench.Options, unix string, dialing *resolve.Options, keepAlive bool) (*http.Client, *connstat.Stats) {
 	// Create HTTP client with connection pooling
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
 	transport := &http.Transport{
-		MaxIdleConns:        concurrency,
-		MaxIdleConnsPerHost: concurrency,
+		DialContext:         dialing.Dial(dialer),
+		MaxIdleConns:        cfg.MaxConcurrency(),
+		MaxIdleConnsPerHost: cfg.MaxConcurrency(),
 		IdleConnTimeout:     90 * time.Second,
 	}
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   5 * time.Second,
+	if tlsOpts.Enabled {
+		// A custom TLS config keeps the transport on HTTP/1.1
+		transport.TLSClientConfig = tlsOpts.Config(cfg.MaxConcurrency())
 	}
-
-	var counter atomic.Int64
-	var wg sync.WaitGroup
-
-	start := time.Now()
-
-	// Launch concurrent workers
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go worker(client, url, duration, &wg, &counter)
+	if unix != "" {
+		// Skips the kernel TCP stack so the numbers isolate parser and
+		// router cost from network cost
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", unix)
+		}
+	}
+	var setup *connstat.Stats
+	if !keepAlive {
+		setup = connstat.New()
+		transport.DisableKeepAlives = true
+		transport.DialContext = setup.DialContext(transport.DialContext)
+		if tlsOpts.Enabled {
+			// Handshaking ourselves is the only way to time it apart
+			// from the request
+			transport.DialTLSContext = cfg.Progress.DialContext(setup.DialTLSContext(transport.DialContext, transport.TLSClientConfig))
+		}
 	}
+	transport.DialContext = cfg.Progress.DialContext(transport.DialContext)
+	return &http.Client{Transport: transport}, setup
+}
 
-	// Wait for all workers to finish
-	wg.Wait()
-	elapsed := time.Since(start)
+// keepAliveAB runs cfg over persistent connections, then again with a new
+// connection per request, prints the two side by side and returns both
+// sets of results labelled by mode, with the requests sent by both.
+func keepAliveAB(cfg httpload.Config, tlsOpts *tlsbench.Options, unix string, dialing *resolve.Options, info io.Writer) ([]report.Result, int64) {
+	var persistent, fresh []report.Result
+	var setup *connstat.Stats
+	var sent int64
+	for _, keepAlive := range []bool{true, false} {
+		if !keepAlive && cfg.Context.Err() != nil {
+			break
+		}
+		client, s := newClient(cfg, tlsOpts, unix, dialing, keepAlive)
+		cfg.Client = client
+		runs := httpload.RunStages(cfg)
+		results := cfg.Reports("bench_http", runs)
+		sent += httpload.Sent(runs)
+		client.CloseIdleConnections()
+		if keepAlive {
+			persistent = results
+		} else {
+			fresh, setup = results, s
+		}
+	}
 
-	totalRequests := counter.Load()
-	rps := float64(totalRequests) / elapsed.Seconds()
+	// An interrupted first pass leaves nothing to compare it with
+	if fresh != nil {
+		fmt.Fprintln(info, "\nKeep-alive vs new connection per request:")
+		compare.WriteColumns(info, compare.Compare(persistent, fresh, compare.ReportOnly), "keep-alive", "no keep-alive")
+		setup.Annotate(info, fresh)
+	}
+	for _, r := range persistent {
+		r.Config["keepalive"] = "true"
+	}
+	label(persistent, "keep-alive")
+	label(fresh, "no keep-alive")
+	return append(persistent, fresh...), sent
+}
 
-	fmt.Println("\nResults:")
-	fmt.Printf("Total requests: %d\n", totalRequests)
-	fmt.Printf("Time elapsed: %v\n", elapsed)
-	fmt.Printf("Requests/sec: %.2f\n", rps)
+func label(results []report.Result, mode string) {
+	for i := range results {
+		results[i].Label = strings.TrimSpace(mode + " " + results[i].Label)
+	}
 }
@@ -1,82 +1,171 @@
+//go:build ignore
+
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"sync"
-	"sync/atomic"
+	"os"
+	"strconv"
 	"time"
 
+	"benchmarks/internal/cli"
+	"benchmarks/internal/h2ping"
+	"benchmarks/internal/httpload"
+	"benchmarks/internal/report"
+	"benchmarks/internal/tlsbench"
 	"golang.org/x/net/http2"
 )
 
-func worker(client *http.Client, url string, duration time.Duration, wg *sync.WaitGroup, counter *atomic.Int64) {
-	defer wg.Done()
+func main() {
+	opts := cli.Options{
+		URL:         "http://localhost:8080/",
+		Concurrency: 100,
+		Duration:    10 * time.Second,
+		Timeout:     5 * time.Second,
+	}
+	var cfg httpload.Config
+	var cluster httpload.Cluster
+	var tlsOpts tlsbench.Options
+	cfg.Register(flag.CommandLine)
+	cluster.Register(flag.CommandLine)
+	tlsOpts.Register(flag.CommandLine)
+	conns := flag.Int("conns", 0, "spread workers over exactly this many connections (default: let the transport share as few as the server allows)")
+	streams := flag.Int("streams-per-conn", 0, "concurrent streams per connection; with -conns this sets -c to conns x streams")
+	pingInterval := flag.Duration("ping-interval", 0, "send an HTTP/2 PING on every connection this often and report the RTT next to request latency, to tell network delay from server queuing (0 = off)")
+	cli.Parse(&opts)
+	switch {
+	case *conns < 0 || *streams < 0:
+		cli.Check(errors.New("-conns and -streams-per-conn must not be negative"))
+	case *streams > 0 && *conns > 0:
+		opts.Concurrency = *conns * *streams
+	case *streams > 0:
+		*conns = (opts.Concurrency + *streams - 1) / *streams
+	}
+	cfg.URL, cfg.Concurrency, cfg.Duration = tlsOpts.URL(opts.URL), opts.Concurrency, opts.Duration
+	if cfg.Requests > 0 && !cli.Given("d") {
+		cfg.Duration = 0
+	}
+	cfg.Timeouts.Request = opts.Timeout
 
-	start := time.Now()
-	for time.Since(start) < duration {
-		resp, err := client.Get(url)
-		if err != nil {
-			continue
-		}
+	info := opts.Format.Info()
+	cli.Check(cluster.Join(&cfg, info))
+	cli.Check(cfg.Validate())
+	if *conns > cfg.MaxConcurrency() {
+		cli.Check(errors.New("-conns must not exceed the number of workers"))
+	}
+	fmt.Fprintf(info, "Benchmarking HTTP/2 server at %s\n", cfg.URL)
+	cfg.Describe(info)
+	tlsOpts.Describe(info)
+	opts.Resolve.Describe(info)
+	if *conns > 0 {
+		fmt.Fprintf(info, "Connections: %d (~%d streams each)\n", *conns, (cfg.MaxConcurrency()+*conns-1) / *conns)
+	}
+	if *pingInterval > 0 {
+		fmt.Fprintf(info, "PING: every %v on each connection\n", *pingInterval)
+	}
+	fmt.Fprintln(info, "Starting benchmark...")
+	cfg.Context = cli.Interrupt(info)
 
-		// Read and discard body
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
+	var pinger *h2ping.Pinger
+	if !cluster.Coordinating() {
+		cfg.Progress = opts.Meter(cfg.MaxConcurrency())
+		pinger = h2ping.New(*pingInterval)
+	}
 
-		if resp.StatusCode == 200 {
-			counter.Add(1)
+	newTransport := func() *http2.Transport {
+		// Create HTTP/2 transport with h2c (HTTP/2 cleartext)
+		var dialer net.Dialer
+		dial := cfg.Progress.DialContext(opts.Resolve.Dial(&dialer))
+		transport := &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				// Use regular TCP connection for h2c
+				return dial(ctx, network, addr)
+			},
+		}
+		if tlsOpts.Enabled {
+			// Negotiate h2 over TLS via ALPN instead
+			transport = &http2.Transport{
+				TLSClientConfig: tlsOpts.Config(cfg.MaxConcurrency()),
+				DialTLSContext: func(ctx context.Context, network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+					conn, err := dial(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+					tc := tls.Client(conn, tlsConfig)
+					if err := tc.HandshakeContext(ctx); err != nil {
+						conn.Close()
+						return nil, err
+					}
+					return tc, nil
+				},
+			}
 		}
+		transport.ConnPool = pinger.Pool(transport)
+		return transport
 	}
-}
-
-func main() {
-	url := "http://localhost:8080/"
-	concurrency := 100
-	duration := 10 * time.Second
 
-	fmt.Printf("Benchmarking HTTP/2 server at %s\n", url)
-	fmt.Printf("Concurrency: %d connections\n", concurrency)
-	fmt.Printf("Duration: %v\n", duration)
-	fmt.Println("Starting benchmark...")
-
-	// Create HTTP/2 transport with h2c (HTTP/2 cleartext)
-	transport := &http2.Transport{
-		AllowHTTP: true,
-		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-			// Use regular TCP connection for h2c
-			return net.Dial(network, addr)
-		},
+	if *conns == 0 {
+		cfg.Client = &http.Client{Transport: newTransport()}
+	} else {
+		// One transport per connection: a shared one would coalesce every
+		// stream onto as few connections as the server's stream limit
+		// allows. Strict limits make excess streams queue on their own
+		// connection instead of opening another.
+		for range *conns {
+			t := newTransport()
+			t.StrictMaxConcurrentStreams = true
+			cfg.Clients = append(cfg.Clients, &http.Client{Transport: t})
+		}
 	}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   5 * time.Second,
+	cfg.Metrics = opts.ServeMetrics("bench_http2", info)
+	defer cfg.Metrics.Close()
+	cfg.Raw = opts.RawLog()
+	prof := opts.Profile()
+	server := opts.SampleServer()
+	stop := cfg.Progress.Start(info, opts.Progress)
+	runs, err := cluster.Run(cfg, info)
+	stop()
+	pinger.Stop()
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
-
-	var counter atomic.Int64
-	var wg sync.WaitGroup
-
-	start := time.Now()
-
-	// Launch concurrent workers
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go worker(client, url, duration, &wg, &counter)
+	server.Stop()
+	if err := cfg.Raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	results := cfg.Reports("bench_http2", runs)
+	cfg.Summarize(info, runs)
+	cluster.Annotate(results)
+	tlsOpts.Annotate(info, results)
+	pinger.Annotate(info, results)
+	if *conns > 0 {
+		for _, r := range results {
+			r.Config["conns"] = strconv.Itoa(*conns)
+			r.Config["streams_per_conn"] = strconv.Itoa((r.Concurrency + *conns - 1) / *conns)
+		}
+	}
+	opts.Resolve.Annotate(info, results)
+	cli.MarkInterrupted(cfg.Context, results)
+	cfg.Raw.Annotate(info, results)
+	server.Annotate(info, results)
+	prof.Annotate(info, results, httpload.Sent(runs))
+	if err := report.Write(os.Stdout, opts.Format, results...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	// Wait for all workers to finish
-	wg.Wait()
-	elapsed := time.Since(start)
-
-	totalRequests := counter.Load()
-	rps := float64(totalRequests) / elapsed.Seconds()
-
-	fmt.Println("\nResults:")
-	fmt.Printf("Total requests: %d\n", totalRequests)
-	fmt.Printf("Time elapsed: %v\n", elapsed)
-	fmt.Printf("Requests/sec: %.2f\n", rps)
 }
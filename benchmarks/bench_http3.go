@@ -0,0 +1,122 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"benchmarks/internal/cli"
+	"benchmarks/internal/httpload"
+	"benchmarks/internal/report"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func main() {
+	opts := cli.Options{
+		URL:         "https://localhost:443/",
+		Concurrency: 100,
+		Duration:    10 * time.Second,
+		Timeout:     5 * time.Second,
+	}
+	var cfg httpload.Config
+	var cluster httpload.Cluster
+	cfg.Register(flag.CommandLine)
+	cluster.Register(flag.CommandLine)
+	insecure := flag.Bool("insecure", true, "skip certificate verification (FasterAPI's bundled certs are self-signed)")
+	cli.Parse(&opts)
+	if opts.Resolve.Proxy != nil {
+		cli.Check(errors.New("-proxy tunnels TCP only and cannot carry QUIC"))
+	}
+	cfg.URL, cfg.Concurrency, cfg.Duration = opts.URL, opts.Concurrency, opts.Duration
+	if cfg.Requests > 0 && !cli.Given("d") {
+		cfg.Duration = 0
+	}
+	cfg.Timeouts.Request = opts.Timeout
+
+	info := opts.Format.Info()
+	cli.Check(cluster.Join(&cfg, info))
+	cli.Check(cfg.Validate())
+	fmt.Fprintf(info, "Benchmarking HTTP/3 server at %s\n", cfg.URL)
+	cfg.Describe(info)
+	opts.Resolve.Describe(info)
+	fmt.Fprintln(info, "Starting benchmark...")
+	cfg.Context = cli.Interrupt(info)
+
+	// HTTP/3 multiplexes every worker onto one QUIC connection per host,
+	// like the HTTP/2 transport does over TCP. How many requests run on it
+	// at once is up to the server's stream limit; workers beyond it wait
+	// for a stream.
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: *insecure,
+			NextProtos:         []string{http3.NextProtoH3},
+		},
+		QUICConfig: &quic.Config{
+			KeepAlivePeriod: 10 * time.Second,
+		},
+	}
+	if opts.Resolve.Enabled() {
+		// One UDP socket of the chosen family for every connection. The
+		// transport has already set the TLS server name from the URL.
+		udp, err := net.ListenUDP(opts.Resolve.Network("udp"), nil)
+		cli.Check(err)
+		qt := &quic.Transport{Conn: udp}
+		defer qt.Close()
+		transport.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			raddr, err := net.ResolveUDPAddr(opts.Resolve.Network("udp"), opts.Resolve.Addr(addr))
+			if err != nil {
+				return nil, err
+			}
+			return qt.DialEarly(ctx, raddr, tlsCfg, cfg)
+		}
+	}
+	defer transport.Close()
+
+	cfg.Client = &http.Client{Transport: transport}
+	if !cluster.Coordinating() {
+		cfg.Progress = opts.Meter(cfg.MaxConcurrency())
+	}
+	cfg.Metrics = opts.ServeMetrics("bench_http3", info)
+	defer cfg.Metrics.Close()
+	cfg.Raw = opts.RawLog()
+	prof := opts.Profile()
+	server := opts.SampleServer()
+	stop := cfg.Progress.Start(info, opts.Progress)
+	runs, err := cluster.Run(cfg, info)
+	stop()
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	server.Stop()
+	if err := cfg.Raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	results := cfg.Reports("bench_http3", runs)
+	cfg.Summarize(info, runs)
+	cluster.Annotate(results)
+	opts.Resolve.Annotate(info, results)
+	cli.MarkInterrupted(cfg.Context, results)
+	cfg.Raw.Annotate(info, results)
+	server.Annotate(info, results)
+	prof.Annotate(info, results, httpload.Sent(runs))
+	if err := report.Write(os.Stdout, opts.Format, results...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,215 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/cli"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/progress"
+	"benchmarks/internal/rawlog"
+	"benchmarks/internal/report"
+	"benchmarks/internal/sse"
+)
+
+type counters struct {
+	events    atomic.Int64 // events delivered
+	connects  atomic.Int64 // streams opened successfully
+	failures  atomic.Int64 // dials and non-SSE responses
+	dropped   atomic.Int64 // streams that ended before the run did
+	reconnect bool
+	meter     *progress.Meter
+	exported  *metrics.Metrics
+}
+
+// fail counts a stream that could not be opened.
+func (c *counters) fail(id int) {
+	c.failures.Add(1)
+	c.meter.Error(id)
+	c.exported.Observe(0, "connect")
+}
+
+// subscribe opens one stream and reads it until ctx ends or the server
+// hangs up, recording its time to first event, or its failure, in raw. It returns the last event id and reconnection time, starting from
+// lastID and retry, so a reconnect can resume where the stream left off,
+// and whether the stream was dropped.
+func subscribe(ctx context.Context, client *http.Client, url, lastID string, retry time.Duration, id int, c *counters, firstEvent *hdr.Histogram, raw *rawlog.Buffer) (string, time.Duration, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		c.fail(id)
+		return lastID, retry, false
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.fail(id)
+			raw.Record(start, time.Since(start), false)
+		}
+		return lastID, retry, false
+	}
+	defer resp.Body.Close()
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK || mediaType != "text/event-stream" {
+		c.fail(id)
+		raw.Record(start, time.Since(start), false)
+		return lastID, retry, false
+	}
+	c.connects.Add(1)
+
+	r := sse.NewReader(resp.Body)
+	r.LastID, r.Retry = lastID, retry
+	first := true
+	for {
+		_, err := r.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return r.LastID, r.Retry, false
+			}
+			c.dropped.Add(1)
+			c.meter.Error(id)
+			c.exported.Observe(0, "dropped")
+			return r.LastID, r.Retry, true
+		}
+		if first {
+			d := time.Since(start)
+			firstEvent.Record(d)
+			raw.Record(start, d, true)
+			first = false
+		}
+		c.events.Add(1)
+		c.meter.Event(id)
+		c.exported.Event()
+	}
+}
+
+func worker(ctx context.Context, client *http.Client, url string, id int, wg *sync.WaitGroup, c *counters, firstEvent *hdr.Histogram, raw *rawlog.Buffer) {
+	defer wg.Done()
+	defer raw.Flush()
+
+	lastID, retry := "", sse.DefaultRetry
+	for ctx.Err() == nil {
+		var dropped bool
+		lastID, retry, dropped = subscribe(ctx, client, url, lastID, retry, id, c, firstEvent, raw)
+		if !dropped || !c.reconnect {
+			return
+		}
+		// Honour the server's retry hint like EventSource does
+		select {
+		case <-ctx.Done():
+		case <-time.After(retry):
+		}
+	}
+}
+
+func main() {
+	opts := cli.Options{
+		URL:         "http://localhost:8000/sse/time",
+		Concurrency: 100,
+		Duration:    10 * time.Second,
+		Timeout:     5 * time.Second,
+	}
+	reconnect := flag.Bool("reconnect", true, "reopen dropped streams after the server's retry time (3s until it sets one), resuming with Last-Event-ID")
+	cli.Parse(&opts)
+	url, concurrency, duration := opts.URL, opts.Concurrency, opts.Duration
+
+	info := opts.Format.Info()
+	fmt.Fprintf(info, "Benchmarking SSE endpoint at %s\n", url)
+	fmt.Fprintf(info, "Concurrency: %d subscribers\n", concurrency)
+	fmt.Fprintf(info, "Duration: %v\n", duration)
+	opts.Resolve.Describe(info)
+	fmt.Fprintln(info, "Starting benchmark...")
+	interrupt := cli.Interrupt(info)
+
+	// Streams stay open for the whole run, so -timeout bounds only the wait
+	// for response headers rather than the request as a whole
+	c := &counters{
+		reconnect: *reconnect,
+		meter:     opts.Meter(concurrency),
+		exported:  opts.ServeMetrics("bench_sse", info),
+	}
+	defer c.exported.Close()
+	var dialer net.Dialer
+	transport := &http.Transport{
+		DialContext:           c.meter.DialContext(opts.Resolve.Dial(&dialer)),
+		MaxIdleConns:          concurrency,
+		MaxIdleConnsPerHost:   concurrency,
+		ResponseHeaderTimeout: opts.Timeout,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	client := &http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	histograms := make([]*hdr.Histogram, concurrency)
+
+	ctx, cancel := context.WithTimeout(interrupt, duration)
+	defer cancel()
+	raw := opts.RawLog()
+	prof := opts.Profile()
+	server := opts.SampleServer()
+	stop := c.meter.Start(info, opts.Progress)
+	start := time.Now()
+
+	for i := 0; i < concurrency; i++ {
+		histograms[i] = hdr.New()
+		wg.Add(1)
+		go worker(ctx, client, url, i, &wg, c, histograms[i], raw.Worker(i))
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+	stop()
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	server.Stop()
+	if err := raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	fmt.Fprintf(info, "\nStreams opened: %d\n", c.connects.Load())
+	fmt.Fprintf(info, "Failed connects: %d\n", c.failures.Load())
+	fmt.Fprintf(info, "Dropped streams: %d\n", c.dropped.Load())
+	fmt.Fprintln(info, "Latency below is time to first event per stream.")
+
+	// Requests counts events, so Requests/sec reads as events/sec
+	result := report.NewResult("bench_sse", url, concurrency, elapsed, c.events.Load(), c.failures.Load()+c.dropped.Load(), hdr.Merged(histograms))
+	result.Config = map[string]string{
+		"duration":  duration.String(),
+		"timeout":   opts.Timeout.String(),
+		"reconnect": strconv.FormatBool(*reconnect),
+		"connects":  strconv.FormatInt(c.connects.Load(), 10),
+		"failures":  strconv.FormatInt(c.failures.Load(), 10),
+		"dropped":   strconv.FormatInt(c.dropped.Load(), 10),
+	}
+	opts.Resolve.Annotate(info, []report.Result{result})
+	cli.MarkInterrupted(interrupt, []report.Result{result})
+	raw.Annotate(info, []report.Result{result})
+	server.Annotate(info, []report.Result{result})
+	prof.Annotate(info, []report.Result{result}, result.Requests+result.Errors)
+	if err := report.Write(os.Stdout, opts.Format, result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
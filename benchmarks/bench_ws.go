@@ -0,0 +1,389 @@
+//go:build ignore
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/cli"
+	"benchmarks/internal/connstat"
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/progress"
+	"benchmarks/internal/rawlog"
+	"benchmarks/internal/report"
+	"benchmarks/internal/ws"
+)
+
+// stampMarker prefixes the send time embedded in fan-out messages. Receivers
+// search for it anywhere in the frame, so servers that wrap or prefix the
+// relayed text (FasterAPI's demo chat room does) still yield latencies.
+var stampMarker = []byte("@ts=")
+
+type settings struct {
+	url      string
+	duration time.Duration
+	timeout  time.Duration
+	size     int
+	skip     int
+	tls      *tls.Config
+	dial     connstat.DialFunc
+	meter    *progress.Meter
+	exported *metrics.Metrics
+	raw      *rawlog.Writer
+
+	// interrupt ends the run early; blocked reads and writes then fail
+	// and are not counted
+	interrupt context.Context
+}
+
+func dial(s *settings) (*ws.Conn, error) {
+	ctx := s.interrupt
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	c, err := ws.DialWith(ctx, s.dial, s.url, nil, s.tls)
+	if err != nil {
+		return nil, err
+	}
+	// Discard greetings so they are not mistaken for echoes
+	for i := 0; i < s.skip; i++ {
+		if s.timeout > 0 {
+			c.SetDeadline(time.Now().Add(s.timeout))
+		}
+		if _, _, err := c.ReadMessage(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// stopOnInterrupt unblocks conn's reads and writes once the run is
+// interrupted. The returned func releases it.
+func (s *settings) stopOnInterrupt(conn *ws.Conn) func() bool {
+	return context.AfterFunc(s.interrupt, func() { conn.SetDeadline(time.Unix(1, 0)) })
+}
+
+// echoWorker sends one message at a time and waits for its reply, recording
+// the round trip like bench_echo does for raw TCP.
+func echoWorker(s *settings, id int, wg *sync.WaitGroup, counter *atomic.Int64, errs errclass.Counts, latency *hdr.Histogram) {
+	defer wg.Done()
+
+	conn, err := dial(s)
+	if err != nil {
+		if s.interrupt.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Connection error: %v\n", err)
+			s.fail(errs, id, err)
+		}
+		return
+	}
+	defer conn.Close()
+	defer s.stopOnInterrupt(conn)()
+	raw := s.raw.Worker(id)
+	defer raw.Flush()
+
+	message := bytes.Repeat([]byte{'x'}, s.size)
+
+	start := time.Now()
+	for time.Since(start) < s.duration && s.interrupt.Err() == nil {
+		reqStart := time.Now()
+		if s.timeout > 0 {
+			conn.SetDeadline(reqStart.Add(s.timeout))
+		}
+		s.exported.Begin()
+		err := conn.WriteMessage(ws.OpText, message)
+		if err == nil {
+			_, _, err = conn.ReadMessage()
+		}
+		d := time.Since(reqStart)
+		if err != nil && s.interrupt.Err() != nil {
+			// Cut short by the interrupt, not the server's failure
+			s.exported.End(d, classify(err))
+			return
+		}
+		raw.Record(reqStart, d, err == nil)
+		if err != nil {
+			s.exported.End(d, classify(err))
+			errs[classify(err)]++
+			s.meter.Error(id)
+			return
+		}
+		s.exported.End(d, "")
+		counter.Add(1)
+		latency.Record(d)
+		s.meter.Record(id, d)
+	}
+}
+
+// fanoutReader counts every stamped message delivered to one subscriber and
+// records how long it took to arrive from the sender.
+func fanoutReader(conn *ws.Conn, s *settings, id int, end time.Time, counter *atomic.Int64, errs errclass.Counts, latency *hdr.Histogram) {
+	raw := s.raw.Worker(id)
+	defer raw.Flush()
+	conn.SetDeadline(end)
+	defer s.stopOnInterrupt(conn)()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			// Hitting the end-of-run deadline, or the one the
+			// interrupt sets, is how readers stop
+			if !errors.Is(err, os.ErrDeadlineExceeded) && s.interrupt.Err() == nil {
+				s.fail(errs, id, err)
+			}
+			return
+		}
+		i := bytes.Index(msg, stampMarker)
+		if i < 0 {
+			continue
+		}
+		digits := msg[i+len(stampMarker):]
+		if j := bytes.IndexByte(digits, ';'); j >= 0 {
+			digits = digits[:j]
+		}
+		sent, err := strconv.ParseInt(string(digits), 10, 64)
+		if err != nil {
+			continue
+		}
+		d := time.Duration(time.Now().UnixNano() - sent)
+		raw.Record(time.Unix(0, sent), d, true)
+		counter.Add(1)
+		latency.Record(d)
+		s.meter.Record(id, d)
+		s.exported.Observe(d, "")
+	}
+}
+
+// fanoutSender publishes a stamped message every interval until end.
+// Writes share the reader's deadline of end, so a stalled one ends with
+// the run.
+func fanoutSender(conn *ws.Conn, s *settings, id int, interval time.Duration, end time.Time, sent *atomic.Int64, errs errclass.Counts) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	done := time.NewTimer(time.Until(end))
+	defer done.Stop()
+	buf := make([]byte, 0, s.size+32)
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-done.C:
+			return
+		case <-s.interrupt.Done():
+			return
+		}
+		if !now.Before(end) {
+			return
+		}
+		buf = append(buf[:0], stampMarker...)
+		buf = strconv.AppendInt(buf, time.Now().UnixNano(), 10)
+		buf = append(buf, ';')
+		for len(buf) < s.size {
+			buf = append(buf, 'x')
+		}
+		if err := conn.WriteMessage(ws.OpText, buf); err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) && s.interrupt.Err() == nil {
+				s.fail(errs, id, err)
+			}
+			return
+		}
+		sent.Add(1)
+	}
+}
+
+// fail records a failure that happened outside a timed round trip.
+func (s *settings) fail(errs errclass.Counts, id int, err error) {
+	class := classify(err)
+	errs[class]++
+	s.meter.Error(id)
+	s.exported.Observe(0, class)
+}
+
+// classify extends errclass with the close handshake, which is how a
+// WebSocket server usually reports that it gave up on a connection.
+func classify(err error) string {
+	if errors.Is(err, ws.ErrClosed) {
+		return "ws_closed"
+	}
+	return errclass.Of(err)
+}
+
+func main() {
+	opts := cli.Options{
+		URL:         "ws://localhost:8000/ws/echo",
+		Concurrency: 100,
+		Duration:    10 * time.Second,
+		Timeout:     5 * time.Second,
+	}
+	mode := flag.String("mode", "echo", "echo (request/reply per connection) or fanout (broadcast to every connection)")
+	size := flag.Int("size", 64, "message size in bytes")
+	skip := flag.Int("skip", 1, "server messages to discard after connecting (FasterAPI's demo endpoints send a greeting)")
+	senders := flag.Int("senders", 1, "fanout: connections that also publish messages")
+	interval := flag.Duration("interval", 100*time.Millisecond, "fanout: delay between messages from each sender")
+	insecure := flag.Bool("insecure", true, "skip certificate verification for wss:// (FasterAPI's bundled certs are self-signed)")
+	cli.Parse(&opts)
+
+	switch {
+	case *mode != "echo" && *mode != "fanout":
+		cli.Check(fmt.Errorf("-mode must be echo or fanout, got %q", *mode))
+	case *size < 0:
+		cli.Check(errors.New("-size must not be negative"))
+	case *mode == "fanout" && (*senders < 1 || *senders > opts.Concurrency):
+		cli.Check(errors.New("-senders must be between 1 and -c"))
+	case *mode == "fanout" && *interval <= 0:
+		cli.Check(errors.New("-interval must be positive"))
+	}
+
+	s := &settings{
+		url:      opts.URL,
+		duration: opts.Duration,
+		timeout:  opts.Timeout,
+		size:     *size,
+		skip:     *skip,
+		tls:      &tls.Config{InsecureSkipVerify: *insecure},
+		dial:     opts.Resolve.Dial(&net.Dialer{}),
+		meter:    opts.Meter(opts.Concurrency),
+		exported: opts.ServeMetrics("bench_ws", opts.Format.Info()),
+	}
+	defer s.exported.Close()
+	concurrency := opts.Concurrency
+
+	info := opts.Format.Info()
+	fmt.Fprintf(info, "Benchmarking WebSocket server at %s\n", s.url)
+	fmt.Fprintf(info, "Mode: %s\n", *mode)
+	fmt.Fprintf(info, "Concurrency: %d connections\n", concurrency)
+	if *mode == "fanout" {
+		fmt.Fprintf(info, "Senders: %d, one message every %v\n", *senders, *interval)
+	}
+	fmt.Fprintf(info, "Message size: %d bytes\n", s.size)
+	fmt.Fprintf(info, "Duration: %v\n", s.duration)
+	opts.Resolve.Describe(info)
+	fmt.Fprintln(info, "Starting benchmark...")
+	s.interrupt = cli.Interrupt(info)
+
+	var counter, sent atomic.Int64
+	var wg sync.WaitGroup
+	histograms := make([]*hdr.Histogram, concurrency)
+	// Fan-out senders write from their own goroutines, so they get their
+	// own tallies alongside the per-connection ones
+	classes := make([]errclass.Counts, 2*concurrency)
+	for i := range histograms {
+		histograms[i] = hdr.New()
+	}
+	for i := range classes {
+		classes[i] = make(errclass.Counts)
+	}
+
+	var start time.Time
+	var stop func()
+	s.raw = opts.RawLog()
+	prof := opts.Profile()
+	server := opts.SampleServer()
+	if *mode == "echo" {
+		stop = s.meter.Start(info, opts.Progress)
+		start = time.Now()
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go echoWorker(s, i, &wg, &counter, classes[i], histograms[i])
+		}
+		wg.Wait()
+	} else {
+		// Subscribe everyone before the clock starts so early broadcasts
+		// are not lost to connections still handshaking
+		conns := make([]*ws.Conn, concurrency)
+		for i := range conns {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				conn, err := dial(s)
+				if err != nil {
+					if s.interrupt.Err() == nil {
+						fmt.Fprintf(os.Stderr, "Connection error: %v\n", err)
+						s.fail(classes[i], i, err)
+					}
+					return
+				}
+				conns[i] = conn
+			}(i)
+		}
+		wg.Wait()
+
+		stop = s.meter.Start(info, opts.Progress)
+		start = time.Now()
+		end := start.Add(s.duration)
+		live := 0
+		for i, conn := range conns {
+			if conn == nil {
+				continue
+			}
+			defer conn.Close()
+			wg.Add(1)
+			go func(i int, conn *ws.Conn) {
+				defer wg.Done()
+				fanoutReader(conn, s, i, end, &counter, classes[i], histograms[i])
+			}(i, conn)
+			if live < *senders {
+				live++
+				wg.Add(1)
+				go func(i int, conn *ws.Conn) {
+					defer wg.Done()
+					fanoutSender(conn, s, i, *interval, end, &sent, classes[concurrency+i])
+				}(i, conn)
+			}
+		}
+		wg.Wait()
+	}
+	elapsed := time.Since(start)
+	stop()
+	if err := prof.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	server.Stop()
+	if err := s.raw.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := opts.WriteTimeline(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	errs := errclass.Merged(classes)
+	result := report.NewResult("bench_ws", s.url, concurrency, elapsed, counter.Load(), errs.Total(), hdr.Merged(histograms))
+	result.ErrorClasses = errs
+	result.Workers = report.NewFairness(hdr.Counts(histograms))
+	result.Config = map[string]string{
+		"mode":     *mode,
+		"duration": s.duration.String(),
+		"timeout":  s.timeout.String(),
+		"size":     strconv.Itoa(s.size),
+	}
+	if *mode == "fanout" {
+		result.Config["senders"] = strconv.Itoa(*senders)
+		result.Config["interval"] = interval.String()
+		result.Config["sent"] = strconv.FormatInt(sent.Load(), 10)
+		fmt.Fprintf(info, "\nMessages published: %d (a full broadcast delivers %d)\n", sent.Load(), sent.Load()*int64(concurrency))
+	}
+	opts.Resolve.Annotate(info, []report.Result{result})
+	cli.MarkInterrupted(s.interrupt, []report.Result{result})
+	s.raw.Annotate(info, []report.Result{result})
+	server.Annotate(info, []report.Result{result})
+	prof.Annotate(info, []report.Result{result}, result.Requests+result.Errors)
+	if err := report.Write(os.Stdout, opts.Format, result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build ignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"benchmarks/internal/cli"
+	"benchmarks/internal/compare"
+	"benchmarks/internal/report"
+)
+
+func main() {
+	th := compare.DefaultThresholds
+	flag.Float64Var(&th.RPS, "rps", th.RPS, "largest tolerated requests/sec drop in percent (negative = report only)")
+	flag.Float64Var(&th.P50, "p50", th.P50, "largest tolerated p50 latency rise in percent (negative = report only)")
+	flag.Float64Var(&th.P90, "p90", th.P90, "largest tolerated p90 latency rise in percent (negative = report only)")
+	flag.Float64Var(&th.P99, "p99", th.P99, "largest tolerated p99 latency rise in percent (negative = report only)")
+	flag.Float64Var(&th.P999, "p999", th.P999, "largest tolerated p99.9 latency rise in percent (negative = report only)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: go run benchcmp.go [flags] baseline.json candidate.json")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	base, err := report.ReadFile(flag.Arg(0))
+	cli.Check(err)
+	cand, err := report.ReadFile(flag.Arg(1))
+	cli.Check(err)
+
+	rows := compare.Compare(base, cand, th)
+	if err := compare.Write(os.Stdout, rows); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	regressions := 0
+	for _, r := range rows {
+		if r.Regressed() {
+			regressions++
+		}
+	}
+	if regressions > 0 {
+		fmt.Fprintf(os.Stderr, "%d benchmark(s) regressed\n", regressions)
+		os.Exit(1)
+	}
+}
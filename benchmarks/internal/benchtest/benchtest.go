@@ -0,0 +1,83 @@
+// Package benchtest runs the load engines under go test -bench, so that
+// benchstat can track client changes across commits alongside the
+// standalone tools.
+//
+// FasterAPI itself is not a Go program, so the fixtures here stand in for
+// it in-process: they answer the same hello and echo workloads with as
+// little work as possible, which keeps the numbers about the client.
+//
+// Each load benchmark runs exactly b.N successful requests through the
+// engine, so ns/op is the wall time per request across all workers, and
+// reports req/s and latency percentiles from that run besides.
+package benchtest
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"benchmarks/internal/report"
+)
+
+// Concurrency is the default worker count of a load benchmark.
+const Concurrency = 16
+
+// Hello is the body every fixture HTTP response carries.
+const Hello = "Hello, World!"
+
+// HTTPServer starts a server answering every request with Hello over
+// HTTP/1.1 and, with prior knowledge, cleartext HTTP/2. It is closed when
+// the benchmark ends.
+func HTTPServer(tb testing.TB) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, Hello)
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	tb.Cleanup(srv.Close)
+	return srv
+}
+
+// EchoServer starts a TCP server that writes back every byte it reads and
+// returns its address. It is closed when the benchmark ends.
+func EchoServer(tb testing.TB) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// Report adds figures from r to the benchmark's own, failing it if the
+// run completed nothing or saw errors.
+func Report(b *testing.B, r report.Result) {
+	b.Helper()
+	if r.Errors > 0 {
+		b.Fatalf("%d errors: %s", r.Errors, r.ErrorClasses)
+	}
+	if r.Requests == 0 || r.RPS == 0 {
+		b.Fatal("no requests completed")
+	}
+	b.ReportMetric(r.RPS, "req/s")
+	b.ReportMetric(float64(r.Latency.P50.Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(r.Latency.P99.Nanoseconds()), "p99-ns")
+}
@@ -0,0 +1,300 @@
+// Package cli is the flag layer shared by the benchmark tools, so every
+// binary accepts the same -url, -c, -d, -timeout, -format, -progress,
+// -timeline, -metrics, -raw-out, client profiling, server sampling and
+// dialing (-resolve, -4, -6) options.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/progress"
+	"benchmarks/internal/rawlog"
+	"benchmarks/internal/report"
+	"benchmarks/internal/resolve"
+	"benchmarks/internal/selfprof"
+	"benchmarks/internal/srvstat"
+)
+
+// Options holds the common benchmark settings. The values present when
+// Register is called become the flag defaults.
+type Options struct {
+	URL         string
+	Concurrency int
+	Duration    time.Duration
+	Timeout     time.Duration
+	Format      report.Format
+	Progress    time.Duration // print rolling stats this often; 0 disables
+	Metrics     string        // serve Prometheus metrics on this address
+	RawOut      string        // write every request's start and latency here
+	Timeline    string        // write per-second statistics here, or "-" for a table
+
+	// Resolve shapes dialing: -resolve overrides, the address family and
+	// happy eyeballs
+	Resolve resolve.Options
+
+	// Client self-profiling: pprof output paths, and whether to report
+	// CPU use and allocations per request without writing profiles
+	CPUProfile  string
+	MemProfile  string
+	ClientStats bool
+
+	// Server sampling: the server's pid on this host, and an expvar or
+	// Prometheus URL it serves
+	ServerPID   int
+	ServerStats string
+
+	// Levels, when non-nil, turns -c into a comma-separated list of
+	// concurrency levels for tools that sweep several of them.
+	Levels IntList
+
+	timeline *progress.Timeline // shared by every meter under -timeline
+}
+
+// Register adds the common flags to fs.
+func (o *Options) Register(fs *flag.FlagSet) {
+	if o.Format == "" {
+		o.Format = report.Text
+	}
+	fs.StringVar(&o.URL, "url", o.URL, "target URL or host:port")
+	if o.Levels != nil {
+		fs.Var(&o.Levels, "c", "comma-separated concurrency levels")
+	} else {
+		fs.IntVar(&o.Concurrency, "c", o.Concurrency, "number of concurrent workers")
+	}
+	fs.DurationVar(&o.Duration, "d", o.Duration, "benchmark duration")
+	fs.DurationVar(&o.Timeout, "timeout", o.Timeout, "per-request timeout")
+	fs.DurationVar(&o.Progress, "progress", o.Progress, "print interval stats (rate, p99, errors, open connections) this often, e.g. 5s")
+	fs.StringVar(&o.Timeline, "timeline", o.Timeline, "write rate, errors and latency percentiles for every second of the run to this CSV file, or print them as a table with -timeline - (rounds -progress to whole seconds)")
+	fs.StringVar(&o.Metrics, "metrics", o.Metrics, "serve client-side Prometheus metrics at this address, e.g. :9090")
+	o.Resolve.Register(fs)
+	fs.StringVar(&o.RawOut, "raw-out", o.RawOut, "write every request's start time and latency to this file: compact binary, or CSV for .csv and gzipped CSV for .csv.gz")
+	fs.StringVar(&o.CPUProfile, "cpuprofile", o.CPUProfile, "write a CPU profile of the client to this file")
+	fs.StringVar(&o.MemProfile, "memprofile", o.MemProfile, "write a heap profile of the client to this file")
+	fs.BoolVar(&o.ClientStats, "client-stats", o.ClientStats, "report the client's own CPU use and allocations per request (implied by the profile flags)")
+	fs.IntVar(&o.ServerPID, "server-pid", o.ServerPID, "sample CPU, RSS and threads of the server with this pid from /proc during the run")
+	fs.StringVar(&o.ServerStats, "server-stats", o.ServerStats, "sample the server's expvar (/debug/vars) or Prometheus (/metrics) URL during the run for CPU, RSS, goroutines and GC")
+	fs.Func("format", "output format: text, json or csv (default text)", func(s string) error {
+		f, err := report.ParseFormat(s)
+		o.Format = f
+		return err
+	})
+}
+
+// Validate reports option combinations that cannot run.
+func (o *Options) Validate() error {
+	switch {
+	case o.URL == "":
+		return errors.New("-url is required")
+	case o.Levels == nil && o.Concurrency < 1:
+		return errors.New("-c must be at least 1")
+	case o.Levels != nil && len(o.Levels) == 0:
+		return errors.New("-c needs at least one level")
+	case o.Duration <= 0:
+		return errors.New("-d must be positive")
+	case o.Timeout < 0:
+		return errors.New("-timeout must not be negative")
+	case o.Progress < 0:
+		return errors.New("-progress must not be negative")
+	case o.ServerPID < 0:
+		return errors.New("-server-pid must not be negative")
+	}
+	return nil
+}
+
+// Meter returns a progress meter for workers, or nil when neither
+// -progress nor -timeline is set. Under -timeline every meter feeds the
+// same timeline.
+func (o *Options) Meter(workers int) *progress.Meter {
+	if o.Progress <= 0 && o.Timeline == "" {
+		return nil
+	}
+	m := progress.New(workers)
+	if o.Timeline != "" {
+		if o.timeline == nil {
+			o.timeline = &progress.Timeline{}
+		}
+		m.Timeline = o.timeline
+	}
+	return m
+}
+
+// WriteTimeline writes what the meters collected under -timeline: a table
+// on info for "-", otherwise a CSV file. It does nothing without
+// -timeline, or when no meter ran.
+func (o *Options) WriteTimeline(info io.Writer) error {
+	if o.timeline == nil {
+		return nil
+	}
+	if o.Timeline == "-" {
+		o.timeline.WriteTable(info)
+		return nil
+	}
+	f, err := os.Create(o.Timeline)
+	if err != nil {
+		return err
+	}
+	if err := o.timeline.WriteCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(info, "\nTimeline: %d seconds written to %s\n", len(o.timeline.Buckets), o.Timeline)
+	return f.Close()
+}
+
+// ServeMetrics starts the -metrics endpoint for tool and reports where it
+// listens on info, or returns nil when -metrics is unset. It exits like
+// Check if the address cannot be bound.
+func (o *Options) ServeMetrics(tool string, info io.Writer) *metrics.Metrics {
+	if o.Metrics == "" {
+		return nil
+	}
+	m, err := metrics.Serve(o.Metrics, tool)
+	Check(err)
+	fmt.Fprintf(info, "Metrics: http://%s/metrics\n", m.Addr())
+	return m
+}
+
+// RawLog creates the -raw-out file, or returns nil when -raw-out is unset.
+// It exits like Check if the file cannot be created.
+func (o *Options) RawLog() *rawlog.Writer {
+	if o.RawOut == "" {
+		return nil
+	}
+	w, err := rawlog.Create(o.RawOut)
+	Check(err)
+	return w
+}
+
+// Profile starts profiling the client, or returns nil when no profiling
+// flag was given. It exits like Check if a profile cannot be created.
+func (o *Options) Profile() *selfprof.Session {
+	if o.CPUProfile == "" && o.MemProfile == "" && !o.ClientStats {
+		return nil
+	}
+	s, err := selfprof.Start(o.CPUProfile, o.MemProfile)
+	Check(err)
+	return s
+}
+
+// SampleServer starts sampling the server's resource use, or returns nil
+// when neither -server-pid nor -server-stats was given. It exits like Check
+// if the first sample cannot be taken.
+func (o *Options) SampleServer() *srvstat.Sampler {
+	if o.ServerPID == 0 && o.ServerStats == "" {
+		return nil
+	}
+	s, err := srvstat.Start(o.ServerPID, o.ServerStats)
+	Check(err)
+	return s
+}
+
+// Addr returns the URL as a host:port pair for raw socket tools, dropping
+// any scheme and trailing path.
+func (o *Options) Addr() string {
+	addr := o.URL
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		addr = addr[:i]
+	}
+	return addr
+}
+
+// Parse registers o on the process command line, parses os.Args and exits
+// with status 2 on invalid input. Tool-specific flags must be defined on
+// flag.CommandLine before calling it.
+func Parse(o *Options) {
+	o.Register(flag.CommandLine)
+	flag.Parse()
+	if err := o.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.CommandLine.Name(), err)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// Interrupt returns a context that the first SIGINT or SIGTERM cancels, so
+// a tool can stop its workers and still report what they measured. It
+// notes the interruption on info; a second signal exits at once.
+func Interrupt(info io.Writer) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		fmt.Fprintln(info, "\nInterrupted: stopping workers to report partial results (Ctrl-C again to quit)")
+		cancel()
+		<-sig
+		os.Exit(130)
+	}()
+	return ctx
+}
+
+// MarkInterrupted records in the config of results that ctx was
+// cancelled, so a partial run is not mistaken for a complete one.
+func MarkInterrupted(ctx context.Context, results []report.Result) {
+	if ctx.Err() == nil {
+		return
+	}
+	for _, r := range results {
+		r.Config["interrupted"] = "true"
+	}
+}
+
+// Given reports whether the flag name was set on the command line, for
+// tools where another stop condition replaces a default such as -d.
+func Given(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == name
+	})
+	return given
+}
+
+// Check exits with status 2 if a tool-specific validation failed after
+// Parse.
+func Check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.CommandLine.Name(), err)
+		os.Exit(2)
+	}
+}
+
+// IntList is a flag.Value holding comma-separated positive integers.
+type IntList []int
+
+func (l *IntList) String() string {
+	if l == nil {
+		return ""
+	}
+	parts := make([]string, len(*l))
+	for i, v := range *l {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *IntList) Set(s string) error {
+	var out IntList
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || v < 1 {
+			return fmt.Errorf("invalid level %q", part)
+		}
+		out = append(out, v)
+	}
+	*l = out
+	return nil
+}
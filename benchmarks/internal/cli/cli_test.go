@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+func parse(t *testing.T, o *Options, args ...string) error {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return o.Validate()
+}
+
+func TestDefaultsAndOverrides(t *testing.T) {
+	o := Options{URL: "http://localhost:8070/", Concurrency: 100, Duration: 10 * time.Second, Timeout: 5 * time.Second}
+	if err := parse(t, &o, "-url", "http://host:9000/x", "-c", "8", "-d", "2s", "-format", "json"); err != nil {
+		t.Fatal(err)
+	}
+	if o.URL != "http://host:9000/x" || o.Concurrency != 8 || o.Duration != 2*time.Second || o.Timeout != 5*time.Second || o.Format != report.JSON {
+		t.Errorf("unexpected options %+v", o)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, args := range [][]string{
+		{"-c", "0"},
+		{"-d", "0s"},
+		{"-url", ""},
+		{"-format", "xml"},
+	} {
+		o := Options{URL: "localhost:1", Concurrency: 1, Duration: time.Second}
+		if err := parse(t, &o, args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	o := Options{URL: "localhost:1", Duration: time.Second, Levels: IntList{50, 100}}
+	if err := parse(t, &o, "-c", "1, 2,4"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o.Levels, IntList{1, 2, 4}) {
+		t.Errorf("levels = %v", o.Levels)
+	}
+	if err := parse(t, &Options{URL: "x", Duration: time.Second, Levels: IntList{}}, "-c", "1,x"); err == nil {
+		t.Error("expected an error for a non-numeric level")
+	}
+}
+
+func TestAddr(t *testing.T) {
+	for in, want := range map[string]string{
+		"localhost:8070":        "localhost:8070",
+		"tcp://localhost:8070":  "localhost:8070",
+		"http://[::1]:8080/foo": "[::1]:8080",
+	} {
+		o := Options{URL: in}
+		if got := o.Addr(); got != want {
+			t.Errorf("Addr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package compare diffs a candidate set of benchmark results against a
+// baseline, so CI can fail a change that costs throughput or latency.
+package compare
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+// Thresholds are the largest tolerated changes in percent: a drop for RPS,
+// a rise for the latency percentiles. A negative threshold reports the
+// metric without checking it.
+type Thresholds struct {
+	RPS  float64
+	P50  float64
+	P90  float64
+	P99  float64
+	P999 float64
+}
+
+// DefaultThresholds tolerate the run-to-run noise of a quiet machine.
+var DefaultThresholds = Thresholds{RPS: 5, P50: 10, P90: -1, P99: 20, P999: -1}
+
+// ReportOnly checks nothing, for side-by-side views where neither side is
+// a baseline.
+var ReportOnly = Thresholds{RPS: -1, P50: -1, P90: -1, P99: -1, P999: -1}
+
+// Metric is one compared statistic.
+type Metric struct {
+	Name      string
+	Base, New float64
+	Latency   bool    // Base and New are nanoseconds
+	Delta     float64 // percent change from Base
+	Limit     float64 // negative when unchecked
+	Regressed bool
+}
+
+// Row pairs a baseline result with the candidate result for the same
+// benchmark. One of the two may be missing.
+type Row struct {
+	Key       string
+	Base, New *report.Result
+	Metrics   []Metric
+}
+
+// Regressed reports whether the candidate is worse than allowed, or lost a
+// benchmark the baseline had.
+func (r Row) Regressed() bool {
+	if r.New == nil {
+		return true
+	}
+	for _, m := range r.Metrics {
+		if m.Regressed {
+			return true
+		}
+	}
+	return false
+}
+
+// Key identifies a result across runs. The target is left out so a
+// baseline recorded against one host still matches a candidate run
+// against another.
+func Key(r report.Result) string {
+	k := r.Tool
+	if r.Label != "" {
+		k += " [" + r.Label + "]"
+	}
+	return k + " c=" + strconv.Itoa(r.Concurrency)
+}
+
+// Compare matches candidate results to baseline results by Key, in
+// baseline order followed by any results only the candidate has.
+func Compare(base, cand []report.Result, th Thresholds) []Row {
+	byKey := map[string]*report.Result{}
+	candKeys := keys(cand)
+	for i, k := range candKeys {
+		byKey[k] = &cand[i]
+	}
+
+	var rows []Row
+	seen := map[string]bool{}
+	for i, k := range keys(base) {
+		row := Row{Key: k, Base: &base[i], New: byKey[k]}
+		if row.New != nil {
+			row.Metrics = metrics(*row.Base, *row.New, th)
+		}
+		seen[k] = true
+		rows = append(rows, row)
+	}
+	for i, k := range candKeys {
+		if !seen[k] {
+			rows = append(rows, Row{Key: k, New: &cand[i]})
+		}
+	}
+	return rows
+}
+
+// keys returns the Key of each result, numbering repeats so that a file
+// holding several runs of one benchmark still pairs them up in order.
+func keys(results []report.Result) []string {
+	out := make([]string, len(results))
+	count := map[string]int{}
+	for i, r := range results {
+		k := Key(r)
+		count[k]++
+		if n := count[k]; n > 1 {
+			k += " #" + strconv.Itoa(n)
+		}
+		out[i] = k
+	}
+	return out
+}
+
+func metrics(b, n report.Result, th Thresholds) []Metric {
+	out := []Metric{compare("rps", b.RPS, n.RPS, false, th.RPS)}
+	if b.Latency.Count == 0 || n.Latency.Count == 0 {
+		return out
+	}
+	for _, p := range []struct {
+		name      string
+		base, new time.Duration
+		limit     float64
+	}{
+		{"p50", b.Latency.P50, n.Latency.P50, th.P50},
+		{"p90", b.Latency.P90, n.Latency.P90, th.P90},
+		{"p99", b.Latency.P99, n.Latency.P99, th.P99},
+		{"p99.9", b.Latency.P999, n.Latency.P999, th.P999},
+	} {
+		out = append(out, compare(p.name, float64(p.base), float64(p.new), true, p.limit))
+	}
+	return out
+}
+
+func compare(name string, base, new float64, latency bool, limit float64) Metric {
+	m := Metric{Name: name, Base: base, New: new, Latency: latency, Limit: limit}
+	switch {
+	case base != 0:
+		m.Delta = (new - base) / base * 100
+	case new != 0:
+		m.Delta = math.Inf(1)
+	}
+	if limit >= 0 {
+		if latency {
+			m.Regressed = m.Delta > limit
+		} else {
+			m.Regressed = -m.Delta > limit
+		}
+	}
+	return m
+}
+
+// Write prints rows as an aligned table, marking every regression.
+func Write(w io.Writer, rows []Row) error {
+	return WriteColumns(w, rows, "baseline", "candidate")
+}
+
+// WriteColumns is Write with the two value columns named base and cand.
+func WriteColumns(w io.Writer, rows []Row, base, cand string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "benchmark\tmetric\t%s\t%s\tdelta\t\n", base, cand)
+	for _, r := range rows {
+		switch {
+		case r.New == nil:
+			fmt.Fprintf(tw, "%s\t\t\t\t\tREGRESSION: missing from candidate\n", r.Key)
+			continue
+		case r.Base == nil:
+			fmt.Fprintf(tw, "%s\t\t\t\t\tnew\n", r.Key)
+			continue
+		}
+		for i, m := range r.Metrics {
+			key := r.Key
+			if i > 0 {
+				key = ""
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%+.1f%%\t", key, m.Name, m.format(m.Base), m.format(m.New), m.Delta)
+			if m.Regressed {
+				fmt.Fprintf(tw, "REGRESSION (limit %s%g%%)", m.sign(), m.Limit)
+			}
+			fmt.Fprintln(tw)
+		}
+	}
+	return tw.Flush()
+}
+
+func (m Metric) format(v float64) string {
+	if m.Latency {
+		return time.Duration(v).String()
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+// sign is the direction in which the metric gets worse.
+func (m Metric) sign() string {
+	if m.Latency {
+		return "+"
+	}
+	return "-"
+}
@@ -0,0 +1,69 @@
+package compare
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/report"
+)
+
+func result(label string, rps float64, p99 time.Duration) report.Result {
+	return report.Result{
+		Tool:        "bench_http",
+		Label:       label,
+		Concurrency: 100,
+		RPS:         rps,
+		Latency:     hdr.Summary{Count: 1, P50: time.Millisecond, P90: time.Millisecond, P99: p99, P999: p99},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := []report.Result{result("", 1000, 10*time.Millisecond), result("route /", 500, 10*time.Millisecond), result("gone", 1, time.Millisecond)}
+	cand := []report.Result{result("route /", 480, 13*time.Millisecond), result("", 960, 10*time.Millisecond), result("added", 1, time.Millisecond)}
+
+	rows := Compare(base, cand, DefaultThresholds)
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(rows))
+	}
+	if rows[0].Regressed() {
+		t.Errorf("a 4%% RPS drop exceeded the 5%% threshold: %+v", rows[0].Metrics)
+	}
+	if !rows[1].Regressed() || !rows[1].Metrics[3].Regressed {
+		t.Errorf("a 30%% p99 rise was not flagged: %+v", rows[1].Metrics)
+	}
+	if !rows[2].Regressed() || rows[2].New != nil {
+		t.Error("a benchmark missing from the candidate was not flagged")
+	}
+	if rows[3].Regressed() || rows[3].Base != nil {
+		t.Error("a benchmark new in the candidate was flagged")
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "REGRESSION"); n != 2 {
+		t.Errorf("table marks %d regressions, want 2:\n%s", n, buf.String())
+	}
+}
+
+func TestThresholdDisabled(t *testing.T) {
+	th := DefaultThresholds
+	th.RPS = -1
+	rows := Compare([]report.Result{result("", 1000, time.Millisecond)}, []report.Result{result("", 10, time.Millisecond)}, th)
+	if rows[0].Regressed() {
+		t.Error("a disabled threshold was checked")
+	}
+}
+
+func TestRepeatedKeys(t *testing.T) {
+	base := []report.Result{result("", 100, time.Millisecond), result("", 200, time.Millisecond)}
+	cand := []report.Result{result("", 100, time.Millisecond), result("", 100, time.Millisecond)}
+	rows := Compare(base, cand, DefaultThresholds)
+	if len(rows) != 2 || rows[0].Regressed() || !rows[1].Regressed() {
+		t.Errorf("repeated runs were not paired in order: %+v", rows)
+	}
+}
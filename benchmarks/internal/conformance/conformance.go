@@ -0,0 +1,535 @@
+// Package conformance runs request/response test cases, loaded from YAML,
+// against a server, so covering a new endpoint means adding a case to a
+// file rather than changing the client:
+//
+//	cases:
+//	  - name: Simple GET /
+//	    path: /
+//	    expect:
+//	      status: 200
+//	      protocol: HTTP/2.0
+//	  - name: Create item
+//	    method: POST
+//	    path: /items
+//	    headers:
+//	      Content-Type: application/json
+//	    body: '{"name":"widget"}'
+//	    expect:
+//	      status: 201
+//	      contains: widget
+//	  - name: 10 concurrent requests
+//	    path: /
+//	    concurrent: 10
+//
+// Trailers sent with a request and expected in the response are listed
+// the same way as headers:
+//
+//	cases:
+//	  - name: Checksum trailer
+//	    path: /upload
+//	    body: '{"part":1}'
+//	    trailers:
+//	      X-Checksum: abc123
+//	    expect:
+//	      trailers:
+//	        X-Checksum: abc123
+//
+// A case can send a generated binary body of body_size bytes instead of a
+// literal one, and expect the server to echo whatever it sent:
+//
+//	cases:
+//	  - name: 1 MiB upload
+//	    method: PUT
+//	    path: /echo
+//	    body_size: 1048576
+//	    expect:
+//	      echo: true
+//
+// Beyond the status, a case can require headers matching regular
+// expressions, values at paths into a JSON body, and a latency bound:
+//
+//	cases:
+//	  - name: Get item
+//	    path: /items/7
+//	    expect:
+//	      status: 200
+//	      headers_match:
+//	        Content-Type: ^application/json
+//	        ETag: '^"[0-9a-f]+"$'
+//	      json:
+//	        id: 7
+//	        tags.0: new
+//	        owner: {name: ada, admin: false}
+//	      max_latency: 50ms
+package conformance
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Suite is an ordered list of cases.
+type Suite struct {
+	Cases []Case `yaml:"cases"`
+}
+
+// Case is one request, or several identical ones sent at once, and what
+// the response must look like.
+type Case struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+
+	// BodySize, instead of Body, sends this many bytes of binary data
+	// covering every byte value, so that a body larger than the HTTP/2
+	// flow-control window needs no file.
+	BodySize int `yaml:"body_size"`
+
+	// Trailers are sent after the body, which then goes out without a
+	// length so that HTTP/1.1 can carry them in chunked encoding.
+	Trailers map[string]string `yaml:"trailers"`
+
+	// Concurrent sends this many copies of the request at once; every one
+	// of them must pass.
+	Concurrent int `yaml:"concurrent"`
+
+	// Serial keeps other cases from running alongside this one when the
+	// suite runs in parallel, for a case that depends on or changes state
+	// the others see.
+	Serial bool `yaml:"serial"`
+
+	Expect Expect `yaml:"expect"`
+}
+
+// Expect describes a passing response. Empty fields are not checked.
+type Expect struct {
+	// Status is the required status code; zero accepts any 2xx.
+	Status   int               `yaml:"status"`
+	Protocol string            `yaml:"protocol"` // e.g. HTTP/2.0
+	Headers  map[string]string `yaml:"headers"`  // exact values
+	Body     string            `yaml:"body"`     // the exact body
+	Contains string            `yaml:"contains"` // a substring of the body
+
+	// Echo requires the response body to be the request body.
+	Echo bool `yaml:"echo"`
+
+	// Trailers are required response trailers and their exact values.
+	Trailers map[string]string `yaml:"trailers"`
+
+	// HeadersMatch are required response headers and regular expressions
+	// their values must match; Parse compiles them.
+	HeadersMatch map[string]string `yaml:"headers_match"`
+	patterns     map[string]*regexp.Regexp
+
+	// JSON maps paths into a JSON body to the values found there. A path
+	// is a list of object keys and array indexes separated by dots, as in
+	// items.0.name; a value may be any YAML, and objects and arrays must
+	// match in full.
+	JSON map[string]any `yaml:"json"`
+
+	// MaxLatency fails each response taking longer, from sending the
+	// request to reading the end of the body.
+	MaxLatency time.Duration `yaml:"max_latency"`
+}
+
+//go:embed default.yaml
+var defaultSuite []byte
+
+// echoPlaceholder is the path of the built-in cases that need a route
+// echoing the request body.
+const echoPlaceholder = "{echo}"
+
+// Default returns the built-in smoke tests, used when no case file is
+// given. The cases sending a body go to echo, a path that answers with the
+// request body, and are left out if echo is empty.
+func Default(echo string) *Suite {
+	s, err := Parse(defaultSuite)
+	if err != nil {
+		panic(err)
+	}
+	cases := s.Cases[:0]
+	for _, c := range s.Cases {
+		if c.Path == echoPlaceholder {
+			if echo == "" {
+				continue
+			}
+			c.Path = echo
+		}
+		cases = append(cases, c)
+	}
+	s.Cases = cases
+	return s
+}
+
+// Load reads and validates a case file.
+func Load(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a YAML suite, filling in default names and
+// methods.
+func Parse(data []byte) (*Suite, error) {
+	var s Suite
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("conformance: %w", err)
+	}
+	if len(s.Cases) == 0 {
+		return nil, errors.New("conformance: no cases")
+	}
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if c.Path == "" {
+			return nil, fmt.Errorf("conformance: case %d has no path", i+1)
+		}
+		if c.BodySize < 0 || c.BodySize > 0 && c.Body != "" {
+			return nil, fmt.Errorf("conformance: case %d: body_size must be positive and excludes body", i+1)
+		}
+		if c.Method == "" {
+			c.Method = http.MethodGet
+			if c.Body != "" || c.BodySize > 0 || len(c.Trailers) > 0 {
+				c.Method = http.MethodPost
+			}
+		}
+		if c.Name == "" {
+			c.Name = c.Method + " " + c.Path
+		}
+		if c.Concurrent < 0 {
+			return nil, fmt.Errorf("conformance: case %q: negative concurrent", c.Name)
+		}
+		if err := c.Expect.compile(); err != nil {
+			return nil, fmt.Errorf("conformance: case %q: %w", c.Name, err)
+		}
+	}
+	return &s, nil
+}
+
+// Result is the outcome of one case.
+type Result struct {
+	Name    string
+	Err     error // nil if the case passed
+	Elapsed time.Duration
+
+	// Status, Protocol and Body describe the response of a single
+	// request, for the log.
+	Status   int
+	Protocol string
+	Body     []byte
+}
+
+// Passed reports whether the case passed.
+func (r Result) Passed() bool { return r.Err == nil }
+
+// Run sends the case's requests to base with client.
+func (c *Case) Run(ctx context.Context, client *http.Client, base *url.URL) (res Result) {
+	res.Name = c.Name
+	start := time.Now()
+	defer func() { res.Elapsed = time.Since(start) }()
+	if c.Concurrent <= 1 {
+		res.Status, res.Protocol, res.Body, res.Err = c.do(ctx, client, base)
+		return res
+	}
+	errs := make([]error, c.Concurrent)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, err := c.do(ctx, client, base); err != nil {
+				errs[i] = fmt.Errorf("request %d: %w", i+1, err)
+			}
+		}()
+	}
+	wg.Wait()
+	res.Err = errors.Join(errs...)
+	return res
+}
+
+// RunParallel runs the suite's cases against base, up to n at once, and
+// returns their results in suite order. Each case gets a client of its
+// own from newClient, closed once the case ends, so that no two share a
+// connection, and timeout bounds each case. A serial case waits for those
+// before it and holds back those after it. done, if set, is called with
+// each result in suite order as soon as it and those before it are in.
+func (s *Suite) RunParallel(ctx context.Context, n int, timeout time.Duration, newClient func() *http.Client, base *url.URL, done func(i int, res Result)) []Result {
+	results := make([]Result, len(s.Cases))
+	finished := make([]chan struct{}, len(s.Cases))
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+	run := func(i int) {
+		defer close(finished[i])
+		client := newClient()
+		defer client.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		results[i] = s.Cases[i].Run(ctx, client, base)
+	}
+	go func() {
+		sem := make(chan struct{}, max(n, 1))
+		var wg sync.WaitGroup
+		for i, c := range s.Cases {
+			if c.Serial {
+				wg.Wait()
+				run(i)
+				continue
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				run(i)
+			}()
+		}
+	}()
+	for i := range results {
+		<-finished[i]
+		if done != nil {
+			done(i, results[i])
+		}
+	}
+	return results
+}
+
+func (c *Case) do(ctx context.Context, client *http.Client, base *url.URL) (status int, proto string, body []byte, err error) {
+	ref, err := url.Parse(c.Path)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	sent, contentType := []byte(c.Body), "application/json"
+	if c.BodySize > 0 {
+		sent, contentType = Binary(c.BodySize), "application/octet-stream"
+	}
+	var reqBody io.Reader
+	if len(sent) > 0 {
+		reqBody = bytes.NewReader(sent)
+	}
+	req, err := http.NewRequestWithContext(ctx, c.Method, base.ResolveReference(ref).String(), reqBody)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if len(sent) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if h := c.Headers["Host"]; h != "" {
+		req.Host = h
+	}
+	if len(c.Trailers) > 0 {
+		// Hiding the length, even of an empty body, keeps the transport
+		// from sending the request without one
+		req.Body, req.GetBody, req.ContentLength = io.NopCloser(bytes.NewReader(sent)), nil, -1
+		req.Trailer = http.Header{}
+		for k, v := range c.Trailers {
+			req.Trailer.Set(k, v)
+		}
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, resp.Proto, body, fmt.Errorf("reading body: %w", err)
+	}
+	return resp.StatusCode, resp.Proto, body, c.Expect.check(resp, body, sent, time.Since(start))
+}
+
+// compile checks the patterns and JSON paths of e, keeping the compiled
+// patterns.
+func (e *Expect) compile() error {
+	e.patterns = make(map[string]*regexp.Regexp, len(e.HeadersMatch))
+	for k, pattern := range e.HeadersMatch {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("headers_match %s: %w", k, err)
+		}
+		e.patterns[k] = re
+	}
+	for path := range e.JSON {
+		if slices.Contains(strings.Split(path, "."), "") {
+			return fmt.Errorf("json path %q has an empty key", path)
+		}
+	}
+	if e.MaxLatency < 0 {
+		return errors.New("negative max_latency")
+	}
+	return nil
+}
+
+func (e *Expect) check(resp *http.Response, body, sent []byte, latency time.Duration) error {
+	switch {
+	case e.Status != 0 && resp.StatusCode != e.Status:
+		return fmt.Errorf("status %d, want %d", resp.StatusCode, e.Status)
+	case e.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299):
+		return fmt.Errorf("status %d", resp.StatusCode)
+	case e.Protocol != "" && resp.Proto != e.Protocol:
+		return fmt.Errorf("protocol %s, want %s", resp.Proto, e.Protocol)
+	}
+	for k, want := range e.Headers {
+		if got := resp.Header.Get(k); got != want {
+			return fmt.Errorf("header %s is %q, want %q", k, got, want)
+		}
+	}
+	for k, re := range e.patterns {
+		got, ok := resp.Header[http.CanonicalHeaderKey(k)]
+		switch {
+		case !ok:
+			return fmt.Errorf("header %s missing", k)
+		case !re.MatchString(got[0]):
+			return fmt.Errorf("header %s is %q, which does not match %s", k, got[0], re)
+		}
+	}
+	if e.Body != "" && string(body) != e.Body {
+		return fmt.Errorf("body %q, want %q", truncate(body), e.Body)
+	}
+	if e.Contains != "" && !bytes.Contains(body, []byte(e.Contains)) {
+		return fmt.Errorf("body %q does not contain %q", truncate(body), e.Contains)
+	}
+	if e.Echo && !bytes.Equal(body, sent) {
+		return echoMismatch(body, sent)
+	}
+	if len(e.JSON) > 0 {
+		if err := checkJSON(body, e.JSON); err != nil {
+			return err
+		}
+	}
+	if e.MaxLatency > 0 && latency > e.MaxLatency {
+		return fmt.Errorf("took %v, want under %v", latency.Round(time.Millisecond), e.MaxLatency)
+	}
+	// Trailers are only complete once the body has been read
+	for k, want := range e.Trailers {
+		got, ok := resp.Trailer[http.CanonicalHeaderKey(k)]
+		switch {
+		case !ok:
+			return fmt.Errorf("trailer %s missing", k)
+		case len(got) != 1 || got[0] != want:
+			return fmt.Errorf("trailer %s is %q, want %q", k, got, want)
+		}
+	}
+	return nil
+}
+
+// checkJSON compares the values at paths into a JSON body with those
+// wanted. Both sides go through encoding/json, so that YAML's integers
+// compare equal to JSON's numbers.
+func checkJSON(body []byte, want map[string]any) error {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("body %q is not JSON: %w", truncate(body), err)
+	}
+	// Map order is random; report the first failing path in a stable order
+	for _, path := range slices.Sorted(maps.Keys(want)) {
+		got, ok := lookup(doc, path)
+		if !ok {
+			return fmt.Errorf("json %s missing", path)
+		}
+		w, err := json.Marshal(want[path])
+		if err != nil {
+			return fmt.Errorf("json %s: %w", path, err)
+		}
+		var norm any
+		json.Unmarshal(w, &norm)
+		if !reflect.DeepEqual(got, norm) {
+			g, _ := json.Marshal(got)
+			return fmt.Errorf("json %s is %s, want %s", path, g, w)
+		}
+	}
+	return nil
+}
+
+// lookup follows a dotted path of object keys and array indexes.
+func lookup(doc any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]any:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			doc = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// echoMismatch says where an echoed body first differs from what was
+// sent, which tells a truncated body from a corrupted one.
+func echoMismatch(body, sent []byte) error {
+	n := min(len(body), len(sent))
+	i := 0
+	for i < n && body[i] == sent[i] {
+		i++
+	}
+	if i == n {
+		return fmt.Errorf("echoed %d bytes, sent %d", len(body), len(sent))
+	}
+	return fmt.Errorf("echo differs from the %d bytes sent at offset %d", len(sent), i)
+}
+
+// Binary returns n bytes of data covering every byte value. The pattern
+// repeats every 251 bytes, a prime, so a lost or repeated block of a
+// power-of-two size does not line up with it.
+func Binary(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// BodyText returns the response body for a log: as text if it is short
+// and printable, otherwise just its size.
+func (r Result) BodyText() string {
+	if len(r.Body) > 200 || !utf8.Valid(r.Body) {
+		return fmt.Sprintf("(%d bytes)", len(r.Body))
+	}
+	return string(r.Body)
+}
+
+// truncate keeps error messages readable for large bodies.
+func truncate(body []byte) string {
+	const limit = 200
+	if len(body) > limit {
+		return string(body[:limit]) + "..."
+	}
+	return string(body)
+}
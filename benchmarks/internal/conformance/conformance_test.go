@@ -0,0 +1,264 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`
+cases:
+  - path: /
+  - path: /items
+    body: '{}'
+    expect:
+      status: 201
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Cases[0].Name != "GET /" || s.Cases[1].Method != http.MethodPost {
+		t.Errorf("defaults not filled in: %+v", s.Cases)
+	}
+	for _, bad := range []string{"", "cases: []", "cases:\n  - name: x", "cases:\n  - path: /\n    expext: {}", "cases:\n  - path: /\n    body: x\n    body_size: 1",
+		"cases:\n  - path: /\n    expect:\n      headers_match: {X-A: '('}", "cases:\n  - path: /\n    expect:\n      json: {a..b: 1}"} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+	all, plain := Default("/mirror"), Default("")
+	if len(plain.Cases) == 0 || len(all.Cases) <= len(plain.Cases) {
+		t.Errorf("%d default cases with an echo path, %d without", len(all.Cases), len(plain.Cases))
+	}
+	for _, c := range all.Cases {
+		if c.Path != "/" && c.Path != "/json" && c.Path != "/mirror" {
+			t.Errorf("default case %q goes to %s", c.Name, c.Path)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/echo":
+			// HTTP/1 cannot stream the echo while the body is still arriving
+			b, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Content-Type", r.Header.Get("Content-Type"))
+			w.Write(b)
+			return
+		case "/trailers":
+			// Request trailers come back as response trailers
+			io.Copy(io.Discard, r.Body)
+			for k, v := range r.Trailer {
+				w.Header()[http.TrailerPrefix+k] = v
+			}
+			return
+		case "/items":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"items":[{"id":7,"price":2.5,"tags":["new"],"owner":{"name":"ada"}}]}`))
+			return
+		case "/short":
+			b, _ := io.ReadAll(r.Body)
+			w.Write(b[:len(b)-1])
+			return
+		}
+		w.Header().Set("X-Method", r.Method)
+		w.Write([]byte(`{"message":"Hello"}`))
+	}))
+	defer srv.Close()
+	base, _ := url.Parse(srv.URL)
+
+	s, err := Parse([]byte(`
+cases:
+  - name: ok
+    method: PUT
+    path: /json
+    body: x
+    concurrent: 4
+    expect:
+      headers:
+        X-Method: PUT
+      body: '{"message":"Hello"}'
+  - name: contains
+    path: /
+    expect:
+      contains: Hello
+      protocol: HTTP/1.1
+  - name: status
+    path: /missing
+  - name: protocol
+    path: /
+    expect:
+      protocol: HTTP/2.0
+  - name: body
+    path: /
+    expect:
+      contains: Goodbye
+  - name: binary echo
+    method: PUT
+    path: /echo
+    body_size: 100000
+    expect:
+      echo: true
+      headers:
+        X-Content-Type: application/octet-stream
+  - name: trailers
+    path: /trailers
+    trailers:
+      X-Checksum: abc123
+    expect:
+      trailers:
+        x-checksum: abc123
+  - name: missing trailer
+    path: /trailers
+    body: x
+    expect:
+      trailers:
+        X-Checksum: abc123
+  - name: truncated echo
+    path: /short
+    body_size: 1000
+    expect:
+      echo: true
+  - name: assertions
+    path: /items
+    expect:
+      headers_match:
+        content-type: ^application/json$
+      json:
+        items.0.id: 7
+        items.0.price: 2.5
+        items.0.tags: [new]
+        items.0.owner: {name: ada}
+      max_latency: 10s
+  - name: json value
+    path: /items
+    expect:
+      json:
+        items.0.owner.name: bob
+  - name: json path
+    path: /items
+    expect:
+      json:
+        items.1.id: 7
+  - name: header pattern
+    path: /
+    expect:
+      headers_match:
+        X-Method: ^POST$
+  - name: latency
+    path: /
+    expect:
+      max_latency: 1ns
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "", "status 404", "protocol HTTP/1.1", "does not contain", "", "", "trailer X-Checksum missing", "echoed 999 bytes, sent 1000",
+		"", `json items.0.owner.name is "ada", want "bob"`, "json items.1.id missing", "does not match ^POST$", "want under 1ns"}
+	for i, c := range s.Cases {
+		res := c.Run(context.Background(), srv.Client(), base)
+		switch {
+		case want[i] == "" && !res.Passed():
+			t.Errorf("%s: %v", c.Name, res.Err)
+		case want[i] != "" && (res.Passed() || !strings.Contains(res.Err.Error(), want[i])):
+			t.Errorf("%s: got %v, want an error containing %q", c.Name, res.Err, want[i])
+		}
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	var mu sync.Mutex
+	var running, peak int
+	conns := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		conns[r.RemoteAddr] = true
+		alone := running == 1
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if r.URL.Path == "/serial" && !alone {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer srv.Close()
+	base, _ := url.Parse(srv.URL)
+
+	var suite strings.Builder
+	suite.WriteString("cases:\n")
+	for i := range 9 {
+		if i == 4 {
+			suite.WriteString("  - path: /serial\n    serial: true\n")
+			continue
+		}
+		fmt.Fprintf(&suite, "  - path: /%d\n", i)
+	}
+	suite.WriteString("  - path: /missing\n    expect:\n      status: 404\n")
+	s, err := Parse([]byte(suite.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClient := func() *http.Client { return &http.Client{Transport: &http.Transport{}} }
+	var order []int
+	results := s.RunParallel(context.Background(), 4, time.Second, newClient, base, func(i int, _ Result) {
+		order = append(order, i)
+	})
+	for i, res := range results {
+		if res.Name != s.Cases[i].Name || res.Passed() != (i != 9) {
+			t.Errorf("result %d: %s %v", i, res.Name, res.Err)
+		}
+	}
+	if !slices.Equal(order, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Errorf("results reported in order %v", order)
+	}
+	if peak != 4 {
+		t.Errorf("%d cases ran at once, want 4", peak)
+	}
+	if len(conns) != len(s.Cases) {
+		t.Errorf("%d cases shared %d connections", len(s.Cases), len(conns))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	results := []Result{
+		{Name: "ok", Elapsed: time.Millisecond, Status: 200},
+		{Name: "bad # case", Err: errors.Join(errors.New("request 1: status 500"), errors.New("request 2: status 500"))},
+	}
+	want := map[Format][]string{
+		TAP:   {"1..2\n", "ok 1 - ok\n", "not ok 2 - bad \\# case\n", `message: "request 1: status 500\nrequest 2: status 500"`},
+		JUnit: {`<testsuite name="suite" tests="2" failures="1"`, `<failure message="request 1: status 500">`},
+		JSON:  {`{"name":"ok","passed":true,"elapsed_ns":1000000,"status":200}`, `"passed":false,"error":"request 1`},
+	}
+	for f, subs := range want {
+		var b strings.Builder
+		if err := Write(&b, f, "suite", results); err != nil {
+			t.Fatal(err)
+		}
+		for _, sub := range subs {
+			if !strings.Contains(b.String(), sub) {
+				t.Errorf("%s output lacks %q:\n%s", f, sub, b.String())
+			}
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat accepted xml")
+	}
+}
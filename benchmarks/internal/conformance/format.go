@@ -0,0 +1,142 @@
+package conformance
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format selects how results are written for CI.
+type Format string
+
+const (
+	Text  Format = "text"
+	JUnit Format = "junit"
+	TAP   Format = "tap"
+	JSON  Format = "json"
+)
+
+// ParseFormat validates a -format flag value.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case Text, JUnit, TAP, JSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (want text, junit, tap or json)", s)
+}
+
+// Info returns where progress should go: stdout for text output, stderr
+// otherwise so that stdout holds only the report.
+func (f Format) Info() io.Writer {
+	if f == Text {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// Write renders results in format f under the suite name. Text writes
+// nothing, since the runner prints as it goes. JSON output is one object
+// per line.
+func Write(w io.Writer, f Format, suite string, results []Result) error {
+	switch f {
+	case JUnit:
+		return writeJUnit(w, suite, results)
+	case TAP:
+		return writeTAP(w, results)
+	case JSON:
+		return writeJSON(w, results)
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, results []Result) error {
+	type record struct {
+		Name     string        `json:"name"`
+		Passed   bool          `json:"passed"`
+		Error    string        `json:"error,omitempty"`
+		Elapsed  time.Duration `json:"elapsed_ns"`
+		Status   int           `json:"status,omitempty"`
+		Protocol string        `json:"protocol,omitempty"`
+	}
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		rec := record{Name: r.Name, Passed: r.Passed(), Elapsed: r.Elapsed, Status: r.Status, Protocol: r.Protocol}
+		if r.Err != nil {
+			rec.Error = r.Err.Error()
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTAP writes TAP version 13, with the error of a failed test as a
+// YAML diagnostic block.
+func writeTAP(w io.Writer, results []Result) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", len(results))
+	for i, r := range results {
+		// '#' would start a directive
+		name := strings.ReplaceAll(r.Name, "#", `\#`)
+		if r.Passed() {
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, name)
+			continue
+		}
+		fmt.Fprintf(&b, "not ok %d - %s\n  ---\n  message: %s\n  ...\n", i+1, name, strconv.Quote(r.Err.Error()))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeJUnit writes the JUnit XML that CI systems such as Jenkins and
+// GitLab render as a test report.
+func writeJUnit(w io.Writer, suite string, results []Result) error {
+	type failure struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+	type testcase struct {
+		Name      string   `xml:"name,attr"`
+		Classname string   `xml:"classname,attr"`
+		Time      string   `xml:"time,attr"`
+		Failure   *failure `xml:"failure,omitempty"`
+	}
+	type testsuite struct {
+		XMLName  xml.Name   `xml:"testsuite"`
+		Name     string     `xml:"name,attr"`
+		Tests    int        `xml:"tests,attr"`
+		Failures int        `xml:"failures,attr"`
+		Time     string     `xml:"time,attr"`
+		Cases    []testcase `xml:"testcase"`
+	}
+	secs := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', 3, 64) }
+	ts := testsuite{Name: suite, Tests: len(results)}
+	var total time.Duration
+	for _, r := range results {
+		tc := testcase{Name: r.Name, Classname: suite, Time: secs(r.Elapsed)}
+		if r.Err != nil {
+			// Joined errors, one per failed request, go one per line
+			tc.Failure = &failure{Message: strings.SplitN(r.Err.Error(), "\n", 2)[0], Text: r.Err.Error()}
+			ts.Failures++
+		}
+		total += r.Elapsed
+		ts.Cases = append(ts.Cases, tc)
+	}
+	ts.Time = secs(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(ts); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,96 @@
+// Package connstat times connection setup, TCP connect and TLS handshake,
+// separately from the requests that follow. Together with a client that
+// opens a new connection per request it measures what the server's accept
+// loop and handshake cost, which keep-alive runs amortise away.
+package connstat
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/report"
+)
+
+// DialFunc matches net.Dialer.DialContext.
+type DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Stats records setup times. It is safe for concurrent use.
+type Stats struct {
+	Connect   *hdr.Histogram
+	Handshake *hdr.Histogram
+}
+
+// New returns empty stats.
+func New() *Stats {
+	return &Stats{Connect: hdr.New(), Handshake: hdr.New()}
+}
+
+// DialContext wraps dial so every successful connect is timed.
+func (s *Stats) DialContext(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		c, err := dial(ctx, network, addr)
+		if err == nil {
+			s.Connect.Record(time.Since(start))
+		}
+		return c, err
+	}
+}
+
+// DialTLSContext returns a dialer for http.Transport.DialTLSContext that
+// connects with dial, then times the handshake with cfg on its own.
+func (s *Stats) DialTLSContext(dial DialFunc, cfg *tls.Config) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		raw, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := cfg
+		if c.ServerName == "" {
+			c = cfg.Clone()
+			c.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn := tls.Client(raw, c)
+		start := time.Now()
+		if err := conn.HandshakeContext(ctx); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		s.Handshake.Record(time.Since(start))
+		return conn, nil
+	}
+}
+
+// Annotate prints the setup times to w and records their percentiles in
+// the config of every result, marking the results as run without
+// keep-alive.
+func (s *Stats) Annotate(w io.Writer, results []report.Result) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(w, "\nConnection setup (%d connections):\n", s.Connect.Count())
+	for _, phase := range []struct {
+		name string
+		h    *hdr.Histogram
+	}{{"connect", s.Connect}, {"tls_handshake", s.Handshake}} {
+		if phase.h.Count() == 0 {
+			continue
+		}
+		sum := phase.h.Summary()
+		fmt.Fprintf(w, "  %-14s p50=%v p99=%v max=%v\n", phase.name+":", sum.P50, sum.P99, sum.Max)
+		for _, r := range results {
+			r.Config[phase.name+"_p50"] = sum.P50.String()
+			r.Config[phase.name+"_p99"] = sum.P99.String()
+		}
+	}
+	for _, r := range results {
+		r.Config["keepalive"] = "false"
+		r.Config["connections"] = strconv.FormatInt(s.Connect.Count(), 10)
+	}
+}
@@ -0,0 +1,57 @@
+package connstat
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"benchmarks/internal/report"
+)
+
+func TestDialTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	s := New()
+	dial := s.DialContext((&net.Dialer{}).DialContext)
+	tr := &http.Transport{
+		DisableKeepAlives: true,
+		DialTLSContext:    s.DialTLSContext(dial, &tls.Config{InsecureSkipVerify: true}),
+	}
+	client := &http.Client{Transport: tr}
+	for range 3 {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if s.Connect.Count() != 3 || s.Handshake.Count() != 3 {
+		t.Errorf("timed %d connects and %d handshakes, want 3 each", s.Connect.Count(), s.Handshake.Count())
+	}
+
+	results := []report.Result{{Config: map[string]string{}}}
+	var buf bytes.Buffer
+	s.Annotate(&buf, results)
+	if !strings.Contains(buf.String(), "tls_handshake:") || results[0].Config["connections"] != "3" || results[0].Config["keepalive"] != "false" {
+		t.Errorf("annotated %v:\n%s", results[0].Config, buf.String())
+	}
+}
+
+func TestDialFailureNotTimed(t *testing.T) {
+	s := New()
+	dial := s.DialContext(func(context.Context, string, string) (net.Conn, error) {
+		return nil, &net.OpError{Op: "dial"}
+	})
+	if _, err := dial(context.Background(), "tcp", "x:1"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if s.Connect.Count() != 0 {
+		t.Error("failed dial was timed")
+	}
+}
@@ -0,0 +1,216 @@
+// Package distrib spreads one benchmark over several load generators when a
+// single machine cannot saturate the server. A coordinator waits for a fixed
+// number of workers to connect, hands each the job and a common start
+// delay, and collects their results so the tool can merge them into one
+// report.
+//
+// The protocol is newline-delimited JSON over TCP: the worker sends a
+// hello, the coordinator answers with an assignment, and the worker replies
+// with its result once the run is over. Jobs and results are opaque to this
+// package; the tool defines both.
+package distrib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// StartDelay is how long after the assignment every worker starts, so
+// that the slowest to receive it still starts with the rest.
+const StartDelay = 2 * time.Second
+
+// helloTimeout bounds how long a connected worker may take to identify
+// itself before the coordinator gives up on it.
+const helloTimeout = 10 * time.Second
+
+// Options selects the role of this process.
+type Options struct {
+	Coordinator string // listen here and coordinate Workers workers
+	Workers     int
+	Worker      string // connect to this coordinator as a worker
+}
+
+// Register adds -coordinator, -workers and -worker to fs.
+func (o *Options) Register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Coordinator, "coordinator", o.Coordinator, "coordinate a distributed run, listening for workers on this address, e.g. :7000")
+	fs.IntVar(&o.Workers, "workers", o.Workers, "number of workers the coordinator waits for")
+	fs.StringVar(&o.Worker, "worker", o.Worker, "run as a worker for the coordinator at this address")
+}
+
+// Validate reports role combinations that cannot run.
+func (o *Options) Validate() error {
+	switch {
+	case o.Coordinator != "" && o.Worker != "":
+		return errors.New("-coordinator and -worker are mutually exclusive")
+	case o.Coordinator != "" && o.Workers < 1:
+		return errors.New("-coordinator needs -workers of at least 1")
+	case o.Coordinator == "" && o.Workers != 0:
+		return errors.New("-workers requires -coordinator")
+	}
+	return nil
+}
+
+type hello struct {
+	Host string `json:"host"`
+}
+
+type assignment struct {
+	Index int             `json:"index"`
+	Total int             `json:"total"`
+	Delay time.Duration   `json:"delay"`
+	Job   json.RawMessage `json:"job"`
+}
+
+type reply struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// peer is one connection speaking the protocol.
+type peer struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	host string
+}
+
+func newPeer(conn net.Conn) *peer {
+	return &peer{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(bufio.NewReader(conn))}
+}
+
+// Coordinator accepts workers and collects their results.
+type Coordinator struct {
+	ln    net.Listener
+	peers []*peer
+}
+
+// Listen binds the coordinator's address. The listener is bound before
+// any worker is awaited so a busy port is reported straight away.
+func Listen(addr string) (*Coordinator, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Coordinator{ln: ln}, nil
+}
+
+// Addr returns the address workers should join.
+func (c *Coordinator) Addr() string {
+	return c.ln.Addr().String()
+}
+
+// Close stops listening and drops every worker.
+func (c *Coordinator) Close() error {
+	for _, p := range c.peers {
+		p.conn.Close()
+	}
+	return c.ln.Close()
+}
+
+// Run waits for n workers, gives each the job built by job(i, n) and
+// returns their results in joining order once all have finished. A worker
+// that fails or disconnects fails the whole run, since a merged report
+// missing one generator's load would be misleading.
+func (c *Coordinator) Run(n int, job func(i, n int) any, log io.Writer) ([]json.RawMessage, error) {
+	for len(c.peers) < n {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			return nil, err
+		}
+		p := newPeer(conn)
+		var h hello
+		conn.SetReadDeadline(time.Now().Add(helloTimeout))
+		if err := p.dec.Decode(&h); err != nil {
+			conn.Close()
+			fmt.Fprintf(log, "Ignoring %s: %v\n", conn.RemoteAddr(), err)
+			continue
+		}
+		conn.SetReadDeadline(time.Time{})
+		p.host = h.Host
+		c.peers = append(c.peers, p)
+		fmt.Fprintf(log, "Worker %d/%d joined: %s (%s)\n", len(c.peers), n, h.Host, conn.RemoteAddr())
+	}
+
+	for i, p := range c.peers {
+		data, err := json.Marshal(job(i, n))
+		if err != nil {
+			return nil, err
+		}
+		if err := p.enc.Encode(assignment{Index: i, Total: n, Delay: StartDelay, Job: data}); err != nil {
+			return nil, fmt.Errorf("worker %s: %w", p.host, err)
+		}
+	}
+	fmt.Fprintf(log, "All workers joined; starting in %v\n", StartDelay)
+
+	results := make([]json.RawMessage, n)
+	for i, p := range c.peers {
+		var r reply
+		if err := p.dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("worker %s: %w", p.host, err)
+		}
+		if r.Error != "" {
+			return nil, fmt.Errorf("worker %s: %s", p.host, r.Error)
+		}
+		results[i] = r.Result
+	}
+	return results, nil
+}
+
+// Session is a worker's connection to its coordinator.
+type Session struct {
+	// Index and Total identify this worker among all that joined.
+	Index, Total int
+
+	p     *peer
+	start time.Time
+}
+
+// Join connects to the coordinator at addr, waits until every worker has
+// joined and decodes the assigned job into job.
+func Join(addr string, job any) (*Session, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := newPeer(conn)
+	host, _ := os.Hostname()
+	if err := p.enc.Encode(hello{Host: host}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var a assignment
+	if err := p.dec.Decode(&a); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("coordinator: %w", err)
+	}
+	if err := json.Unmarshal(a.Job, job); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("coordinator sent a bad job: %w", err)
+	}
+	return &Session{Index: a.Index, Total: a.Total, p: p, start: time.Now().Add(a.Delay)}, nil
+}
+
+// Wait blocks until the common start time.
+func (s *Session) Wait() {
+	time.Sleep(time.Until(s.start))
+}
+
+// Finish sends the worker's result, or err if the run failed, and closes
+// the session.
+func (s *Session) Finish(result any, err error) error {
+	defer s.p.conn.Close()
+	var r reply
+	if err != nil {
+		r.Error = err.Error()
+	} else if r.Result, err = json.Marshal(result); err != nil {
+		r.Error = err.Error()
+	}
+	return s.p.enc.Encode(r)
+}
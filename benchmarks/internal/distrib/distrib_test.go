@@ -0,0 +1,95 @@
+package distrib
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	c, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const n = 3
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var job struct{ Rate float64 }
+			s, err := Join(c.Addr(), &job)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// Skip the start delay; this test only checks the exchange
+			if err := s.Finish(map[string]any{"index": s.Index, "rate": job.Rate}, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	raw, err := c.Run(n, func(i, n int) any {
+		return map[string]float64{"Rate": 300 / float64(n)}
+	}, io.Discard)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int]bool{}
+	for _, data := range raw {
+		var r struct {
+			Index int
+			Rate  float64
+		}
+		if err := json.Unmarshal(data, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Rate != 100 {
+			t.Errorf("worker %d got rate %v, want 100", r.Index, r.Rate)
+		}
+		seen[r.Index] = true
+	}
+	if len(seen) != n {
+		t.Errorf("worker indexes %v are not distinct", seen)
+	}
+}
+
+func TestRunWorkerError(t *testing.T) {
+	c, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go func() {
+		var job struct{}
+		if s, err := Join(c.Addr(), &job); err == nil {
+			s.Finish(nil, errors.New("out of sockets"))
+		}
+	}()
+	_, err = c.Run(1, func(int, int) any { return struct{}{} }, io.Discard)
+	if err == nil {
+		t.Fatal("worker failure was not reported")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, o := range []Options{
+		{Coordinator: ":7000", Worker: "host:7000", Workers: 2},
+		{Coordinator: ":7000"},
+		{Workers: 2},
+	} {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded", o)
+		}
+	}
+	if err := (&Options{Coordinator: ":7000", Workers: 2}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,584 @@
+// Package echoload is the echo load engine shared by bench_echo and
+// bench_echo_stress: each worker writes a newline-terminated message and
+// reads the echo back over TCP or a Unix socket.
+package echoload
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/connstat"
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/payload"
+	"benchmarks/internal/progress"
+	"benchmarks/internal/rawlog"
+	"benchmarks/internal/retry"
+)
+
+// DefaultSize is the message size used when Config.Size is zero.
+const DefaultSize = 6
+
+// ClassMismatch is the error class of an echo that differs from what was
+// sent.
+const ClassMismatch = "mismatch"
+
+// Config describes one load run.
+type Config struct {
+	// Dial opens every connection; nil uses Socket's dialer.
+	Dial connstat.DialFunc
+
+	// Socket tunes each connection. A custom Dial should come from
+	// Socket.Dialer for the buffer sizes to apply.
+	Socket Socket
+
+	// Network defaults to tcp. udp switches to datagram echo, which
+	// counts lost and reordered datagrams; see Result.
+	Network string
+	Addr    string
+
+	Concurrency int
+	Duration    time.Duration
+
+	// Requests, when positive, ends the run after exactly this many
+	// successful round trips. Duration then only caps the run, and zero
+	// means no cap.
+	Requests int64
+
+	// Timeout bounds each round trip, and each dial unless
+	// Socket.ConnectTimeout is set; zero means none.
+	Timeout time.Duration
+
+	// Churn opens a new connection for every round trip.
+	Churn bool
+
+	// Retry redials connections that fail to open for a transient reason.
+	// With retries on, a worker whose persistent connection breaks also
+	// opens a new one rather than stopping; the round trip that broke it
+	// still counts as an error.
+	Retry retry.Policy
+
+	// Pipeline keeps this many messages in flight per connection. Zero
+	// and one both mean one at a time.
+	Pipeline int
+
+	// Size is the mean message size, newline included, drawn from the
+	// payload distribution Dist (default fixed).
+	Size int
+	Dist string
+
+	// Seed, when non-zero, makes every worker draw the same sizes from
+	// run to run.
+	Seed uint64
+
+	// Check compares every echo with the message that was sent.
+	// Differences count as ClassMismatch errors.
+	Check bool
+
+	// Progress, when non-nil, receives every round trip as it completes.
+	// It must have a slot for each of Concurrency workers.
+	Progress *progress.Meter
+
+	// Metrics, when non-nil, exports in-flight round trips, latency and
+	// error classes for Prometheus.
+	Metrics *metrics.Metrics
+
+	// Raw, when non-nil, receives every round trip.
+	Raw *rawlog.Writer
+
+	// Context, when non-nil, ends the run early once cancelled, as on
+	// Ctrl-C: round trips in flight are abandoned and not counted.
+	Context context.Context
+}
+
+// Validate reports a configuration Run cannot execute.
+func (c *Config) Validate() error {
+	c.defaults()
+	if _, err := payload.NewSizer(c.Dist, c.Size, nil); err != nil {
+		return err
+	}
+	switch {
+	case c.Concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case c.Pipeline < 0:
+		return errors.New("pipeline must not be negative")
+	case c.Pipeline > 1 && c.Churn:
+		return errors.New("-pipeline needs persistent connections; drop -no-keepalive")
+	}
+	if err := c.Socket.validate(); err != nil {
+		return err
+	}
+	if c.udp() {
+		if err := c.validateUDP(); err != nil {
+			return err
+		}
+	}
+	return c.Retry.Validate()
+}
+
+func (c *Config) defaults() {
+	if c.Network == "" {
+		c.Network = "tcp"
+	}
+	if c.Size == 0 {
+		c.Size = DefaultSize
+	}
+	if c.Dist == "" {
+		c.Dist = payload.Fixed
+	}
+	if c.Dial == nil {
+		c.Dial = c.Socket.Dialer().DialContext
+	}
+	if c.Context == nil {
+		c.Context = context.Background()
+	}
+}
+
+// Result is the outcome of one Run.
+type Result struct {
+	Concurrency int
+	Elapsed     time.Duration
+	Requests    int64
+	Errors      int64
+	Latency     *hdr.Histogram
+
+	// ErrorClasses breaks Errors down by cause.
+	ErrorClasses errclass.Counts
+
+	// Echoed is the payload bytes that made the round trip.
+	Echoed int64
+
+	// Retried is how many of Requests needed a redial to succeed, and
+	// Retries how many redials were made in all.
+	Retried int64
+	Retries int64
+
+	// Workers is the successful round trips of each worker.
+	Workers []int64
+
+	// Datagram echo only: Sent is the datagrams sent, of which Lost got no
+	// echo within Timeout (also counted as ClassLost errors), Reordered
+	// were echoed after a later one, and Late is echoes that arrived after
+	// their datagram was declared lost, or twice.
+	Sent      int64
+	Lost      int64
+	Reordered int64
+	Late      int64
+}
+
+// run is the state shared by the workers of one Run call.
+type run struct {
+	cfg      Config
+	ctx      context.Context
+	deadline time.Time
+	counter  atomic.Int64
+	echoed   atomic.Int64
+	retried  atomic.Int64
+	retries  atomic.Int64
+
+	sent      atomic.Int64
+	reordered atomic.Int64
+	late      atomic.Int64
+
+	// left is the successes still to go under Config.Requests. A worker
+	// takes one before each round trip and returns it if the round trip
+	// fails.
+	left atomic.Int64
+}
+
+// Run executes the workload and blocks until it finishes. cfg must have
+// passed Validate.
+func Run(cfg Config) Result {
+	cfg.defaults()
+	r := &run{cfg: cfg, ctx: cfg.Context}
+	histograms := make([]*hdr.Histogram, cfg.Concurrency)
+	classes := make([]errclass.Counts, cfg.Concurrency)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	r.deadline = start.Add(cfg.Duration)
+	if cfg.Requests > 0 && cfg.Duration <= 0 {
+		r.deadline = start.Add(math.MaxInt64)
+	}
+	r.left.Store(cfg.Requests)
+	for i := 0; i < cfg.Concurrency; i++ {
+		histograms[i] = hdr.New()
+		classes[i] = make(errclass.Counts)
+		wg.Add(1)
+		w := &worker{run: r, id: i, errs: classes[i], latency: histograms[i], raw: cfg.Raw.Worker(i)}
+		go w.loop(&wg)
+	}
+	wg.Wait()
+
+	errs := errclass.Merged(classes)
+	return Result{
+		Concurrency:  cfg.Concurrency,
+		Elapsed:      time.Since(start),
+		Requests:     r.counter.Load(),
+		Errors:       errs.Total(),
+		Latency:      hdr.Merged(histograms),
+		ErrorClasses: errs,
+		Echoed:       r.echoed.Load(),
+		Retried:      r.retried.Load(),
+		Retries:      r.retries.Load(),
+		Sent:         r.sent.Load(),
+		Lost:         errs[ClassLost],
+		Reordered:    r.reordered.Load(),
+		Late:         r.late.Load(),
+		Workers:      hdr.Counts(histograms),
+	}
+}
+
+// messages returns a worker's size source and send and receive buffers
+// large enough for any size it draws.
+func (r *run) messages(id int) (*payload.Sizer, *payload.Buffer, []byte) {
+	sizer, _ := payload.NewSizer(r.cfg.Dist, r.cfg.Size, payload.NewRand(r.cfg.Seed, id)) // checked by Validate
+	return sizer, payload.NewBuffer(sizer.Max()), make([]byte, sizer.Max())
+}
+
+// running reports whether the run has neither reached its deadline nor
+// been interrupted.
+func (r *run) running() bool {
+	return time.Now().Before(r.deadline) && r.ctx.Err() == nil
+}
+
+func (r *run) connect() (net.Conn, error) {
+	ctx, cancel := r.ctx, context.CancelFunc(func() {})
+	timeout := r.cfg.Timeout
+	if r.cfg.Socket.ConnectTimeout > 0 {
+		timeout = r.cfg.Socket.ConnectTimeout
+	}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	conn, err := r.cfg.Dial(ctx, r.cfg.Network, r.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	r.cfg.Socket.apply(conn)
+	return r.cfg.Progress.Conn(interruptible(r.ctx, conn)), nil
+}
+
+// stoppable is a connection whose blocked reads and writes return once the
+// run is interrupted, and whose deadlines can no longer be pushed back.
+type stoppable struct {
+	net.Conn
+	stop func() bool
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+func interruptible(ctx context.Context, conn net.Conn) net.Conn {
+	if ctx.Done() == nil {
+		return conn
+	}
+	c := &stoppable{Conn: conn}
+	c.stop = context.AfterFunc(ctx, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.stopped = true
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	return c
+}
+
+func (c *stoppable) setDeadline(set func(time.Time) error, t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	return set(t)
+}
+
+func (c *stoppable) SetDeadline(t time.Time) error {
+	return c.setDeadline(c.Conn.SetDeadline, t)
+}
+
+func (c *stoppable) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(c.Conn.SetReadDeadline, t)
+}
+
+func (c *stoppable) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(c.Conn.SetWriteDeadline, t)
+}
+
+func (c *stoppable) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// dial connects under the retry policy and reports the retries it took.
+func (r *run) dial() (net.Conn, int, error) {
+	var conn net.Conn
+	var err error
+	_, retries := r.cfg.Retry.Do(r.deadline, func() string {
+		if conn, err = r.connect(); err != nil {
+			return errclass.Of(err)
+		}
+		return ""
+	})
+	r.retries.Add(int64(retries))
+	return conn, retries, err
+}
+
+// take claims one of the successes left under Config.Requests, or
+// reports false once none are left.
+func (r *run) take() bool {
+	if r.cfg.Requests <= 0 {
+		return true
+	}
+	if r.left.Add(-1) >= 0 {
+		return true
+	}
+	r.left.Add(1)
+	return false
+}
+
+// mismatched reports whether Check is on and echo differs from the
+// message that was sent.
+func (r *run) mismatched(echo []byte) bool {
+	return r.cfg.Check && !payload.Valid(echo)
+}
+
+// worker is the state of one connection's worth of load.
+type worker struct {
+	*run
+	id      int
+	errs    errclass.Counts
+	latency *hdr.Histogram
+	raw     *rawlog.Buffer
+}
+
+// fail records a round trip, begun at start, that failed after Begin,
+// unless the interrupt cut it short.
+func (w *worker) fail(start time.Time, err error) {
+	if w.ctx.Err() != nil {
+		w.cfg.Metrics.End(0, errclass.Of(err))
+		return
+	}
+	w.reject(start, errclass.Of(err))
+}
+
+// reject records a failed round trip by class.
+func (w *worker) reject(start time.Time, class string) {
+	if w.cfg.Requests > 0 {
+		w.left.Add(1)
+	}
+	w.errs[class]++
+	w.cfg.Progress.Error(w.id)
+	w.cfg.Metrics.End(0, class)
+	w.raw.Record(start, time.Since(start), false)
+}
+
+func (w *worker) success(start time.Time, d time.Duration, n int) {
+	w.cfg.Metrics.End(d, "")
+	w.echoed.Add(int64(n))
+	w.counter.Add(1)
+	w.latency.Record(d)
+	w.cfg.Progress.Record(w.id, d)
+	w.raw.Record(start, d, true)
+}
+
+// complete records a round trip whose echo arrived after d, retried
+// reporting whether its connection took a redial.
+func (w *worker) complete(start time.Time, d time.Duration, echo []byte, retried bool) {
+	if w.mismatched(echo) {
+		w.reject(start, ClassMismatch)
+		return
+	}
+	w.success(start, d, len(echo))
+	if retried {
+		w.retried.Add(1)
+	}
+}
+
+func (w *worker) loop(wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer w.raw.Flush()
+	switch {
+	case w.cfg.udp():
+		w.datagrams()
+		return
+	case w.cfg.Churn:
+		w.churn()
+		return
+	}
+	for conn := w.open(); conn != nil; conn = w.open() {
+		var broken bool
+		if w.cfg.Pipeline > 1 {
+			broken = w.pipelined(conn)
+		} else {
+			broken = w.sequential(conn)
+		}
+		conn.Close()
+		if !broken || !w.cfg.Retry.Enabled() {
+			return
+		}
+	}
+}
+
+// open dials a persistent connection, or returns nil and records the
+// failure against no round trip.
+func (w *worker) open() net.Conn {
+	conn, _, err := w.dial()
+	if err != nil {
+		if w.ctx.Err() != nil {
+			return nil
+		}
+		class := errclass.Of(err)
+		w.errs[class]++
+		w.cfg.Progress.Error(w.id)
+		w.cfg.Metrics.Observe(0, class)
+		return nil
+	}
+	return conn
+}
+
+// roundTrip writes msg to conn and reads its echo into echo, which must be
+// as long.
+func (w *worker) roundTrip(conn net.Conn, start time.Time, msg, echo []byte) error {
+	if w.cfg.Timeout > 0 {
+		conn.SetDeadline(start.Add(w.cfg.Timeout))
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	// Read the whole echo, however the server splits it
+	_, err := io.ReadFull(conn, echo)
+	return err
+}
+
+// sequential sends one message at a time on conn until the run ends, and
+// reports whether it stopped early because conn broke.
+func (w *worker) sequential(conn net.Conn) bool {
+	sizer, msgs, buffer := w.messages(w.id)
+	for w.running() && w.take() {
+		size := sizer.Next()
+		reqStart := time.Now()
+		w.cfg.Metrics.Begin()
+		if err := w.roundTrip(conn, reqStart, msgs.Message(size), buffer[:size]); err != nil {
+			w.fail(reqStart, err)
+			return true
+		}
+		w.complete(reqStart, time.Since(reqStart), buffer[:size], false)
+	}
+	return false
+}
+
+// churn opens a new connection for every round trip, timing the dial with
+// the round trip.
+func (w *worker) churn() {
+	sizer, msgs, buffer := w.messages(w.id)
+	for w.running() && w.take() {
+		size := sizer.Next()
+		reqStart := time.Now()
+		w.cfg.Metrics.Begin()
+		conn, retries, err := w.dial()
+		if err != nil {
+			w.fail(reqStart, err)
+			continue
+		}
+		err = w.roundTrip(conn, reqStart, msgs.Message(size), buffer[:size])
+		d := time.Since(reqStart)
+		conn.Close()
+		if err != nil {
+			w.fail(reqStart, err)
+			continue
+		}
+		w.complete(reqStart, d, buffer[:size], retries > 0)
+	}
+}
+
+// inFlight is a pipelined message awaiting its echo.
+type inFlight struct {
+	sent time.Time
+	size int
+}
+
+// pipelined keeps up to Pipeline messages in flight on conn, writing from
+// this goroutine and matching echoes in order from another. Each echo is
+// read by the length of its message, so replies the server coalesced or
+// split are still told apart. It reports whether it stopped early because
+// conn broke.
+func (w *worker) pipelined(conn net.Conn) bool {
+	cfg := &w.cfg
+	slots := make(chan struct{}, cfg.Pipeline)
+	sent := make(chan inFlight, cfg.Pipeline)
+	done := make(chan struct{})
+	sizer, msgs, buffer := w.messages(w.id)
+
+	var readErr error
+	go func() {
+		defer close(done)
+		br := bufio.NewReader(conn)
+		for m := range sent {
+			if cfg.Timeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(cfg.Timeout))
+			}
+			if _, err := io.ReadFull(br, buffer[:m.size]); err != nil {
+				readErr = err
+				w.fail(m.sent, err)
+				return
+			}
+			if w.mismatched(buffer[:m.size]) {
+				w.reject(m.sent, ClassMismatch)
+			} else {
+				w.success(m.sent, time.Since(m.sent), m.size)
+			}
+			<-slots
+		}
+	}()
+
+	// The reader owns the worker's counters until it exits, so a write
+	// failure is recorded after it has
+	var writeErr error
+	var writeStart time.Time
+loop:
+	for w.running() {
+		select {
+		case slots <- struct{}{}:
+		case <-done:
+			break loop
+		}
+		// An echo still in flight may yet fail and hand its success back
+		for !w.take() {
+			if len(slots) == 1 || w.ctx.Err() != nil {
+				break loop
+			}
+			time.Sleep(time.Millisecond)
+		}
+		size := sizer.Next()
+		now := time.Now()
+		if cfg.Timeout > 0 {
+			conn.SetWriteDeadline(now.Add(cfg.Timeout))
+		}
+		cfg.Metrics.Begin()
+		if _, err := conn.Write(msgs.Message(size)); err != nil {
+			writeErr, writeStart = err, now
+			break
+		}
+		sent <- inFlight{sent: now, size: size}
+	}
+	// Drain the replies still in flight so they count
+	close(sent)
+	<-done
+	// Echoes a failed reader never got to were lost with the connection
+	for m := range sent {
+		w.fail(m.sent, readErr)
+	}
+	if writeErr != nil {
+		w.fail(writeStart, writeErr)
+	}
+	return writeErr != nil || readErr != nil
+}
@@ -0,0 +1,251 @@
+package echoload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"benchmarks/internal/benchtest"
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/payload"
+	"benchmarks/internal/report"
+	"benchmarks/internal/retry"
+)
+
+func TestRun(t *testing.T) {
+	addr := benchtest.EchoServer(t)
+	for _, cfg := range []Config{
+		{},
+		{Pipeline: 8, Size: 100, Dist: payload.LogNormal},
+		{Churn: true, Size: 32, Dist: payload.Uniform},
+	} {
+		cfg.Addr, cfg.Concurrency, cfg.Duration, cfg.Check = addr, 4, 100*time.Millisecond, true
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		res := Run(cfg)
+		if res.Requests == 0 || res.Errors != 0 {
+			t.Errorf("%+v: %d requests, %d errors (%s)", cfg, res.Requests, res.Errors, res.ErrorClasses)
+		}
+		if res.Latency.Count() != res.Requests {
+			t.Errorf("%+v: latency count %d, want %d", cfg, res.Latency.Count(), res.Requests)
+		}
+		if cfg.Dist == payload.Fixed && res.Echoed != res.Requests*DefaultSize {
+			t.Errorf("echoed %d bytes for %d requests", res.Echoed, res.Requests)
+		}
+	}
+}
+
+func TestRunRequestCount(t *testing.T) {
+	addr := benchtest.EchoServer(t)
+	for _, cfg := range []Config{{}, {Pipeline: 8}, {Churn: true}} {
+		cfg.Addr, cfg.Concurrency, cfg.Requests = addr, 4, 1000
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if res := Run(cfg); res.Requests != 1000 || res.Errors != 0 {
+			t.Errorf("%+v: %d requests, %d errors", cfg, res.Requests, res.Errors)
+		}
+	}
+}
+
+func TestRunInterrupt(t *testing.T) {
+	// A server that accepts and reads but never echoes, so only the
+	// interrupt can end round trips without a timeout
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	for _, cfg := range []Config{{}, {Pipeline: 8}, {Churn: true}} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		cfg.Addr, cfg.Concurrency, cfg.Duration, cfg.Context = lis.Addr().String(), 2, time.Minute, ctx
+		start := time.Now()
+		res := Run(cfg)
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%+v: run took %v after the interrupt", cfg, d)
+		}
+		if res.Errors != 0 {
+			t.Errorf("%+v: %d errors (%s); abandoned round trips are not errors", cfg, res.Errors, res.ErrorClasses)
+		}
+	}
+}
+
+func TestRunMismatch(t *testing.T) {
+	// A server that answers every message with the wrong bytes
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, DefaultSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("xxxxxxxxxx")[:n])
+		}
+	}()
+
+	res := Run(Config{Addr: lis.Addr().String(), Concurrency: 1, Duration: 50 * time.Millisecond, Check: true})
+	if res.Requests != 0 || res.ErrorClasses[ClassMismatch] == 0 {
+		t.Fatalf("requests %d, classes %s", res.Requests, res.ErrorClasses)
+	}
+}
+
+func TestRunPipelineBroken(t *testing.T) {
+	// A server that echoes one message and hangs up on the rest
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, DefaultSize)
+		if _, err := io.ReadFull(conn, buf); err == nil {
+			conn.Write(buf)
+		}
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+	}()
+	m, err := metrics.Serve("127.0.0.1:0", "bench_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	res := Run(Config{Addr: lis.Addr().String(), Concurrency: 1, Pipeline: 8, Duration: time.Second, Metrics: m})
+	if res.Requests != 1 || res.Errors < 2 {
+		t.Errorf("%d requests, %d errors (%s), want the echoes in flight to fail", res.Requests, res.Errors, res.ErrorClasses)
+	}
+	resp, err := http.Get("http://" + m.Addr() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := `bench_client_in_flight_requests{tool="bench_test"} 0`; !strings.Contains(string(body), want) {
+		t.Errorf("scrape missing %q", want)
+	}
+}
+
+func TestRunRedials(t *testing.T) {
+	// The server comes up, and later restarts, while the workers run
+	sock := filepath.Join(t.TempDir(), "echo.sock")
+	serve := func(conns int) {
+		lis, err := net.Listen("unix", sock)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer lis.Close()
+		for range conns {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, DefaultSize)
+			for range 10 {
+				if _, err := conn.Read(buf); err != nil {
+					break
+				}
+				conn.Write(buf)
+			}
+			conn.Close()
+		}
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		serve(1)
+		time.Sleep(20 * time.Millisecond)
+		serve(1)
+	}()
+
+	cfg := Config{
+		Network:     "unix",
+		Addr:        sock,
+		Concurrency: 1,
+		Duration:    300 * time.Millisecond,
+		Retry:       retry.Policy{Attempts: 100, Backoff: 5 * time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests != 20 {
+		t.Errorf("%d requests, want 20 over two connections (%s)", res.Requests, res.ErrorClasses)
+	}
+	if res.Retries == 0 {
+		t.Error("no retries counted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, cfg := range []Config{
+		{Concurrency: 0},
+		{Concurrency: 1, Pipeline: -1},
+		{Concurrency: 1, Pipeline: 2, Churn: true},
+		{Concurrency: 1, Dist: "zipf"},
+		{Concurrency: 1, Retry: retry.Policy{Attempts: -1}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%+v: no error", cfg)
+		}
+	}
+}
+
+func BenchmarkEcho(b *testing.B) {
+	addr := benchtest.EchoServer(b)
+	for _, c := range []int{1, benchtest.Concurrency, 64} {
+		b.Run(fmt.Sprintf("c=%d", c), func(b *testing.B) {
+			benchmarkRun(b, Config{Addr: addr, Concurrency: c})
+		})
+	}
+}
+
+func BenchmarkEchoPipelined(b *testing.B) {
+	benchmarkRun(b, Config{Addr: benchtest.EchoServer(b), Concurrency: benchtest.Concurrency, Pipeline: 16})
+}
+
+func BenchmarkEchoValidated(b *testing.B) {
+	benchmarkRun(b, Config{Addr: benchtest.EchoServer(b), Concurrency: benchtest.Concurrency, Size: 1024, Dist: payload.LogNormal, Check: true})
+}
+
+func benchmarkRun(b *testing.B, cfg Config) {
+	cfg.Requests = int64(b.N)
+	if err := cfg.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	res := Run(cfg)
+	benchtest.Report(b, report.NewResult("echoload", cfg.Addr, res.Concurrency, res.Elapsed, res.Requests, res.Errors, res.Latency))
+	b.ReportMetric(float64(res.Echoed)/res.Elapsed.Seconds()/1e6, "MB/s")
+}
@@ -0,0 +1,122 @@
+package echoload
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Socket tunes the connections of a run, to separate what the server costs
+// from what Nagle's algorithm and socket buffer sizing cost. The zero
+// Socket keeps the Go defaults: Nagle off and kernel-sized buffers.
+type Socket struct {
+	// Nagle turns Nagle's algorithm back on (TCP_NODELAY off), so small
+	// writes may wait for the previous segment's ACK.
+	Nagle bool
+	// SendBuffer and RecvBuffer set SO_SNDBUF and SO_RCVBUF in bytes
+	// before connecting, so the receive window is scaled to match. The
+	// kernel may round or double them; zero keeps its default.
+	SendBuffer int
+	RecvBuffer int
+	// ConnectTimeout bounds each dial in place of Config.Timeout.
+	ConnectTimeout time.Duration
+}
+
+// Register adds the socket tuning flags to fs.
+func (s *Socket) Register(fs *flag.FlagSet) {
+	fs.BoolFunc("nodelay", "set TCP_NODELAY; -nodelay=false turns Nagle's algorithm on to show the latency it adds (default true)", func(v string) error {
+		on, err := strconv.ParseBool(v)
+		s.Nagle = !on
+		return err
+	})
+	fs.IntVar(&s.SendBuffer, "sndbuf", s.SendBuffer, "SO_SNDBUF in bytes for each connection (0 = kernel default)")
+	fs.IntVar(&s.RecvBuffer, "rcvbuf", s.RecvBuffer, "SO_RCVBUF in bytes for each connection, set before connecting so the window scales with it (0 = kernel default)")
+	fs.DurationVar(&s.ConnectTimeout, "connect-timeout", s.ConnectTimeout, "limit on each connect (0 = -timeout)")
+}
+
+func (s *Socket) validate() error {
+	switch {
+	case s.SendBuffer < 0 || s.RecvBuffer < 0:
+		return errors.New("-sndbuf and -rcvbuf must not be negative")
+	case s.ConnectTimeout < 0:
+		return errors.New("-connect-timeout must not be negative")
+	}
+	return nil
+}
+
+// tuned reports whether s changes anything.
+func (s *Socket) tuned() bool {
+	return *s != Socket{}
+}
+
+// Dialer returns a dialer that sizes the socket buffers before connecting.
+func (s *Socket) Dialer() *net.Dialer {
+	d := &net.Dialer{}
+	if s.SendBuffer > 0 || s.RecvBuffer > 0 {
+		d.Control = s.control
+	}
+	return d
+}
+
+func (s *Socket) control(_, _ string, rc syscall.RawConn) error {
+	var err error
+	ctrl := rc.Control(func(fd uintptr) {
+		if s.SendBuffer > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, s.SendBuffer)
+		}
+		if err == nil && s.RecvBuffer > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, s.RecvBuffer)
+		}
+	})
+	if ctrl != nil {
+		return ctrl
+	}
+	return err
+}
+
+// apply sets the options that only take effect once conn is connected.
+func (s *Socket) apply(conn net.Conn) {
+	if tc, ok := conn.(*net.TCPConn); ok && s.Nagle {
+		tc.SetNoDelay(false)
+	}
+}
+
+// Describe prints the socket options for the run header.
+func (s *Socket) Describe(w io.Writer) {
+	if !s.tuned() {
+		return
+	}
+	var parts []string
+	if s.Nagle {
+		parts = append(parts, "Nagle on (TCP_NODELAY off)")
+	}
+	if s.SendBuffer > 0 {
+		parts = append(parts, fmt.Sprintf("SO_SNDBUF %d", s.SendBuffer))
+	}
+	if s.RecvBuffer > 0 {
+		parts = append(parts, fmt.Sprintf("SO_RCVBUF %d", s.RecvBuffer))
+	}
+	if s.ConnectTimeout > 0 {
+		parts = append(parts, fmt.Sprintf("connect timeout %v", s.ConnectTimeout))
+	}
+	fmt.Fprintf(w, "Socket: %s\n", strings.Join(parts, ", "))
+}
+
+// Record adds the socket options to a result's config when any is set.
+func (s *Socket) Record(config map[string]string) {
+	if !s.tuned() {
+		return
+	}
+	config["nodelay"] = strconv.FormatBool(!s.Nagle)
+	config["sndbuf"] = strconv.Itoa(s.SendBuffer)
+	config["rcvbuf"] = strconv.Itoa(s.RecvBuffer)
+	if s.ConnectTimeout > 0 {
+		config["connect_timeout"] = s.ConnectTimeout.String()
+	}
+}
@@ -0,0 +1,38 @@
+package echoload
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"benchmarks/internal/benchtest"
+)
+
+func TestSocket(t *testing.T) {
+	addr := benchtest.EchoServer(t)
+	s := Socket{Nagle: true, SendBuffer: 16 << 10, RecvBuffer: 16 << 10}
+	conn, err := s.Dialer().DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rc, _ := conn.(*net.TCPConn).SyscallConn()
+	rc.Control(func(fd uintptr) {
+		// Linux doubles the requested size for bookkeeping
+		for _, opt := range []int{syscall.SO_SNDBUF, syscall.SO_RCVBUF} {
+			if n, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt); err != nil || n < 16<<10 || n > 64<<10 {
+				t.Errorf("option %d = %d, %v; want about 16KiB", opt, n, err)
+			}
+		}
+	})
+
+	cfg := Config{Addr: addr, Concurrency: 2, Duration: 50 * time.Millisecond, Socket: s, Check: true}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if res := Run(cfg); res.Requests == 0 || res.Errors != 0 {
+		t.Errorf("%d requests, %d errors (%s)", res.Requests, res.Errors, res.ErrorClasses)
+	}
+}
@@ -0,0 +1,153 @@
+package echoload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/payload"
+)
+
+// Datagram echo: with Network "udp" each worker sends datagrams that open
+// with an 8-byte big-endian sequence number and matches the echoes by it.
+// UDP neither retransmits nor orders, so instead of failing the connection
+// a missing echo counts as lost once Timeout passes, and an echo arriving
+// after one sent later as reordered.
+
+// udpHeader is the sequence number every datagram starts with; smaller
+// sizes are padded to it.
+const udpHeader = 8
+
+// maxDatagram is the largest UDP payload over IPv4.
+const maxDatagram = 65507
+
+// ClassLost is the error class of a datagram whose echo never arrived.
+const ClassLost = "lost"
+
+// defaultUDPTimeout declares a datagram lost when Config.Timeout is zero.
+const defaultUDPTimeout = time.Second
+
+func (c *Config) udp() bool {
+	return c.Network == "udp" || c.Network == "udp4" || c.Network == "udp6"
+}
+
+func (c *Config) validateUDP() error {
+	sizer, _ := payload.NewSizer(c.Dist, c.Size, nil) // checked by Validate
+	switch {
+	case c.Churn:
+		return errors.New("UDP has no connections to churn; drop -no-keepalive")
+	case sizer.Max() > maxDatagram:
+		return errors.New("message size distribution exceeds the largest datagram (65507 bytes)")
+	}
+	return nil
+}
+
+// datagrams runs the worker's UDP loop: up to Pipeline datagrams in
+// flight, each echo matched to its send by sequence number.
+func (w *worker) datagrams() {
+	conn := w.open()
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	timeout := w.cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultUDPTimeout
+	}
+	window := max(w.cfg.Pipeline, 1)
+	sizer, _, _ := w.messages(w.id)
+	msg := make([]byte, max(sizer.Max(), udpHeader))
+	payload.Fill(msg)
+	echo := make([]byte, len(msg)+1) // one spare byte shows an oversized echo
+
+	pending := make(map[uint64]inFlight, window)
+	var seq, highest uint64
+	sending := true
+	for {
+		for sending && len(pending) < window {
+			if !w.running() {
+				sending = false
+				break
+			}
+			// A ticket may come back when a datagram in flight is lost
+			if !w.take() {
+				break
+			}
+			size := max(sizer.Next(), udpHeader)
+			binary.BigEndian.PutUint64(msg, seq)
+			now := time.Now()
+			w.cfg.Metrics.Begin()
+			_, err := conn.Write(msg[:size])
+			seq++
+			if err != nil {
+				// There is no connection to break: count the
+				// datagram and carry on
+				w.fail(now, err)
+				continue
+			}
+			w.sent.Add(1)
+			pending[seq-1] = inFlight{sent: now, size: size}
+		}
+		if len(pending) == 0 || w.ctx.Err() != nil {
+			return
+		}
+
+		oldest := time.Now()
+		for _, m := range pending {
+			if m.sent.Before(oldest) {
+				oldest = m.sent
+			}
+		}
+		conn.SetReadDeadline(oldest.Add(timeout))
+		n, err := conn.Read(echo)
+		switch {
+		case w.ctx.Err() != nil:
+			return
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			w.expire(pending, timeout, ClassLost)
+			continue
+		case err != nil:
+			// A connected UDP socket reports ICMP port unreachable as
+			// a refused read; the datagrams in flight are gone with it
+			w.expire(pending, 0, errclass.Of(err))
+			continue
+		}
+		if n < udpHeader {
+			w.late.Add(1)
+			continue
+		}
+		got := binary.BigEndian.Uint64(echo)
+		m, ok := pending[got]
+		if !ok {
+			// The echo of a datagram already declared lost, or a
+			// duplicate
+			w.late.Add(1)
+			continue
+		}
+		delete(pending, got)
+		if got < highest {
+			w.reordered.Add(1)
+		}
+		highest = max(highest, got)
+		d := time.Since(m.sent)
+		if w.cfg.Check && (n != m.size || !bytes.Equal(echo[udpHeader:n], msg[udpHeader:m.size])) {
+			w.reject(m.sent, ClassMismatch)
+			continue
+		}
+		w.success(m.sent, d, n)
+	}
+}
+
+// expire fails the datagrams pending for at least timeout with class.
+func (w *worker) expire(pending map[uint64]inFlight, timeout time.Duration, class string) {
+	now := time.Now()
+	for seq, m := range pending {
+		if now.Sub(m.sent) >= timeout {
+			delete(pending, seq)
+			w.reject(m.sent, class)
+		}
+	}
+}
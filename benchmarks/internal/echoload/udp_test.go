@@ -0,0 +1,83 @@
+package echoload
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// lossyEcho serves UDP echo, dropping every dropEvery-th datagram and
+// holding back every holdEvery-th until the next one has been echoed.
+func lossyEcho(t *testing.T, dropEvery, holdEvery int) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		var held []byte
+		var heldTo net.Addr
+		for i := 1; ; i++ {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			switch {
+			case dropEvery > 0 && i%dropEvery == 0:
+			case holdEvery > 0 && i%holdEvery == 0 && held == nil:
+				held, heldTo = append([]byte(nil), buf[:n]...), from
+			default:
+				pc.WriteTo(buf[:n], from)
+				if held != nil {
+					pc.WriteTo(held, heldTo)
+					held = nil
+				}
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestRunUDP(t *testing.T) {
+	cfg := Config{
+		Network:     "udp",
+		Addr:        lossyEcho(t, 0, 0),
+		Concurrency: 2,
+		Duration:    100 * time.Millisecond,
+		Pipeline:    4,
+		Size:        64,
+		Check:       true,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests == 0 || res.Errors != 0 || res.Sent != res.Requests || res.Reordered != 0 {
+		t.Errorf("%d echoed of %d sent, %d errors (%s), %d reordered", res.Requests, res.Sent, res.Errors, res.ErrorClasses, res.Reordered)
+	}
+}
+
+func TestRunUDPLossAndReorder(t *testing.T) {
+	cfg := Config{
+		Network:     "udp",
+		Addr:        lossyEcho(t, 10, 7),
+		Concurrency: 1,
+		Requests:    200,
+		Timeout:     50 * time.Millisecond,
+		Pipeline:    4,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests != 200 {
+		t.Errorf("%d echoed, want 200 despite losses", res.Requests)
+	}
+	if res.Lost == 0 || res.Lost != res.ErrorClasses[ClassLost] || res.Sent != res.Requests+res.Lost {
+		t.Errorf("sent %d, echoed %d, lost %d (%s)", res.Sent, res.Requests, res.Lost, res.ErrorClasses)
+	}
+	if res.Reordered == 0 {
+		t.Error("no reordering seen")
+	}
+}
@@ -0,0 +1,122 @@
+// Package errclass buckets failed requests by cause, so a run that "passes"
+// with a large share of timeouts or 503s is visible in its results.
+package errclass
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Classes returned by Of.
+const (
+	Timeout = "timeout"
+	Refused = "refused"
+	Reset   = "reset"
+	EOF     = "eof"
+	DNS     = "dns"
+	TLS     = "tls"
+	Dial    = "dial"
+	Other   = "other"
+)
+
+// Of classifies a transport error.
+func Of(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthErr x509.UnknownAuthorityError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return Refused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return Reset
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return EOF
+	case errors.As(err, &dnsErr):
+		return DNS
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &certErr),
+		errors.As(err, &unknownAuthErr):
+		return TLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return Dial
+	}
+	return Other
+}
+
+// Status is the class of a response rejected for its status code.
+func Status(code int) string {
+	return "status_" + strconv.Itoa(code)
+}
+
+// Counts tallies errors per class. Like hdr histograms, each worker keeps
+// its own and they are merged once the run ends.
+type Counts map[string]int64
+
+// Merge adds other's tallies into c.
+func (c Counts) Merge(other Counts) {
+	for class, n := range other {
+		c[class] += n
+	}
+}
+
+// Merged combines per-worker tallies into a fresh Counts.
+func Merged(counts []Counts) Counts {
+	out := make(Counts)
+	for _, c := range counts {
+		out.Merge(c)
+	}
+	return out
+}
+
+// Total returns the number of errors across all classes.
+func (c Counts) Total() int64 {
+	var n int64
+	for _, v := range c {
+		n += v
+	}
+	return n
+}
+
+// Sorted returns the classes with the most frequent first, ties by name.
+func (c Counts) Sorted() []string {
+	classes := make([]string, 0, len(c))
+	for class := range c {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if c[classes[i]] != c[classes[j]] {
+			return c[classes[i]] > c[classes[j]]
+		}
+		return classes[i] < classes[j]
+	})
+	return classes
+}
+
+// String renders c as "timeout=3;status_503=1" for CSV cells.
+func (c Counts) String() string {
+	var b strings.Builder
+	for i, class := range c.Sorted() {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString(class)
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatInt(c[class], 10))
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package errclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOf(t *testing.T) {
+	// A listener that is closed straight away gives a port nobody serves
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	hangup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer hangup.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+
+	get := func(url string, timeout time.Duration) error {
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"refused", get("http://"+closedAddr, time.Second), Refused},
+		{"client timeout", get(slow.URL, 50*time.Millisecond), Timeout},
+		{"context", fmt.Errorf("wrapped: %w", context.DeadlineExceeded), Timeout},
+		{"hangup", get(hangup.URL, time.Second), EOF},
+		{"untrusted cert", get(tlsSrv.URL, time.Second), TLS},
+		{"eof", io.ErrUnexpectedEOF, EOF},
+		{"other", errors.New("boom"), Other},
+	}
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Errorf("%s: request succeeded", tt.name)
+			continue
+		}
+		if got := Of(tt.err); got != tt.want {
+			t.Errorf("%s: Of(%v) = %q, want %q", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCounts(t *testing.T) {
+	a := Counts{Timeout: 2, Status(503): 1}
+	b := Counts{Status(503): 2, Reset: 2}
+	m := Merged([]Counts{a, b, nil})
+	if m.Total() != 7 {
+		t.Fatalf("Total = %d", m.Total())
+	}
+	if got := m.String(); got != "status_503=3;reset=2;timeout=2" {
+		t.Fatalf("String = %q", got)
+	}
+	if a[Status(503)] != 1 {
+		t.Fatal("Merged modified its input")
+	}
+}
@@ -0,0 +1,110 @@
+// Package grpcecho is the client and reference server for the Echo service
+// in echo.proto. Both messages hold a single bytes field, so they are
+// encoded directly with protowire instead of generated code; the bytes on
+// the wire are identical to what protoc-gen-go would produce.
+package grpcecho
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Method is the full gRPC method name for Echo.Echo.
+const Method = "/fasterapi.bench.Echo/Echo"
+
+// Message is both EchoRequest and EchoReply.
+type Message struct {
+	Payload []byte
+}
+
+// Marshal encodes m as protobuf. An empty payload encodes to nothing, as
+// proto3 omits default values.
+func (m *Message) Marshal() []byte {
+	if len(m.Payload) == 0 {
+		return nil
+	}
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, m.Payload)
+}
+
+// Unmarshal decodes b into m, skipping unknown fields.
+func (m *Message) Unmarshal(b []byte) error {
+	m.Payload = nil
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			m.Payload = append(m.Payload[:0], v...)
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// Codec is a grpc encoding.Codec for Message. It registers as "proto" so
+// requests carry the standard application/grpc+proto content type.
+type Codec struct{}
+
+func (Codec) Name() string { return "proto" }
+
+func (Codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(*Message)
+	if !ok {
+		return nil, fmt.Errorf("grpcecho: cannot marshal %T", v)
+	}
+	return m.Marshal(), nil
+}
+
+func (Codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(*Message)
+	if !ok {
+		return fmt.Errorf("grpcecho: cannot unmarshal into %T", v)
+	}
+	return m.Unmarshal(data)
+}
+
+// Call invokes Echo on cc.
+func Call(ctx context.Context, cc grpc.ClientConnInterface, req, reply *Message) error {
+	return cc.Invoke(ctx, Method, req, reply, grpc.ForceCodec(Codec{}))
+}
+
+// NewServer returns a gRPC server with the reference Echo handler
+// registered, for testing the client and as a baseline to compare a
+// FasterAPI handler against.
+func NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append(opts, grpc.ForceServerCodec(Codec{}))...)
+	s.RegisterService(&serviceDesc, nil)
+	return s
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "fasterapi.bench.Echo",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			m := new(Message)
+			if err := dec(m); err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+	}},
+	Metadata: "echo.proto",
+}
@@ -0,0 +1,95 @@
+package grpcecho
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	for _, p := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte{7}, 300)} {
+		var got Message
+		if err := got.Unmarshal((&Message{Payload: p}).Marshal()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Payload, p) {
+			t.Errorf("payload %d bytes came back as %d", len(p), len(got.Payload))
+		}
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	b := protowire.AppendTag(nil, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, 42)
+	b = append(b, (&Message{Payload: []byte("hi")}).Marshal()...)
+	var m Message
+	if err := m.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Payload) != "hi" {
+		t.Fatalf("payload = %q", m.Payload)
+	}
+	if err := m.Unmarshal([]byte{0x0a, 0x05, 'x'}); err == nil {
+		t.Fatal("truncated message decoded")
+	}
+}
+
+func TestCall(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cc, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var reply Message
+	if err := Call(ctx, cc, &Message{Payload: []byte("ping")}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Payload) != "ping" {
+		t.Fatalf("reply = %q", reply.Payload)
+	}
+}
+
+func BenchmarkCall(b *testing.B) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	srv := NewServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cc, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cc.Close()
+
+	req := &Message{Payload: []byte("Hello, World!")}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply Message
+		for pb.Next() {
+			if err := Call(context.Background(), cc, req, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
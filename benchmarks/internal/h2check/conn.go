@@ -0,0 +1,356 @@
+package h2check
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// errIdle is returned by Conn.Next when no frame arrives in time.
+var errIdle = errors.New("no frame from server")
+
+// Conn is a raw HTTP/2 connection, prior-knowledge h2c or TLS, on which a
+// check writes frames, legal or not, and reads back everything the server
+// sends.
+type Conn struct {
+	net.Conn
+	Framer *http2.Framer
+
+	// Settings is what the server sent in its first SETTINGS frame.
+	Settings map[http2.SettingID]uint32
+
+	scheme    string
+	authority string
+	enc       *hpack.Encoder
+	encBuf    bytes.Buffer
+	nextID    uint32
+	timeout   time.Duration
+
+	// The reader goroutine reads one frame per request on next, since a
+	// DATA frame's payload is only valid until the following read.
+	next    chan struct{}
+	frames  chan result
+	reading bool
+	err     error // the read error that stopped the reader
+}
+
+type result struct {
+	f   http2.Frame
+	err error
+}
+
+// Dial connects to addr, sends the client preface with settings and waits
+// for the server's SETTINGS, which it acknowledges. The server's
+// acknowledgement of ours is left for the caller to read. Push is
+// disabled unless settings enable it.
+func Dial(ctx context.Context, addr string, timeout time.Duration, settings ...http2.Setting) (*Conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(nc, nc, addr, timeout)
+	if err := c.handshake(settings); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialTLS is Dial over TLS with cfg, offering only "h2" by ALPN. It fails
+// if the server selects anything else.
+func DialTLS(ctx context.Context, addr string, cfg *tls.Config, timeout time.Duration, settings ...http2.Setting) (*Conn, error) {
+	cfg = cfg.Clone()
+	cfg.NextProtos = []string{http2.NextProtoTLS}
+	d := tls.Dialer{Config: cfg}
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if p := nc.(*tls.Conn).ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+		nc.Close()
+		return nil, fmt.Errorf("server selected ALPN protocol %q, want h2", p)
+	}
+	c := newConn(nc, nc, addr, timeout)
+	c.scheme = "https"
+	if err := c.handshake(settings); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Upgrade connects to addr and sends an HTTP/1.1 request with Upgrade:
+// h2c, whose response the server must send as stream 1 of the HTTP/2
+// connection that follows its 101 Switching Protocols. A non-nil body is
+// sent with the request, before the switch. Upgrade returns once the
+// server's SETTINGS arrive, as Dial does.
+func Upgrade(ctx context.Context, addr string, timeout time.Duration, method, path string, body []byte, settings ...http2.Setting) (*Conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	settings = noPush(settings)
+	// The SETTINGS frame payload, minus its header
+	var payload []byte
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Val)
+	}
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, path, addr)
+	fmt.Fprintf(&req, "Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: %s\r\n",
+		base64.RawURLEncoding.EncodeToString(payload))
+	if body != nil {
+		fmt.Fprintf(&req, "Content-Length: %d\r\n", len(body))
+	}
+	req.WriteString("\r\n")
+	req.Write(body)
+	nc.SetDeadline(time.Now().Add(timeout))
+	if _, err := nc.Write(req.Bytes()); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	// The server's preface may arrive with the 101, so the framer reads
+	// on from the same buffer
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("reading upgrade response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		nc.Close()
+		return nil, fmt.Errorf("upgrade answered with %s, want 101 Switching Protocols", resp.Status)
+	}
+	if up := resp.Header.Get("Upgrade"); !strings.EqualFold(up, "h2c") {
+		nc.Close()
+		return nil, fmt.Errorf("101 with Upgrade %q, want h2c", up)
+	}
+	nc.SetDeadline(time.Time{})
+
+	c := newConn(nc, br, addr, timeout)
+	c.nextID = 3 // stream 1 is the upgraded request
+	if err := c.handshake(settings); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newConn(nc net.Conn, r io.Reader, addr string, timeout time.Duration) *Conn {
+	c := &Conn{
+		Conn:      nc,
+		Framer:    http2.NewFramer(nc, r),
+		Settings:  map[http2.SettingID]uint32{},
+		scheme:    "http",
+		authority: addr,
+		nextID:    1,
+		timeout:   timeout,
+		next:      make(chan struct{}),
+		frames:    make(chan result, 1),
+	}
+	c.Framer.AllowIllegalWrites = true
+	c.Framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	c.enc = hpack.NewEncoder(&c.encBuf)
+	go c.read()
+	return c
+}
+
+// noPush adds SETTINGS_ENABLE_PUSH=0 to settings that leave push at its
+// default, which is on, so that only a check expecting pushes gets them.
+func noPush(settings []http2.Setting) []http2.Setting {
+	for _, s := range settings {
+		if s.ID == http2.SettingEnablePush {
+			return settings
+		}
+	}
+	return append([]http2.Setting{{ID: http2.SettingEnablePush, Val: 0}}, settings...)
+}
+
+// handshake sends the client preface and reads the server's.
+func (c *Conn) handshake(settings []http2.Setting) error {
+	settings = noPush(settings)
+	if _, err := c.Conn.Write([]byte(http2.ClientPreface)); err != nil {
+		return err
+	}
+	if err := c.Framer.WriteSettings(settings...); err != nil {
+		return err
+	}
+	f, err := c.Next()
+	if err != nil {
+		return fmt.Errorf("waiting for server SETTINGS: %w", err)
+	}
+	sf, ok := f.(*http2.SettingsFrame)
+	if !ok || sf.IsAck() {
+		return fmt.Errorf("server's first frame is %v, want SETTINGS", f.Header())
+	}
+	sf.ForeachSetting(func(s http2.Setting) error {
+		c.Settings[s.ID] = s.Val
+		return nil
+	})
+	return c.Framer.WriteSettingsAck()
+}
+
+func (c *Conn) read() {
+	for range c.next {
+		f, err := c.Framer.ReadFrame()
+		c.frames <- result{f, err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Next returns the next frame from the server, or an error if the
+// connection fails or none arrives within the timeout.
+func (c *Conn) Next() (http2.Frame, error) {
+	return c.NextWithin(c.timeout)
+}
+
+// NextWithin is Next with a timeout of wait.
+func (c *Conn) NextWithin(wait time.Duration) (http2.Frame, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if !c.reading {
+		c.reading = true
+		c.next <- struct{}{}
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case r := <-c.frames:
+		c.reading = false
+		c.err = r.err
+		return r.f, r.err
+	case <-t.C:
+		return nil, errIdle
+	}
+}
+
+// Close closes the connection and stops the reader.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	if c.reading && c.err == nil {
+		<-c.frames
+	}
+	close(c.next)
+	return err
+}
+
+// Request opens the next client stream with a request for path. Extra
+// header fields follow the pseudo-headers. A header block larger than the
+// server's maximum frame size continues in CONTINUATION frames.
+func (c *Conn) Request(method, path string, endStream bool, extra ...hpack.HeaderField) (uint32, error) {
+	return c.request(method, path, endStream, int(c.maxFrameSize()), extra...)
+}
+
+// request is Request splitting the header block into fragments of at most
+// limit bytes.
+func (c *Conn) request(method, path string, endStream bool, limit int, extra ...hpack.HeaderField) (uint32, error) {
+	c.encBuf.Reset()
+	fields := append([]hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: c.scheme},
+		{Name: ":authority", Value: c.authority},
+		{Name: ":path", Value: path},
+	}, extra...)
+	for _, hf := range fields {
+		c.enc.WriteField(hf)
+	}
+	id := c.nextID
+	c.nextID += 2
+	block := c.encBuf.Bytes()
+	first := block[:min(len(block), limit)]
+	block = block[len(first):]
+	err := c.Framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: first,
+		EndStream:     endStream,
+		EndHeaders:    len(block) == 0,
+	})
+	for err == nil && len(block) > 0 {
+		frag := block[:min(len(block), limit)]
+		block = block[len(frag):]
+		err = c.Framer.WriteContinuation(id, len(block) == 0, frag)
+	}
+	return id, err
+}
+
+// maxFrameSize is the largest frame payload the server accepts.
+func (c *Conn) maxFrameSize() uint32 {
+	if v, ok := c.Settings[http2.SettingMaxFrameSize]; ok {
+		return v
+	}
+	return 16384
+}
+
+// Response reads frames until stream id ends and returns its status and
+// body, opening the flow-control windows again as DATA arrives, as a
+// client reading promptly would.
+func (c *Conn) Response(id uint32) (status string, body []byte, err error) {
+	for {
+		f, err := c.Next()
+		if err != nil {
+			return status, body, err
+		}
+		if f.Header().StreamID != id {
+			if ga, ok := f.(*http2.GoAwayFrame); ok {
+				return status, body, fmt.Errorf("GOAWAY %v", ga.ErrCode)
+			}
+			continue
+		}
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			if status == "" {
+				status = f.PseudoValue("status")
+			}
+		case *http2.DataFrame:
+			body = append(body, f.Data()...)
+			if n := f.Length; n > 0 {
+				c.Framer.WriteWindowUpdate(0, n)
+				if !f.StreamEnded() {
+					c.Framer.WriteWindowUpdate(id, n)
+				}
+			}
+		case *http2.RSTStreamFrame:
+			return status, body, fmt.Errorf("stream reset with %v", f.ErrCode)
+		}
+		if f.Header().Flags.Has(http2.FlagDataEndStream) {
+			return status, body, nil
+		}
+	}
+}
+
+// GoAway reads frames until the server sends GOAWAY and returns it, or
+// fails if the server closes the connection or goes quiet without one.
+func (c *Conn) GoAway() (*http2.GoAwayFrame, error) {
+	for {
+		f, err := c.Next()
+		switch {
+		case errors.Is(err, errIdle):
+			return nil, errors.New("no GOAWAY: connection left open")
+		case err != nil:
+			return nil, fmt.Errorf("no GOAWAY before the connection closed: %w", err)
+		}
+		if ga, ok := f.(*http2.GoAwayFrame); ok {
+			return ga, nil
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package h2check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// continuationSplit sends a large header block cut into small, oddly sized
+// CONTINUATION fragments, so that fields and their length prefixes straddle
+// frames. The server must reassemble it and answer, and a second request
+// that refers back to the block's fields through the HPACK dynamic table
+// must succeed too, which it only can if the server decoded every field.
+func continuationSplit(ctx context.Context, t Target) error {
+	const fragment = 1021
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	limit, ok := c.Settings[http2.SettingMaxHeaderListSize]
+	if !ok {
+		limit = defaultHeaderLimit
+	}
+	// Up to 48 KiB, within half the server's limit
+	size := min(int(limit)/2, 48<<10)
+	value := strings.Repeat("c", 500)
+	var fields []hpack.HeaderField
+	for n := 0; n+len(value)+32+16 <= size; n += len(value) + 32 + 16 {
+		fields = append(fields, hpack.HeaderField{Name: "x-continued-" + strconv.Itoa(len(fields)), Value: value})
+	}
+	for i, what := range []string{"split header block", "request reusing its fields"} {
+		id, err := c.request(http.MethodGet, t.Path, true, fragment, fields...)
+		if err != nil {
+			return err
+		}
+		if err := expectStatus(c, id, "200"); err != nil {
+			return fmt.Errorf("%s (%d fields in %d-byte fragments): %w", what, len(fields), fragment, err)
+		}
+		if i == 0 {
+			// The last fields are still in the table, so they go by index
+			fields = fields[max(0, len(fields)-6):]
+		}
+	}
+	return nil
+}
+
+// continuationFlood starts a header block and never ends it, sending
+// CONTINUATION frames until the server gives up on it or many times its
+// header list limit has gone by. Servers that buffered such a block
+// without bound were the subject of a 2024 round of HTTP/2 CVEs. The
+// server must cut the connection off with GOAWAY, or reset the stream or
+// close the connection, rather than keep reading. With empty frames, the
+// block never grows, so the server must count frames rather than bytes.
+func continuationFlood(empty bool) func(context.Context, Target) error {
+	return func(ctx context.Context, t Target) error {
+		c, err := t.dial(ctx)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		limit, ok := c.Settings[http2.SettingMaxHeaderListSize]
+		if !ok {
+			limit = defaultHeaderLimit
+		}
+		id := c.nextID
+		if err := c.Framer.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: headerBlock(c, http.MethodGet, t.Path), EndStream: true}); err != nil {
+			return err
+		}
+		// Literal fields without indexing, so the block grows by every
+		// fragment without touching the table
+		var frag []byte
+		if !empty {
+			frag = []byte{0x00, 7, 'x', '-', 'f', 'l', 'o', 'o', 'd', 100}
+			frag = append(frag, strings.Repeat("f", 100)...)
+		}
+		// Empty frames: far more than any real header block needs
+		frames := 10000
+		if !empty {
+			frames = min(100000, 4*int(limit)/len(frag)+1)
+		}
+		for i := range frames {
+			if err := c.Framer.WriteContinuation(id, false, frag); err != nil {
+				// The server closed the connection under us
+				return floodStopped(t, i, len(frag), "closed the connection")
+			}
+			if i%100 != 0 {
+				continue
+			}
+			if how, err := floodAnswer(c, id, 0); how != "" || err != nil {
+				return floodResult(t, i, len(frag), how, err)
+			}
+		}
+		how, err := floodAnswer(c, id, t.Timeout)
+		if how == "" && err == nil {
+			return fmt.Errorf("server read %d CONTINUATION frames (%d KiB) of an unfinished header block without rejecting it", frames, frames*(9+len(frag))>>10)
+		}
+		return floodResult(t, frames, len(frag), how, err)
+	}
+}
+
+// floodAnswer polls for the server's reaction to a flood, waiting up to
+// wait for it. An empty answer means none came yet.
+func floodAnswer(c *Conn, id uint32, wait time.Duration) (string, error) {
+	for {
+		f, err := c.NextWithin(wait)
+		switch {
+		case errors.Is(err, errIdle):
+			return "", nil
+		case err != nil:
+			return "closed the connection", nil
+		}
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return fmt.Sprintf("sent GOAWAY %v", f.ErrCode), nil
+		case *http2.RSTStreamFrame:
+			if f.StreamID == id {
+				return fmt.Sprintf("reset the stream with %v", f.ErrCode), nil
+			}
+		case *http2.MetaHeadersFrame:
+			if f.StreamID == id {
+				return "", fmt.Errorf("server answered the unfinished header block with status %s", f.PseudoValue("status"))
+			}
+		}
+	}
+}
+
+func floodResult(t Target, frames, size int, how string, err error) error {
+	if err != nil {
+		return err
+	}
+	return floodStopped(t, frames, size, how)
+}
+
+// floodStopped logs how far a flood got before the server stopped it.
+func floodStopped(t Target, frames, size int, how string) error {
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Stopped after %d CONTINUATION frames (%d KiB): %s\n", frames, frames*(9+size)>>10, how)
+	}
+	return nil
+}
@@ -0,0 +1,567 @@
+// Package h2check probes a server's HTTP/2 framing layer directly, below
+// what net/http exposes: the SETTINGS exchange, flow-control accounting, the
+// GOAWAY a server owes a client that breaks the protocol, stream
+// cancellation, HPACK and header limits, graceful shutdown, and the h2c
+// Upgrade handshake. It is a small embedded counterpart to h2spec, aimed at
+// FasterAPI's HTTP/2 stack.
+package h2check
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Target is the server under test.
+type Target struct {
+	Addr      string        // host:port of an HTTP/2 listener
+	Path      string        // a path that answers GET with a body
+	Echo      string        // a path that answers POST with the request body
+	Push      string        // a path that pushes resources, for Push
+	WebSocket string        // a path that echoes WebSocket messages, for WebSocket
+	Timeout   time.Duration // how long to wait for any one frame
+
+	// Stream is a path that streams a large response, for Stream, which
+	// reads it at no more than StreamRate bytes a second. With ServerPID or
+	// ServerStats it also fails if the server's RSS grows by more than
+	// MaxGrowth bytes meanwhile.
+	Stream      string
+	StreamRate  float64
+	MaxGrowth   int64
+	ServerPID   int
+	ServerStats string
+
+	// SlowLimit is how long the server may wait for a stalled request
+	// before timing it out, for Timeouts.
+	SlowLimit time.Duration
+
+	// Idle is how long Idle leaves a connection silent, and then keeps it
+	// alive with PINGs.
+	Idle time.Duration
+
+	// Fuzz is how many random frames Fuzz sends, from a generator seeded
+	// with FuzzSeed.
+	Fuzz     int
+	FuzzSeed int64
+
+	// TLS, if set, makes the checks connect with TLS and ALPN instead of
+	// h2c, verifying the server against its roots and server name.
+	TLS *tls.Config
+
+	// Shutdown starts a graceful shutdown of the server, for Shutdown. It
+	// may block until the shutdown completes.
+	Shutdown func() error
+
+	// Log, if set, receives details a check measured, such as a
+	// transfer rate.
+	Log io.Writer
+}
+
+// Check is one protocol check.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context, t Target) error
+}
+
+// Checks lists every check that applies to both h2c and TLS, in the order
+// they run.
+var Checks = []Check{
+	{"SETTINGS exchange", settingsExchange},
+	{"Stream flow control", streamFlowControl},
+	{"WINDOW_UPDATE overflow", windowOverflow},
+	{"Zero WINDOW_UPDATE", zeroWindowUpdate},
+	{"GOAWAY on protocol error", goAwayOnError},
+	{"RST_STREAM cancellation", cancelStream},
+	{"Max concurrent streams", maxStreams},
+	{"HPACK dynamic table", hpackTable},
+	{"Oversized headers get 431", headerLimit},
+	{"Header block split over CONTINUATION", continuationSplit},
+	{"CONTINUATION flood", continuationFlood(false)},
+	{"Empty CONTINUATION flood", continuationFlood(true)},
+}
+
+// Upgrades check the h2c Upgrade handshake, which only a cleartext
+// listener offers.
+var Upgrades = []Check{
+	{"h2c Upgrade with GET", upgradeGet},
+	{"h2c Upgrade with a request body", upgradeBody},
+}
+
+// dial connects to the target as Dial does, over TLS if it has a config.
+func (t Target) dial(ctx context.Context, settings ...http2.Setting) (*Conn, error) {
+	if t.TLS != nil {
+		return DialTLS(ctx, t.Addr, t.TLS, t.Timeout, settings...)
+	}
+	return Dial(ctx, t.Addr, t.Timeout, settings...)
+}
+
+// skip passes a check that does not apply to the server, logging why.
+func skip(t Target, format string, args ...any) error {
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Skipped: "+format+"\n", args...)
+	}
+	return nil
+}
+
+// stall is how long a check waits for frames the server must not send,
+// such as DATA into an exhausted window.
+const stall = 200 * time.Millisecond
+
+// settingsExchange checks that the server's SETTINGS are valid and that it
+// acknowledges ours, both in the preface and later on, ignoring unknown
+// settings as RFC 9113 requires.
+func settingsExchange(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx, http2.Setting{ID: 0xf0, Val: 1})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for id, v := range c.Settings {
+		if err := (http2.Setting{ID: id, Val: v}).Valid(); err != nil {
+			return fmt.Errorf("server sent invalid %v=%d", id, v)
+		}
+	}
+	if v, ok := c.Settings[http2.SettingEnablePush]; ok && v != 0 {
+		return fmt.Errorf("server sent %v=%d, which only clients may enable", http2.SettingEnablePush, v)
+	}
+	if err := settingsAck(c); err != nil {
+		return fmt.Errorf("preface SETTINGS: %w", err)
+	}
+	if err := c.Framer.WriteSettings(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 4096}); err != nil {
+		return err
+	}
+	if err := settingsAck(c); err != nil {
+		return fmt.Errorf("later SETTINGS: %w", err)
+	}
+	return nil
+}
+
+func settingsAck(c *Conn) error {
+	for {
+		f, err := c.Next()
+		if err != nil {
+			return fmt.Errorf("no ACK: %w", err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() {
+				return nil
+			}
+		case *http2.GoAwayFrame:
+			return fmt.Errorf("GOAWAY %v instead of an ACK", f.ErrCode)
+		}
+	}
+}
+
+// streamFlowControl advertises a one-byte stream window and then opens it
+// a little at a time, failing if the server ever sends more DATA than the
+// window allows.
+func streamFlowControl(ctx context.Context, t Target) error {
+	const initial, step = 1, 1024
+	c, err := t.dial(ctx, http2.Setting{ID: http2.SettingInitialWindowSize, Val: initial})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	granted, received := int64(initial), int64(0)
+	for {
+		wait := t.Timeout
+		if received == granted {
+			wait = stall
+		}
+		f, err := c.NextWithin(wait)
+		if errors.Is(err, errIdle) && received == granted {
+			// The server waited, as it must; open the window again
+			if err := c.Framer.WriteWindowUpdate(id, step); err != nil {
+				return err
+			}
+			if err := c.Framer.WriteWindowUpdate(0, step); err != nil {
+				return err
+			}
+			granted += step
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading response after %d bytes: %w", received, err)
+		}
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return fmt.Errorf("GOAWAY %v", f.ErrCode)
+		case *http2.RSTStreamFrame:
+			return fmt.Errorf("stream reset with %v", f.ErrCode)
+		case *http2.DataFrame:
+			if f.StreamID != id {
+				continue
+			}
+			// Padding counts against the window too
+			received += int64(f.Length)
+			if received > granted {
+				return fmt.Errorf("server sent %d bytes of DATA into a window of %d", received, granted)
+			}
+		}
+		if f.Header().StreamID == id && f.Header().Flags.Has(http2.FlagDataEndStream) {
+			return nil
+		}
+	}
+}
+
+// windowOverflow grows the connection window past 2^31-1, which the server
+// must answer with a FLOW_CONTROL_ERROR GOAWAY.
+func windowOverflow(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.Framer.WriteWindowUpdate(0, 1<<31-1); err != nil {
+		return err
+	}
+	_, err = expectGoAway(c, http2.ErrCodeFlowControl)
+	return err
+}
+
+// zeroWindowUpdate sends a connection WINDOW_UPDATE of zero, a
+// PROTOCOL_ERROR.
+func zeroWindowUpdate(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.Framer.WriteWindowUpdate(0, 0); err != nil {
+		return err
+	}
+	_, err = expectGoAway(c, http2.ErrCodeProtocol)
+	return err
+}
+
+// goAwayOnError completes one request, then sends DATA on stream 0. The
+// server must send a PROTOCOL_ERROR GOAWAY naming the request's stream as
+// the last it processed, and close the connection.
+func goAwayOnError(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	if _, _, err := c.Response(id); err != nil {
+		return fmt.Errorf("request before the error: %w", err)
+	}
+	if err := c.Framer.WriteData(0, false, []byte("x")); err != nil {
+		return err
+	}
+	ga, err := expectGoAway(c, http2.ErrCodeProtocol)
+	if err != nil {
+		return err
+	}
+	if ga.LastStreamID != id {
+		return fmt.Errorf("GOAWAY last stream %d, want %d", ga.LastStreamID, id)
+	}
+	for {
+		_, err := c.Next()
+		if errors.Is(err, errIdle) {
+			return errors.New("connection left open after GOAWAY")
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// cancelStream stalls a response with a one-byte stream window, resets
+// the stream with CANCEL and then raises the initial window, which would
+// let a server that ignored the reset carry on sending. No DATA may follow
+// for the cancelled stream, and a new request on the same connection must
+// succeed.
+func cancelStream(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx, http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	for started := false; !started; {
+		f, err := c.Next()
+		if err != nil {
+			return fmt.Errorf("waiting for the response: %w", err)
+		}
+		if f.Header().StreamID != id {
+			continue
+		}
+		if endsStream(f) {
+			return fmt.Errorf("%s ended within one byte; it needs a longer response", t.Path)
+		}
+		_, started = f.(*http2.DataFrame)
+	}
+	if err := c.Framer.WriteRSTStream(id, http2.ErrCodeCancel); err != nil {
+		return err
+	}
+	if err := c.Framer.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 65535}); err != nil {
+		return err
+	}
+	// Anything sent after the ACK was sent after the reset was read
+	acked := false
+	for {
+		wait := t.Timeout
+		if acked {
+			wait = stall
+		}
+		f, err := c.NextWithin(wait)
+		if errors.Is(err, errIdle) && acked {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("after RST_STREAM: %w", err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			acked = acked || f.IsAck()
+		case *http2.DataFrame:
+			if acked && f.StreamID == id {
+				return fmt.Errorf("server sent %d bytes of DATA on stream %d after RST_STREAM", f.Length, id)
+			}
+		case *http2.GoAwayFrame:
+			return fmt.Errorf("GOAWAY %v after a client RST_STREAM", f.ErrCode)
+		}
+	}
+	next, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(c, next, "200"); err != nil {
+		return fmt.Errorf("request after the cancellation: %w", err)
+	}
+	return nil
+}
+
+func expectGoAway(c *Conn, code http2.ErrCode) (*http2.GoAwayFrame, error) {
+	ga, err := c.GoAway()
+	if err != nil {
+		return nil, err
+	}
+	if ga.ErrCode != code {
+		return ga, fmt.Errorf("GOAWAY with %v, want %v", ga.ErrCode, code)
+	}
+	return ga, nil
+}
+
+// upgradeGet switches to HTTP/2 with the h2c Upgrade handshake instead of
+// prior knowledge. The GET that carried the upgrade must be answered on
+// stream 1, and the connection must then serve new streams.
+func upgradeGet(ctx context.Context, t Target) error {
+	c, err := Upgrade(ctx, t.Addr, t.Timeout, http.MethodGet, t.Path, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := expectStatus(c, 1, "200"); err != nil {
+		return fmt.Errorf("upgraded request: %w", err)
+	}
+	id, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(c, id, "200"); err != nil {
+		return fmt.Errorf("request after the upgrade: %w", err)
+	}
+	return nil
+}
+
+// upgradeBody upgrades with a POST, whose body the server has to read as
+// HTTP/1.1 before switching, and expects it echoed on stream 1.
+func upgradeBody(ctx context.Context, t Target) error {
+	sent := []byte(`{"upgrade":"h2c","part":1}`)
+	c, err := Upgrade(ctx, t.Addr, t.Timeout, http.MethodPost, t.Echo, sent)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	status, body, err := c.Response(1)
+	switch {
+	case err != nil:
+		return fmt.Errorf("upgraded request: %w", err)
+	case status != "200":
+		return fmt.Errorf("upgraded request: status %s, want 200", status)
+	case !bytes.Equal(body, sent):
+		return fmt.Errorf("upgraded request: echoed %q, sent %q", body, sent)
+	}
+	return nil
+}
+
+func expectStatus(c *Conn, id uint32, want string) error {
+	status, _, err := c.Response(id)
+	if err != nil {
+		return err
+	}
+	if status != want {
+		return fmt.Errorf("status %s, want %s", status, want)
+	}
+	return nil
+}
+
+// Push checks server push: it enables push, requests Target.Push, a page
+// the server is known to push resources with, and requires at least one
+// PUSH_PROMISE. Every promise must be for a safe request on a new
+// even-numbered stream, and every pushed response must complete and match
+// what a normal GET of its path returns. It is not in Checks since it
+// needs a pushing page.
+var Push = Check{"Server push", serverPush}
+
+type pushed struct {
+	path   string
+	status string
+	body   []byte
+	done   bool
+}
+
+func serverPush(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx, http2.Setting{ID: http2.SettingEnablePush, Val: 1})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := c.Request(http.MethodGet, t.Push, true)
+	if err != nil {
+		return err
+	}
+	pushes := map[uint32]*pushed{}
+	var promised []uint32
+	done := false
+	pending := func() bool {
+		for _, p := range pushes {
+			if !p.done {
+				return true
+			}
+		}
+		return !done
+	}
+	for pending() {
+		f, err := c.Next()
+		if err != nil {
+			return fmt.Errorf("after %d promises: %w", len(promised), err)
+		}
+		sid := f.Header().StreamID
+		switch f := f.(type) {
+		case *http2.PushPromiseFrame:
+			if sid != id {
+				return fmt.Errorf("PUSH_PROMISE on stream %d, want %d", sid, id)
+			}
+			if f.PromiseID%2 != 0 || len(promised) > 0 && f.PromiseID <= promised[len(promised)-1] {
+				return fmt.Errorf("promised stream %d, want a new even-numbered one", f.PromiseID)
+			}
+			if !f.HeadersEnded() {
+				return errors.New("PUSH_PROMISE continued in CONTINUATION frames, which this check cannot follow")
+			}
+			// Decoded with the connection's decoder, whose table the
+			// promise updates like any header block
+			fields, err := c.Framer.ReadMetaHeaders.DecodeFull(f.HeaderBlockFragment())
+			if err != nil {
+				return fmt.Errorf("PUSH_PROMISE headers: %w", err)
+			}
+			var method, path string
+			for _, hf := range fields {
+				switch hf.Name {
+				case ":method":
+					method = hf.Value
+				case ":path":
+					path = hf.Value
+				}
+			}
+			if method != http.MethodGet && method != http.MethodHead {
+				return fmt.Errorf("promised %s %s, but only safe requests may be pushed", method, path)
+			}
+			pushes[f.PromiseID] = &pushed{path: path}
+			promised = append(promised, f.PromiseID)
+		case *http2.MetaHeadersFrame:
+			if p := pushes[sid]; p != nil && p.status == "" {
+				p.status = f.PseudoValue("status")
+			}
+		case *http2.DataFrame:
+			if p := pushes[sid]; p != nil {
+				p.body = append(p.body, f.Data()...)
+			}
+			// Read as a browser would, so large pushes cannot stall
+			if n := f.Length; n > 0 {
+				c.Framer.WriteWindowUpdate(0, n)
+				if !f.StreamEnded() {
+					c.Framer.WriteWindowUpdate(sid, n)
+				}
+			}
+		case *http2.RSTStreamFrame:
+			if p := pushes[sid]; p != nil {
+				return fmt.Errorf("pushed %s reset with %v", p.path, f.ErrCode)
+			}
+			if sid == id {
+				return fmt.Errorf("stream reset with %v", f.ErrCode)
+			}
+		case *http2.GoAwayFrame:
+			return fmt.Errorf("GOAWAY %v", f.ErrCode)
+		}
+		if !endsStream(f) {
+			continue
+		}
+		if sid == id {
+			done = true
+		} else if p := pushes[sid]; p != nil {
+			p.done = true
+		}
+	}
+	if len(promised) == 0 {
+		return fmt.Errorf("no PUSH_PROMISE for %s", t.Push)
+	}
+
+	// Compared on a connection without push, so the fetches push nothing
+	plain, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	for _, pid := range promised {
+		p := pushes[pid]
+		if p.status != "200" {
+			return fmt.Errorf("pushed %s: status %s, want 200", p.path, p.status)
+		}
+		rid, err := plain.Request(http.MethodGet, p.path, true)
+		if err != nil {
+			return err
+		}
+		_, body, err := plain.Response(rid)
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", p.path, err)
+		}
+		if !bytes.Equal(p.body, body) {
+			return fmt.Errorf("pushed %s differs from a normal GET (%d bytes pushed, %d fetched)", p.path, len(p.body), len(body))
+		}
+	}
+	return nil
+}
+
+// endsStream reports whether f is a DATA or HEADERS frame with END_STREAM,
+// a flag other frame types use for something else.
+func endsStream(f http2.Frame) bool {
+	switch f := f.(type) {
+	case *http2.DataFrame:
+		return f.StreamEnded()
+	case *http2.MetaHeadersFrame:
+		return f.StreamEnded()
+	}
+	return false
+}
@@ -0,0 +1,542 @@
+package h2check
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+)
+
+// serve runs handle on every connection accepted by a local listener.
+func serve(t *testing.T, handle func(net.Conn)) Target {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(c)
+		}
+	}()
+	return Target{Addr: ln.Addr().String(), Path: "/", Timeout: 2 * time.Second}
+}
+
+func TestChecksPass(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/echo" {
+			io.Copy(w, r.Body)
+			return
+		}
+		w.Write([]byte(strings.Repeat("Hello, World! ", 200)))
+	})
+	// h2c serves both prior knowledge and the Upgrade handshake
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer srv.Close()
+	target := Target{Addr: srv.Listener.Addr().String(), Path: "/", Echo: "/echo", Timeout: 2 * time.Second}
+	runChecks(t, target, slices.Concat(Checks, Upgrades))
+}
+
+// deviations are where x/net takes a choice RFC 9113 allows but the check
+// does not.
+var deviations = map[string]string{
+	"Max concurrent streams":                           "reset with PROTOCOL_ERROR, want REFUSED_STREAM",
+	"Malformed: HEADERS padding longer than the frame": "stream 1 reset with PROTOCOL_ERROR, want a GOAWAY",
+	// x/net bounds a header block's bytes but not its frame count
+	"Empty CONTINUATION flood": "without rejecting it",
+}
+
+// runChecks runs checks against an x/net server, which must pass all but
+// the deviations.
+func runChecks(t *testing.T, target Target, checks []Check) {
+	t.Helper()
+	for _, check := range checks {
+		err := check.Run(context.Background(), target)
+		if want, ok := deviations[check.Name]; ok {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: got %v, want an error containing %q", check.Name, err, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", check.Name, err)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/echo" {
+			io.Copy(w, r.Body)
+			return
+		}
+		w.Write([]byte(strings.Repeat("Hello, World! ", 200)))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	target := Target{
+		Addr:    srv.Listener.Addr().String(),
+		Path:    "/",
+		Echo:    "/echo",
+		Timeout: 2 * time.Second,
+		TLS:     &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"},
+	}
+	runChecks(t, target, slices.Concat(Checks, TLSChecks))
+
+	// The test certificate is issued for example.com too, so that name
+	// verifies but the roots do not
+	target.TLS = &tls.Config{ServerName: "example.com"}
+	for _, check := range TLSChecks {
+		if check.Name == "Certificate validation" {
+			if err := check.Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), "not trusted") {
+				t.Errorf("got %v without the test root", err)
+			}
+		}
+	}
+
+	// An HTTP/1-only server selects http/1.1
+	h1 := httptest.NewTLSServer(http.NotFoundHandler())
+	defer h1.Close()
+	target = Target{Addr: h1.Listener.Addr().String(), Path: "/", Timeout: 2 * time.Second, TLS: h1.Client().Transport.(*http.Transport).TLSClientConfig}
+	if err := TLSChecks[0].Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), `"http/1.1" from h2 and http/1.1`) {
+		t.Errorf("got %v from an HTTP/1 server", err)
+	}
+}
+
+// limitStreams serves a server advertising settings that refuses every
+// stream beyond two, and answers stream 1 once its request ends.
+func limitStreams(t *testing.T, settings ...http2.Setting) Target {
+	return serve(t, func(c net.Conn) {
+		defer c.Close()
+		buf := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		fr := http2.NewFramer(c, c)
+		fr.WriteSettings(settings...)
+		streams := 0
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch f := f.(type) {
+			case *http2.SettingsFrame:
+				if !f.IsAck() {
+					fr.WriteSettingsAck()
+				}
+			case *http2.HeadersFrame:
+				if streams++; streams > 2 {
+					fr.WriteRSTStream(f.StreamID, http2.ErrCodeRefusedStream)
+				}
+			case *http2.DataFrame:
+				if f.StreamEnded() {
+					var block bytes.Buffer
+					hpack.NewEncoder(&block).WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+					fr.WriteHeaders(http2.HeadersFrameParam{StreamID: f.StreamID, BlockFragment: block.Bytes(), EndHeaders: true, EndStream: true})
+				}
+			}
+		}
+	})
+}
+
+func TestMaxStreams(t *testing.T) {
+	i := slices.IndexFunc(Checks, func(c Check) bool { return c.Name == "Max concurrent streams" })
+	target := limitStreams(t, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 2})
+	target.Echo = "/echo"
+	if err := Checks[i].Run(context.Background(), target); err != nil {
+		t.Error(err)
+	}
+
+	// Too many streams to open, or no limit at all
+	for _, settings := range [][]http2.Setting{{{ID: http2.SettingMaxConcurrentStreams, Val: 1<<31 - 1}}, nil} {
+		var log strings.Builder
+		target := limitStreams(t, settings...)
+		target.Echo, target.Log = "/echo", &log
+		if err := Checks[i].Run(context.Background(), target); err != nil || !strings.Contains(log.String(), "Skipped") {
+			t.Errorf("settings %v: got %v, log %q", settings, err, log.String())
+		}
+	}
+}
+
+func TestChecksFail(t *testing.T) {
+	// A server that completes the handshake and then ignores everything
+	target := serve(t, func(c net.Conn) {
+		defer c.Close()
+		buf := make([]byte, len(http2.ClientPreface))
+		if _, err := c.Read(buf); err != nil {
+			return
+		}
+		fr := http2.NewFramer(c, c)
+		fr.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 1})
+		for {
+			if _, err := fr.ReadFrame(); err != nil {
+				return
+			}
+		}
+	})
+	target.Timeout = 300 * time.Millisecond
+	want := map[string]string{
+		"SETTINGS exchange":          "only clients may enable",
+		"WINDOW_UPDATE overflow":     "no GOAWAY",
+		"h2c Upgrade with GET":       "reading upgrade response",
+		"RST_STREAM cancellation":    "waiting for the response",
+		"Oversized headers get 431":  "KiB of headers",
+		"CONTINUATION flood":         "without rejecting it",
+		"Malformed: PING of 7 bytes": "closed without GOAWAY FRAME_SIZE_ERROR",
+		"Random malformed frames":    "on a new connection: no frame",
+	}
+	target.Fuzz = 1
+	for _, check := range slices.Concat(Checks, Upgrades, Malformed, []Check{Fuzz}) {
+		sub, ok := want[check.Name]
+		if !ok {
+			continue
+		}
+		err := check.Run(context.Background(), target)
+		if err == nil || !strings.Contains(err.Error(), sub) {
+			t.Errorf("%s: got %v, want an error containing %q", check.Name, err, sub)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			if p, ok := w.(http.Pusher); ok {
+				p.Push("/style.css", nil)
+				p.Push("/app.js", nil)
+			}
+			w.Write([]byte("<html></html>"))
+		case "/style.css":
+			w.Write([]byte(strings.Repeat("body{} ", 20000)))
+		default:
+			w.Write([]byte("console.log(1)"))
+		}
+	})
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer srv.Close()
+	target := Target{Addr: srv.Listener.Addr().String(), Push: "/page", Timeout: 2 * time.Second}
+	if err := Push.Run(context.Background(), target); err != nil {
+		t.Error(err)
+	}
+	target.Push = "/app.js"
+	if err := Push.Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), "no PUSH_PROMISE") {
+		t.Errorf("got %v for a page that pushes nothing", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	chunk := []byte(strings.Repeat("x", 64<<10))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for range 256 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	})
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer srv.Close()
+	var log strings.Builder
+	target := Target{
+		Addr:       srv.Listener.Addr().String(),
+		Stream:     "/",
+		StreamRate: 256 << 20,
+		MaxGrowth:  1 << 30,
+		ServerPID:  os.Getpid(),
+		Timeout:    2 * time.Second,
+		Log:        &log,
+	}
+	if err := Stream.Run(context.Background(), target); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), "Streamed 16.8 MB") || !strings.Contains(log.String(), "Server RSS") {
+		t.Errorf("unexpected log %q", log.String())
+	}
+
+	// A server that sends without waiting for the window
+	target = serve(t, func(c net.Conn) {
+		defer c.Close()
+		buf := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		fr := http2.NewFramer(c, c)
+		fr.WriteSettings()
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			if _, ok := f.(*http2.HeadersFrame); ok {
+				break
+			}
+		}
+		var block bytes.Buffer
+		hpack.NewEncoder(&block).WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+		fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block.Bytes(), EndHeaders: true})
+		for range 8 {
+			fr.WriteData(1, false, chunk[:16<<10])
+		}
+		io.Copy(io.Discard, c)
+	})
+	target.Stream = "/"
+	if err := Stream.Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), "overran the window") {
+		t.Errorf("got %v from a server ignoring flow control", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	h2s := &http2.Server{}
+	srv := httptest.NewUnstartedServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}), h2s))
+	// Lets Shutdown send GOAWAY on the h2c connections
+	if err := http2.ConfigureServer(srv.Config, h2s); err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	defer srv.Close()
+	target := Target{
+		Addr:     srv.Listener.Addr().String(),
+		Path:     "/",
+		Echo:     "/echo",
+		Timeout:  2 * time.Second,
+		Shutdown: func() error { return srv.Config.Shutdown(context.Background()) },
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Shutdown.Run(ctx, target); err != nil {
+		t.Error(err)
+	}
+
+	// A server that answers every stream, even after its GOAWAY
+	shutdown := make(chan struct{})
+	target = serve(t, func(c net.Conn) {
+		defer c.Close()
+		buf := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		fr := http2.NewFramer(c, c)
+		fr.WriteSettings()
+		var last uint32
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch f := f.(type) {
+			case *http2.PingFrame:
+				fr.WritePing(true, f.Data)
+				<-shutdown
+				fr.WriteGoAway(last, http2.ErrCodeNo, nil)
+			case *http2.HeadersFrame:
+				last = f.StreamID
+				if last > 5 {
+					var block bytes.Buffer
+					hpack.NewEncoder(&block).WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+					fr.WriteHeaders(http2.HeadersFrameParam{StreamID: f.StreamID, BlockFragment: block.Bytes(), EndHeaders: true, EndStream: true})
+				}
+			}
+		}
+	})
+	target.Echo = "/echo"
+	target.Shutdown = func() error { close(shutdown); return nil }
+	if err := Shutdown.Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), "opened after GOAWAY, was answered") {
+		t.Errorf("got %v from a server answering after GOAWAY", err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	patient := httptest.NewServer(h2c.NewHandler(echo, &http2.Server{}))
+	defer patient.Close()
+	// h2c takes only the body deadline from the HTTP/1 server; an HTTP/2
+	// header block counts against the idle timeout
+	strict := httptest.NewUnstartedServer(h2c.NewHandler(echo, &http2.Server{IdleTimeout: 300 * time.Millisecond}))
+	strict.Config.ReadHeaderTimeout = 300 * time.Millisecond
+	strict.Config.ReadTimeout = 300 * time.Millisecond
+	strict.Start()
+	defer strict.Close()
+
+	for _, check := range Timeouts {
+		var log strings.Builder
+		target := Target{Addr: strict.Listener.Addr().String(), Path: "/", Echo: "/echo", Timeout: 500 * time.Millisecond, SlowLimit: time.Second, Log: &log}
+		if err := check.Run(context.Background(), target); err != nil {
+			t.Errorf("%s: %v", check.Name, err)
+		}
+		if !strings.Contains(log.String(), "Timed out after") {
+			t.Errorf("%s: unexpected log %q", check.Name, log.String())
+		}
+		target.Addr = patient.Listener.Addr().String()
+		target.SlowLimit = 300 * time.Millisecond
+		if err := check.Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), "still waiting") {
+			t.Errorf("%s: got %v from a server without timeouts", check.Name, err)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer srv.Close()
+	var log strings.Builder
+	target := Target{Addr: srv.Listener.Addr().String(), Path: "/", Echo: "/echo", Timeout: time.Second, Fuzz: 500, FuzzSeed: 1, Log: &log}
+	runChecks(t, target, append(Malformed, Fuzz))
+	if !strings.Contains(log.String(), "Sent 500 random frames") {
+		t.Errorf("unexpected log %q", log.String())
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	// Accepts Extended CONNECT and echoes each WebSocket frame unmasked
+	target := serve(t, func(c net.Conn) {
+		defer c.Close()
+		buf := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		fr := http2.NewFramer(c, c)
+		fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+		fr.WriteSettings(http2.Setting{ID: http2.SettingEnableConnectProtocol, Val: 1})
+		var in []byte
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch f := f.(type) {
+			case *http2.MetaHeadersFrame:
+				status := "400"
+				if f.PseudoValue("method") == http.MethodConnect && f.PseudoValue("protocol") == "websocket" {
+					status = "200"
+				}
+				var block bytes.Buffer
+				hpack.NewEncoder(&block).WriteField(hpack.HeaderField{Name: ":status", Value: status})
+				fr.WriteHeaders(http2.HeadersFrameParam{StreamID: f.StreamID, BlockFragment: block.Bytes(), EndHeaders: true, EndStream: status != "200"})
+			case *http2.DataFrame:
+				in = append(in, f.Data()...)
+				// Frames here are short, masked and unfragmented
+				for len(in) >= 2 {
+					n, hdr := int(in[1]&0x7f), 6
+					if n == 126 {
+						n, hdr = int(in[2])<<8|int(in[3]), 8
+					}
+					if len(in) < hdr+n {
+						break
+					}
+					payload := in[hdr : hdr+n]
+					for i := range payload {
+						payload[i] ^= in[hdr-4+i%4]
+					}
+					out := []byte{in[0]}
+					if n < 126 {
+						out = append(out, byte(n))
+					} else {
+						out = append(out, 126, byte(n>>8), byte(n))
+					}
+					fr.WriteData(f.StreamID, false, append(out, payload...))
+					in = in[hdr+n:]
+				}
+			}
+		}
+	})
+	target.WebSocket = "/ws"
+	if err := WebSocket.Run(context.Background(), target); err != nil {
+		t.Error(err)
+	}
+
+	// Go's server leaves Extended CONNECT off by default
+	srv := httptest.NewServer(h2c.NewHandler(http.NotFoundHandler(), &http2.Server{}))
+	defer srv.Close()
+	target = Target{Addr: srv.Listener.Addr().String(), WebSocket: "/ws", Timeout: time.Second}
+	if err := WebSocket.Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), "does not advertise") {
+		t.Errorf("got %v from a server without Extended CONNECT", err)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, c := range []struct {
+		server *http2.Server
+		want   string
+	}{
+		{&http2.Server{}, "Connection kept"},
+		{&http2.Server{IdleTimeout: 200 * time.Millisecond}, "GOAWAY NO_ERROR after"},
+	} {
+		srv := httptest.NewServer(h2c.NewHandler(handler, c.server))
+		var log strings.Builder
+		target := Target{Addr: srv.Listener.Addr().String(), Path: "/", Timeout: 2 * time.Second, Idle: 400 * time.Millisecond, Log: &log}
+		if err := Idle.Run(context.Background(), target); err != nil {
+			t.Errorf("idle timeout %v: %v", c.server.IdleTimeout, err)
+		}
+		if !strings.Contains(log.String(), c.want) {
+			t.Errorf("idle timeout %v: unexpected log %q", c.server.IdleTimeout, log.String())
+		}
+		srv.Close()
+	}
+
+	// Answers the first request, then drops the connection silently by
+	// closing it, or by no longer reading it
+	for _, close := range []bool{true, false} {
+		target := serve(t, func(c net.Conn) {
+			defer c.Close()
+			buf := make([]byte, len(http2.ClientPreface))
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			fr := http2.NewFramer(c, c)
+			fr.WriteSettings()
+			for {
+				f, err := fr.ReadFrame()
+				if err != nil {
+					return
+				}
+				if h, ok := f.(*http2.HeadersFrame); ok {
+					fr.WriteHeaders(http2.HeadersFrameParam{StreamID: h.StreamID, BlockFragment: []byte{0x88}, EndHeaders: true, EndStream: true})
+					break
+				}
+			}
+			if close {
+				time.Sleep(100 * time.Millisecond)
+				return
+			}
+			io.Copy(io.Discard, c)
+		})
+		target.Timeout = 500 * time.Millisecond
+		target.Idle = 300 * time.Millisecond
+		want := "without GOAWAY"
+		if !close {
+			want = "PING after"
+		}
+		if err := Idle.Run(context.Background(), target); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v from a server dropping the connection, want %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package h2check
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// defaultHeaderLimit stands in for the header list limit of a server that
+// advertises none.
+const defaultHeaderLimit = 1 << 20
+
+// hpackTable sends requests that each carry headers repeated from earlier
+// requests, which the encoder sends as dynamic table references, and
+// headers seen once, which churn the table and evict older entries. If the
+// server's decoder and our encoder disagree about the table, a request
+// fails to decode or the connection dies with COMPRESSION_ERROR.
+func hpackTable(ctx context.Context, t Target) error {
+	const requests, repeated, unique = 20, 50, 50
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for i := range requests {
+		var fields []hpack.HeaderField
+		for j := range repeated {
+			fields = append(fields, hpack.HeaderField{Name: "x-repeated-" + strconv.Itoa(j), Value: "value-" + strconv.Itoa(j)})
+		}
+		for j := range unique {
+			fields = append(fields, hpack.HeaderField{
+				Name:  "x-unique-" + strconv.Itoa(j),
+				Value: fmt.Sprintf("request-%d-header-%d", i, j),
+			})
+		}
+		id, err := c.Request(http.MethodGet, t.Path, true, fields...)
+		if err != nil {
+			return err
+		}
+		if err := expectStatus(c, id, "200"); err != nil {
+			return fmt.Errorf("request %d of %d: %w", i+1, requests, err)
+		}
+	}
+	return nil
+}
+
+// headerLimit sends a header list larger than the server accepts. The
+// server must still decode it, to keep its HPACK table in step, answer
+// 431 on that stream alone and go on serving the connection.
+func headerLimit(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	limit, ok := c.Settings[http2.SettingMaxHeaderListSize]
+	if !ok {
+		limit = defaultHeaderLimit
+	}
+	// Each field counts its name and value plus 32 bytes of overhead
+	value := strings.Repeat("h", 4000)
+	var fields []hpack.HeaderField
+	for size := 0; size <= int(limit); size += len(value) + 32 + 12 {
+		fields = append(fields, hpack.HeaderField{Name: fmt.Sprintf("x-big-%05d", len(fields)), Value: value})
+	}
+	id, err := c.Request(http.MethodGet, t.Path, true, fields...)
+	if err != nil {
+		return err
+	}
+	status, _, err := c.Response(id)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%d KiB of headers: %w", len(fields)*len(value)>>10, err)
+	case status != "431":
+		return fmt.Errorf("%d KiB of headers: status %s, want 431", len(fields)*len(value)>>10, status)
+	}
+	next, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(c, next, "200"); err != nil {
+		return fmt.Errorf("request after the 431: %w", err)
+	}
+	return nil
+}
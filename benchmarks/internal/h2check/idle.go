@@ -0,0 +1,176 @@
+package h2check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Idle checks how the server treats an idle connection. After one request
+// the connection sits silent for Target.Idle and is then pinged, and then
+// for as long again it is kept alive with a PING every tenth of that.
+// Either way the server may keep the connection, answering every PING, or
+// close it cleanly with a NO_ERROR GOAWAY first; closing it without one,
+// or leaving a PING unanswered, is the silent drop that strands clients.
+// Server PINGs are acknowledged and counted. It is not in Checks since it
+// takes twice Target.Idle.
+var Idle = Check{"Idle connection keepalive", idleConn}
+
+func idleConn(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(c, id, "200"); err != nil {
+		return err
+	}
+	idle := &idler{c: c, t: t, last: id, start: time.Now()}
+	for _, every := range []time.Duration{t.Idle, t.Idle / 10} {
+		if closed, err := idle.run(t.Idle, every); closed || err != nil {
+			return err
+		}
+	}
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Connection kept for %v: %d PINGs answered, %d server PINGs\n", time.Since(idle.start).Round(time.Second), idle.acked, idle.serverPings)
+	}
+	return nil
+}
+
+// idler holds a connection idle, reading what the server sends.
+type idler struct {
+	c     *Conn
+	t     Target
+	last  uint32 // the last stream the client opened
+	start time.Time
+
+	acked, serverPings int
+	goAway             *http2.GoAwayFrame
+	goAwayAfter        time.Duration
+}
+
+// run idles for d, sending a PING every so often, and reports whether the
+// server closed the connection cleanly meanwhile.
+func (i *idler) run(d, every time.Duration) (closed bool, err error) {
+	end := time.Now().Add(d)
+	for time.Now().Before(end) {
+		wait := min(every, time.Until(end))
+		if closed, err := i.read(wait); closed || err != nil {
+			return closed, err
+		}
+		if i.goAway != nil {
+			return i.drain()
+		}
+		if closed, err := i.ping(); closed || err != nil {
+			return closed, err
+		}
+		if i.goAway != nil {
+			return i.drain()
+		}
+	}
+	return false, nil
+}
+
+// drain reads what follows a GOAWAY until the server closes the
+// connection, giving it as long as the idle time to do so.
+func (i *idler) drain() (bool, error) {
+	wait := i.t.Idle + i.t.Timeout
+	for {
+		f, err := i.c.NextWithin(wait)
+		if errors.Is(err, errIdle) {
+			if i.t.Log != nil {
+				fmt.Fprintf(i.t.Log, "  GOAWAY NO_ERROR after %v idle, but the connection was left open\n", i.goAwayAfter)
+			}
+			return true, nil
+		}
+		if err != nil {
+			return i.closed(err)
+		}
+		if err := i.frame(f); err != nil {
+			return true, err
+		}
+	}
+}
+
+// ping sends a PING and waits for its ACK, reporting like run.
+func (i *idler) ping() (closed bool, err error) {
+	data := [8]byte{'k', 'e', 'e', 'p', 'a', 'l', 'i', 'v'}
+	if err := i.c.Framer.WritePing(false, data); err != nil {
+		return i.closed(err)
+	}
+	deadline := time.Now().Add(i.t.Timeout)
+	for {
+		f, err := i.c.NextWithin(time.Until(deadline))
+		if errors.Is(err, errIdle) {
+			return true, fmt.Errorf("PING after %v idle not answered: the connection was dropped silently", time.Since(i.start).Round(time.Second))
+		}
+		if err != nil {
+			return i.closed(err)
+		}
+		if p, ok := f.(*http2.PingFrame); ok && p.IsAck() && p.Data == data {
+			i.acked++
+			return false, nil
+		}
+		if err := i.frame(f); err != nil {
+			return true, err
+		}
+	}
+}
+
+// read reads frames for d, answering server PINGs.
+func (i *idler) read(d time.Duration) (closed bool, err error) {
+	end := time.Now().Add(d)
+	for {
+		f, err := i.c.NextWithin(time.Until(end))
+		if errors.Is(err, errIdle) {
+			return false, nil
+		}
+		if err != nil {
+			return i.closed(err)
+		}
+		if err := i.frame(f); err != nil {
+			return true, err
+		}
+	}
+}
+
+// frame handles a frame that arrives while idle.
+func (i *idler) frame(f http2.Frame) error {
+	switch f := f.(type) {
+	case *http2.PingFrame:
+		if !f.IsAck() {
+			i.serverPings++
+			return i.c.Framer.WritePing(true, f.Data)
+		}
+	case *http2.GoAwayFrame:
+		if f.ErrCode != http2.ErrCodeNo {
+			return fmt.Errorf("idle connection ended with GOAWAY %v, want NO_ERROR", f.ErrCode)
+		}
+		if f.LastStreamID < i.last {
+			return fmt.Errorf("idle GOAWAY last stream %d, but stream %d was answered", f.LastStreamID, i.last)
+		}
+		i.goAway, i.goAwayAfter = f, time.Since(i.start).Round(time.Millisecond)
+	}
+	return nil
+}
+
+// closed decides whether a failed read or write is the clean end of an
+// idle connection: one the server announced with GOAWAY.
+func (i *idler) closed(err error) (bool, error) {
+	after := time.Since(i.start).Round(time.Millisecond)
+	if i.goAway == nil {
+		return true, fmt.Errorf("server closed the connection after %v idle without GOAWAY: %w", after, err)
+	}
+	if i.t.Log != nil {
+		fmt.Fprintf(i.t.Log, "  GOAWAY NO_ERROR after %v idle, closed after %v, %d PINGs answered\n", i.goAwayAfter, after, i.acked)
+	}
+	return true, nil
+}
@@ -0,0 +1,349 @@
+package h2check
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// malformed is one invalid frame sequence and the error RFC 9113 requires
+// for it. send returns the stream a stream error is due on, or 0 for a
+// connection error. A zero code means the server must ignore the frame.
+type malformed struct {
+	name string
+	send func(c *Conn, t Target) (uint32, error)
+	code http2.ErrCode
+}
+
+var malformedFrames = []malformed{
+	{"DATA padding longer than the frame", func(c *Conn, t Target) (uint32, error) {
+		id, err := c.Request(http.MethodPost, t.Echo, false)
+		if err != nil {
+			return 0, err
+		}
+		return 0, c.Framer.WriteRawFrame(http2.FrameData, http2.FlagDataPadded, id, []byte{5, 'h', 'i'})
+	}, http2.ErrCodeProtocol},
+	{"HEADERS padding longer than the frame", func(c *Conn, t Target) (uint32, error) {
+		block := headerBlock(c, http.MethodGet, t.Path)
+		payload := append([]byte{byte(len(block) + 1)}, block...)
+		return 0, c.Framer.WriteRawFrame(http2.FrameHeaders, http2.FlagHeadersPadded|http2.FlagHeadersEndHeaders|http2.FlagHeadersEndStream, c.nextID, payload)
+	}, http2.ErrCodeProtocol},
+	{"HEADERS on an even stream", func(c *Conn, t Target) (uint32, error) {
+		c.nextID = 2
+		_, err := c.Request(http.MethodGet, t.Path, true)
+		return 0, err
+	}, http2.ErrCodeProtocol},
+	{"HEADERS on a lower stream than the last", func(c *Conn, t Target) (uint32, error) {
+		c.nextID = 5
+		if _, err := c.Request(http.MethodGet, t.Path, true); err != nil {
+			return 0, err
+		}
+		c.nextID = 3
+		_, err := c.Request(http.MethodGet, t.Path, true)
+		return 0, err
+	}, http2.ErrCodeProtocol},
+	{"Frame larger than SETTINGS_MAX_FRAME_SIZE", func(c *Conn, t Target) (uint32, error) {
+		id, err := c.Request(http.MethodPost, t.Echo, false)
+		if err != nil {
+			return 0, err
+		}
+		return 0, writeRaw(c, http2.FrameData, 0, id, make([]byte, c.maxFrameSize()+1))
+	}, http2.ErrCodeFrameSize},
+	{"SETTINGS not a multiple of 6 bytes", func(c *Conn, t Target) (uint32, error) {
+		return 0, writeRaw(c, http2.FrameSettings, 0, 0, make([]byte, 5))
+	}, http2.ErrCodeFrameSize},
+	{"SETTINGS ACK with a payload", func(c *Conn, t Target) (uint32, error) {
+		return 0, writeRaw(c, http2.FrameSettings, http2.FlagSettingsAck, 0, make([]byte, 6))
+	}, http2.ErrCodeFrameSize},
+	{"SETTINGS on a stream", func(c *Conn, t Target) (uint32, error) {
+		return 0, writeRaw(c, http2.FrameSettings, 0, 1, nil)
+	}, http2.ErrCodeProtocol},
+	{"SETTINGS_ENABLE_PUSH of 2", func(c *Conn, t Target) (uint32, error) {
+		return 0, c.Framer.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 2})
+	}, http2.ErrCodeProtocol},
+	{"SETTINGS_INITIAL_WINDOW_SIZE above 2^31-1", func(c *Conn, t Target) (uint32, error) {
+		return 0, c.Framer.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 31})
+	}, http2.ErrCodeFlowControl},
+	{"SETTINGS_MAX_FRAME_SIZE below 16384", func(c *Conn, t Target) (uint32, error) {
+		return 0, c.Framer.WriteSettings(http2.Setting{ID: http2.SettingMaxFrameSize, Val: 16383})
+	}, http2.ErrCodeProtocol},
+	{"PING of 7 bytes", func(c *Conn, t Target) (uint32, error) {
+		return 0, writeRaw(c, http2.FramePing, 0, 0, make([]byte, 7))
+	}, http2.ErrCodeFrameSize},
+	{"PING on a stream", func(c *Conn, t Target) (uint32, error) {
+		return 0, writeRaw(c, http2.FramePing, 0, 1, make([]byte, 8))
+	}, http2.ErrCodeProtocol},
+	{"WINDOW_UPDATE of 3 bytes", func(c *Conn, t Target) (uint32, error) {
+		return 0, writeRaw(c, http2.FrameWindowUpdate, 0, 0, make([]byte, 3))
+	}, http2.ErrCodeFrameSize},
+	{"WINDOW_UPDATE on an idle stream", func(c *Conn, t Target) (uint32, error) {
+		return 0, c.Framer.WriteWindowUpdate(c.nextID, 1)
+	}, http2.ErrCodeProtocol},
+	{"RST_STREAM on stream 0", func(c *Conn, t Target) (uint32, error) {
+		return 0, c.Framer.WriteRSTStream(0, http2.ErrCodeCancel)
+	}, http2.ErrCodeProtocol},
+	{"RST_STREAM on an idle stream", func(c *Conn, t Target) (uint32, error) {
+		return 0, c.Framer.WriteRSTStream(c.nextID, http2.ErrCodeCancel)
+	}, http2.ErrCodeProtocol},
+	{"CONTINUATION without HEADERS", func(c *Conn, t Target) (uint32, error) {
+		return 0, c.Framer.WriteContinuation(c.nextID, true, headerBlock(c, http.MethodGet, t.Path))
+	}, http2.ErrCodeProtocol},
+	{"Frame between HEADERS and CONTINUATION", func(c *Conn, t Target) (uint32, error) {
+		block := headerBlock(c, http.MethodGet, t.Path)
+		id := c.nextID
+		if err := c.Framer.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: block[:1], EndStream: true}); err != nil {
+			return 0, err
+		}
+		if err := c.Framer.WritePing(false, [8]byte{}); err != nil {
+			return 0, err
+		}
+		return 0, c.Framer.WriteContinuation(id, true, block[1:])
+	}, http2.ErrCodeProtocol},
+	{"Stream depending on itself", func(c *Conn, t Target) (uint32, error) {
+		id := c.nextID
+		return id, c.Framer.WritePriority(id, http2.PriorityParam{StreamDep: id, Weight: 15})
+	}, http2.ErrCodeProtocol},
+	{"DATA after END_STREAM", func(c *Conn, t Target) (uint32, error) {
+		id, err := c.Request(http.MethodPost, t.Echo, false)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.Framer.WriteData(id, true, []byte("x")); err != nil {
+			return 0, err
+		}
+		return id, c.Framer.WriteData(id, false, []byte("y"))
+	}, http2.ErrCodeStreamClosed},
+	{"Uppercase header name", func(c *Conn, t Target) (uint32, error) {
+		return c.Request(http.MethodGet, t.Path, true, hpack.HeaderField{Name: "X-Upper", Value: "1"})
+	}, http2.ErrCodeProtocol},
+	{"Pseudo-header after a regular header", func(c *Conn, t Target) (uint32, error) {
+		return c.Request(http.MethodGet, t.Path, true, hpack.HeaderField{Name: "x-before", Value: "1"}, hpack.HeaderField{Name: ":path", Value: t.Path})
+	}, http2.ErrCodeProtocol},
+	{"Unknown frame type is ignored", func(c *Conn, t Target) (uint32, error) {
+		return 0, writeRaw(c, 0xfa, 0xff, 0, []byte("h2check"))
+	}, 0},
+}
+
+// Malformed sends invalid frames, one case per connection, and requires
+// the connection or stream error RFC 9113 prescribes for each. After every
+// case a new connection must still get an answer, so a server that
+// crashes or wedges fails at the frame that did it. It is not in Checks
+// since it is meant for fuzzing runs.
+var Malformed []Check
+
+func init() {
+	for _, m := range malformedFrames {
+		Malformed = append(Malformed, Check{"Malformed: " + m.name, func(ctx context.Context, t Target) error {
+			return m.run(ctx, t)
+		}})
+	}
+}
+
+func (m malformed) run(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := m.send(c, t)
+	if err != nil {
+		return err
+	}
+	if m.code == 0 {
+		// Ignored, so a request on the same connection still works
+		next, err := c.Request(http.MethodGet, t.Path, true)
+		if err != nil {
+			return err
+		}
+		if err := expectStatus(c, next, "200"); err != nil {
+			return fmt.Errorf("request after the frame: %w", err)
+		}
+	} else if err := expectError(c, id, m.code); err != nil {
+		return err
+	}
+	if err := alive(ctx, t); err != nil {
+		return fmt.Errorf("afterwards, on a new connection: %w", err)
+	}
+	return nil
+}
+
+// expectError reads until the server reports code: with GOAWAY for a
+// connection error, or for a stream error with RST_STREAM of id or,
+// since a server may treat any stream error as a connection error, with
+// GOAWAY. A response on id fails the stream error, except STREAM_CLOSED:
+// the request ended before the frame that closes it, so the server may
+// answer it first.
+func expectError(c *Conn, id uint32, code http2.ErrCode) error {
+	want := "GOAWAY"
+	if id != 0 {
+		want = fmt.Sprintf("RST_STREAM of stream %d", id)
+	}
+	for {
+		f, err := c.Next()
+		switch {
+		case errors.Is(err, errIdle):
+			return fmt.Errorf("no %s %v: the frame was ignored", want, code)
+		case err != nil:
+			return fmt.Errorf("connection closed without %s %v: %w", want, code, err)
+		}
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			if f.ErrCode != code {
+				return fmt.Errorf("GOAWAY %v, want %v", f.ErrCode, code)
+			}
+			return nil
+		case *http2.RSTStreamFrame:
+			if id == 0 {
+				return fmt.Errorf("stream %d reset with %v, want a GOAWAY %v for the connection", f.StreamID, f.ErrCode, code)
+			}
+			if f.StreamID != id {
+				continue
+			}
+			if f.ErrCode != code {
+				return fmt.Errorf("stream reset with %v, want %v", f.ErrCode, code)
+			}
+			return nil
+		case *http2.MetaHeadersFrame:
+			if id != 0 && f.StreamID == id && code != http2.ErrCodeStreamClosed {
+				return fmt.Errorf("stream %d answered with status %s instead of %s %v", id, f.PseudoValue("status"), want, code)
+			}
+		}
+	}
+}
+
+// alive checks that the server still answers a request on a new
+// connection.
+func alive(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	return expectStatus(c, id, "200")
+}
+
+// headerBlock encodes a request's header block with the connection's
+// encoder.
+func headerBlock(c *Conn, method, path string) []byte {
+	c.encBuf.Reset()
+	for _, hf := range []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: c.scheme},
+		{Name: ":authority", Value: c.authority},
+		{Name: ":path", Value: path},
+	} {
+		c.enc.WriteField(hf)
+	}
+	return append([]byte(nil), c.encBuf.Bytes()...)
+}
+
+// writeRaw writes a frame without the framer, which refuses payloads
+// over the maximum frame size.
+func writeRaw(c *Conn, typ http2.FrameType, flags http2.Flags, stream uint32, payload []byte) error {
+	hdr := make([]byte, 9, 9+len(payload))
+	hdr[0], hdr[1], hdr[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	hdr[3], hdr[4] = byte(typ), byte(flags)
+	binary.BigEndian.PutUint32(hdr[5:], stream&(1<<31-1))
+	_, err := c.Conn.Write(append(hdr, payload...))
+	return err
+}
+
+// Fuzz sends Target.Fuzz random frames: random types, including unknown
+// ones, flags, streams and payloads, from a generator seeded with
+// Target.FuzzSeed so that a failure can be replayed. After each frame the
+// server must answer a PING, or end the connection with GOAWAY or by
+// closing it, in which case the next frame goes on a new connection. A
+// server that stops responding, or refuses new connections, fails the
+// check at the frame responsible.
+var Fuzz = Check{"Random malformed frames", fuzzFrames}
+
+func fuzzFrames(ctx context.Context, t Target) error {
+	rng := rand.New(rand.NewPCG(uint64(t.FuzzSeed), 0))
+	var c *Conn
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+	conns := 0
+	for i := range t.Fuzz {
+		if c == nil {
+			var err error
+			if c, err = t.dial(ctx); err != nil {
+				return fmt.Errorf("seed %d, before frame %d: %w", t.FuzzSeed, i+1, err)
+			}
+			conns++
+		}
+		typ := http2.FrameType(rng.IntN(12))
+		flags := http2.Flags(rng.IntN(256))
+		stream := []uint32{0, 1, 2, 3, rng.Uint32N(1 << 31)}[rng.IntN(5)]
+		payload := make([]byte, rng.IntN(64))
+		for j := range payload {
+			payload[j] = byte(rng.IntN(256))
+		}
+		what := fmt.Sprintf("frame %d (%v flags=%#x stream=%d length=%d, seed %d)", i+1, typ, uint8(flags), stream, len(payload), t.FuzzSeed)
+		if err := writeRaw(c, typ, flags, stream, payload); err != nil {
+			c.Close()
+			c = nil
+			continue
+		}
+		ended, err := answersPing(c)
+		if err != nil {
+			return fmt.Errorf("after %s: %w", what, err)
+		}
+		if ended {
+			c.Close()
+			c = nil
+			if err := alive(ctx, t); err != nil {
+				return fmt.Errorf("after %s, on a new connection: %w", what, err)
+			}
+		}
+	}
+	if c != nil {
+		c.Close()
+		c = nil
+	}
+	if err := alive(ctx, t); err != nil {
+		return fmt.Errorf("after all %d frames (seed %d): %w", t.Fuzz, t.FuzzSeed, err)
+	}
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Sent %d random frames on %d connections (seed %d)\n", t.Fuzz, conns, t.FuzzSeed)
+	}
+	return nil
+}
+
+// answersPing sends a PING and reads until it is acknowledged or the
+// server ends the connection, which it reports.
+func answersPing(c *Conn) (ended bool, err error) {
+	data := [8]byte{'h', '2', 'c', 'h', 'e', 'c', 'k'}
+	if err := c.Framer.WritePing(false, data); err != nil {
+		return true, nil
+	}
+	for {
+		f, err := c.Next()
+		switch {
+		case errors.Is(err, errIdle):
+			return false, errors.New("server stopped responding")
+		case err != nil:
+			return true, nil
+		}
+		switch f := f.(type) {
+		case *http2.PingFrame:
+			if f.IsAck() && f.Data == data {
+				return false, nil
+			}
+		case *http2.GoAwayFrame:
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package h2check
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// Shutdown checks graceful shutdown: with requests in flight it calls
+// Target.Shutdown and requires a NO_ERROR GOAWAY whose last stream ID
+// covers every in-flight stream. The in-flight requests must then complete
+// in full, a request opened after the GOAWAY must be refused or ignored
+// rather than answered, and the server must close the connection once it
+// is done. It is not in Checks since it stops the server.
+var Shutdown = Check{"Graceful shutdown", gracefulShutdown}
+
+// maxStreamID is the last stream ID a server sends in a first GOAWAY when
+// it shuts down in two steps, before it knows which streams it will serve.
+const maxStreamID = 1<<31 - 1
+
+type inFlight struct {
+	sent   []byte
+	status string
+	body   []byte
+	done   bool
+}
+
+func gracefulShutdown(ctx context.Context, t Target) error {
+	const streams = 3
+	if t.Shutdown == nil {
+		return errors.New("no way to shut the server down")
+	}
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// Requests whose bodies are half sent, so the server cannot finish
+	// them before it shuts down
+	reqs := map[uint32]*inFlight{}
+	var last uint32
+	for i := range streams {
+		id, err := c.Request(http.MethodPost, t.Echo, false)
+		if err != nil {
+			return err
+		}
+		r := &inFlight{sent: fmt.Appendf(nil, "request %d, first half;", i+1)}
+		if err := c.Framer.WriteData(id, false, r.sent); err != nil {
+			return err
+		}
+		reqs[id], last = r, id
+	}
+	// The server answers the PING after it has read the requests
+	if err := c.Framer.WritePing(false, [8]byte{'s', 'h', 'u', 't', 'd', 'o', 'w', 'n'}); err != nil {
+		return err
+	}
+	read := func() (http2.Frame, error) {
+		f, err := c.Next()
+		if err != nil {
+			return nil, err
+		}
+		if err := track(c, reqs, f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	for pinged := false; !pinged; {
+		f, err := read()
+		if err != nil {
+			return fmt.Errorf("before the shutdown: %w", err)
+		}
+		if p, ok := f.(*http2.PingFrame); ok {
+			pinged = p.IsAck()
+		}
+		if ga, ok := f.(*http2.GoAwayFrame); ok {
+			return fmt.Errorf("GOAWAY %v before the shutdown", ga.ErrCode)
+		}
+	}
+
+	// Shutdown may wait for the in-flight requests, which need this
+	// goroutine to finish them
+	stopped := make(chan error, 1)
+	go func() { stopped <- t.Shutdown() }()
+
+	var goAway *http2.GoAwayFrame
+	for goAway == nil || goAway.LastStreamID == maxStreamID {
+		f, err := read()
+		if err != nil {
+			return fmt.Errorf("waiting for GOAWAY after the shutdown: %w", err)
+		}
+		if ga, ok := f.(*http2.GoAwayFrame); ok {
+			if ga.ErrCode != http2.ErrCodeNo {
+				return fmt.Errorf("GOAWAY with %v, want NO_ERROR for a graceful shutdown", ga.ErrCode)
+			}
+			goAway = ga
+		}
+	}
+	if goAway.LastStreamID < last {
+		return fmt.Errorf("GOAWAY last stream %d, but streams up to %d were in flight", goAway.LastStreamID, last)
+	}
+
+	late, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	for id, r := range reqs {
+		more := []byte("second half")
+		if err := c.Framer.WriteData(id, true, more); err != nil {
+			return fmt.Errorf("finishing stream %d: %w", id, err)
+		}
+		r.sent = append(r.sent, more...)
+	}
+	pending := func() bool {
+		for _, r := range reqs {
+			if !r.done {
+				return true
+			}
+		}
+		return false
+	}
+	// Until the server closes the connection, nothing may answer the late
+	// request
+	closed := false
+	for !closed {
+		f, err := read()
+		switch {
+		case errors.Is(err, errIdle) && pending():
+			return fmt.Errorf("in-flight requests did not complete: %w", err)
+		case errors.Is(err, errIdle):
+			return errors.New("connection left open after the in-flight requests completed")
+		case err != nil && pending():
+			return fmt.Errorf("connection closed with requests in flight: %w", err)
+		case err != nil:
+			closed = true
+			continue
+		}
+		if f.Header().StreamID != late {
+			continue
+		}
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			return fmt.Errorf("request on stream %d, opened after GOAWAY, was answered with status %s", late, f.PseudoValue("status"))
+		case *http2.RSTStreamFrame:
+			if f.ErrCode != http2.ErrCodeRefusedStream {
+				return fmt.Errorf("request opened after GOAWAY reset with %v, want REFUSED_STREAM", f.ErrCode)
+			}
+		}
+	}
+	for id, r := range reqs {
+		switch {
+		case r.status != "200":
+			return fmt.Errorf("in-flight stream %d: status %s, want 200", id, r.status)
+		case !bytes.Equal(r.body, r.sent):
+			return fmt.Errorf("in-flight stream %d: echoed %q, sent %q", id, r.body, r.sent)
+		}
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			return fmt.Errorf("shutting down: %w", err)
+		}
+	case <-ctx.Done():
+		return errors.New("shutdown did not return after the connection closed")
+	}
+	return nil
+}
+
+// track records what f carries for the in-flight requests, opening the
+// windows as DATA arrives.
+func track(c *Conn, reqs map[uint32]*inFlight, f http2.Frame) error {
+	r := reqs[f.Header().StreamID]
+	if r == nil {
+		return nil
+	}
+	switch f := f.(type) {
+	case *http2.MetaHeadersFrame:
+		if r.status == "" {
+			r.status = f.PseudoValue("status")
+		}
+	case *http2.DataFrame:
+		r.body = append(r.body, f.Data()...)
+		if n := f.Length; n > 0 {
+			c.Framer.WriteWindowUpdate(0, n)
+			if !f.StreamEnded() {
+				c.Framer.WriteWindowUpdate(f.StreamID, n)
+			}
+		}
+	case *http2.RSTStreamFrame:
+		return fmt.Errorf("in-flight stream %d reset with %v", f.StreamID, f.ErrCode)
+	}
+	r.done = r.done || endsStream(f)
+	return nil
+}
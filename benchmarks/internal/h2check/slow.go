@@ -0,0 +1,202 @@
+package h2check
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Timeouts check that the server gives up on a client that stalls midway
+// through a request instead of holding the connection forever. The client
+// trickles the request a byte or a header at a time, so a timer that only
+// resets on each read cannot pass. The server must answer 408, reset the
+// stream, send GOAWAY or close the connection within Target.SlowLimit.
+// They are not in Checks since each takes about that long.
+var Timeouts = []Check{
+	{"Slow HTTP/1.1 headers", slowH1Headers},
+	{"Slow HTTP/1.1 body", slowH1Body},
+	{"Slow HTTP/2 headers", slowH2Headers},
+	{"Slow HTTP/2 body", slowH2Body},
+}
+
+// trickleEvery is how often a slow client sends a little more.
+func trickleEvery(t Target) time.Duration {
+	return max(min(t.SlowLimit/4, time.Second), 10*time.Millisecond)
+}
+
+// timedOut logs how the server ended a slow request.
+func timedOut(t Target, how string, after time.Duration) error {
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Timed out after %v: %s\n", after.Round(time.Millisecond), how)
+	}
+	return nil
+}
+
+func slowH1Headers(ctx context.Context, t Target) error {
+	head := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\n", t.Path, t.Addr)
+	return slowH1(ctx, t, head, func(i int) string { return fmt.Sprintf("X-Slow-%d: 1\r\n", i) })
+}
+
+func slowH1Body(ctx context.Context, t Target) error {
+	head := fmt.Sprintf("POST %s HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n\r\nx", t.Echo, t.Addr, 1<<20)
+	return slowH1(ctx, t, head, func(int) string { return "x" })
+}
+
+// slowH1 sends head and then trickles more of the request until the
+// server gives up on it.
+func slowH1(ctx context.Context, t Target, head string, more func(i int) string) error {
+	var nc net.Conn
+	var err error
+	if t.TLS != nil {
+		nc, err = dialTLS(ctx, t, "http/1.1")
+	} else {
+		var d net.Dialer
+		nc, err = d.DialContext(ctx, "tcp", t.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	start := time.Now()
+	if _, err := io.WriteString(nc, head); err != nil {
+		return err
+	}
+	ended := make(chan string, 1)
+	go func() { ended <- h1Wait(nc) }()
+	tick := time.NewTicker(trickleEvery(t))
+	defer tick.Stop()
+	limit := time.NewTimer(t.SlowLimit + t.Timeout)
+	defer limit.Stop()
+	for i := 0; ; i++ {
+		select {
+		case how := <-ended:
+			return timedOut(t, how, time.Since(start))
+		case <-limit.C:
+			return fmt.Errorf("server still waiting for the rest of the request after %v", time.Since(start).Round(time.Millisecond))
+		case <-tick.C:
+			// A write to a closed connection fails, which h1Wait reports
+			io.WriteString(nc, more(i))
+		}
+	}
+}
+
+// h1Wait reads what the server sends until it answers 408 or closes the
+// connection, and describes which.
+func h1Wait(nc net.Conn) string {
+	br := bufio.NewReader(nc)
+	how := "closed the connection"
+	for {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			return how
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusRequestTimeout {
+			return "answered 408"
+		}
+		how = fmt.Sprintf("answered %s, then closed the connection", resp.Status)
+	}
+}
+
+// slowH2Headers opens a stream with a header block that never ends,
+// adding a header in a CONTINUATION frame at a time.
+func slowH2Headers(ctx context.Context, t Target) error {
+	var buf bytes.Buffer
+	enc := hpack.NewEncoder(&buf)
+	open := func(c *Conn) (uint32, error) {
+		for _, hf := range []hpack.HeaderField{
+			{Name: ":method", Value: http.MethodGet},
+			{Name: ":scheme", Value: c.scheme},
+			{Name: ":authority", Value: c.authority},
+			{Name: ":path", Value: t.Path},
+		} {
+			enc.WriteField(hf)
+		}
+		id := c.nextID
+		c.nextID += 2
+		return id, c.Framer.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: buf.Bytes(), EndStream: true})
+	}
+	more := func(c *Conn, id uint32) error {
+		buf.Reset()
+		enc.WriteField(hpack.HeaderField{Name: "x-slow", Value: "1"})
+		return c.Framer.WriteContinuation(id, false, buf.Bytes())
+	}
+	return slowH2(ctx, t, open, more)
+}
+
+// slowH2Body opens a POST stream and sends its body a byte at a time.
+func slowH2Body(ctx context.Context, t Target) error {
+	open := func(c *Conn) (uint32, error) {
+		id, err := c.Request(http.MethodPost, t.Echo, false)
+		if err != nil {
+			return id, err
+		}
+		return id, c.Framer.WriteData(id, false, []byte("x"))
+	}
+	more := func(c *Conn, id uint32) error {
+		return c.Framer.WriteData(id, false, []byte("x"))
+	}
+	return slowH2(ctx, t, open, more)
+}
+
+// slowH2 opens a stream and then trickles more of its request until the
+// server gives up on it.
+func slowH2(ctx context.Context, t Target, open func(*Conn) (uint32, error), more func(*Conn, uint32) error) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	start := time.Now()
+	id, err := open(c)
+	if err != nil {
+		return err
+	}
+	every := trickleEvery(t)
+	limit := start.Add(t.SlowLimit + t.Timeout)
+	next := start.Add(every)
+	status := ""
+	for time.Now().Before(limit) {
+		f, err := c.NextWithin(time.Until(next))
+		if errors.Is(err, errIdle) {
+			// A write to a closed connection fails, which the next read
+			// reports
+			more(c, id)
+			next = next.Add(every)
+			continue
+		}
+		if err != nil {
+			return timedOut(t, "closed the connection", time.Since(start))
+		}
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return timedOut(t, fmt.Sprintf("sent GOAWAY %v", f.ErrCode), time.Since(start))
+		case *http2.RSTStreamFrame:
+			if f.StreamID == id {
+				return timedOut(t, fmt.Sprintf("reset the stream with %v", f.ErrCode), time.Since(start))
+			}
+		case *http2.MetaHeadersFrame:
+			if f.StreamID != id {
+				continue
+			}
+			if status = f.PseudoValue("status"); status == "408" {
+				return timedOut(t, "answered 408", time.Since(start))
+			}
+		}
+	}
+	err = fmt.Errorf("server still waiting for the rest of the request after %v", time.Since(start).Round(time.Millisecond))
+	if status != "" {
+		err = fmt.Errorf("%w, having answered %s", err, status)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package h2check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"benchmarks/internal/srvstat"
+	"golang.org/x/net/http2"
+)
+
+// Stream checks a large streamed response read slowly: it downloads
+// Target.Stream, reopening the windows no faster than Target.StreamRate,
+// and fails if the server ever sends more than the stream or connection
+// window allows. With a server pid or stats URL it also samples the
+// server and fails if its RSS grows by more than Target.MaxGrowth, which
+// is what buffering the response instead of waiting for the reader looks
+// like. It is not in Checks since it needs a streaming path.
+var Stream = Check{"Large streamed response", largeStream}
+
+const (
+	// defaultWindow is the initial flow-control window of RFC 9113.
+	defaultWindow = 65535
+	// lowWindow is where a slow reader stops to pause: less than one
+	// full-size DATA frame left.
+	lowWindow = 16 << 10
+	// minPause is the shortest pause, long enough for DATA sent in
+	// disregard of the window to arrive.
+	minPause = 2 * time.Millisecond
+)
+
+func largeStream(ctx context.Context, t Target) error {
+	var sampler *srvstat.Sampler
+	if t.ServerPID > 0 || t.ServerStats != "" {
+		var err error
+		if sampler, err = srvstat.Start(t.ServerPID, t.ServerStats); err != nil {
+			return err
+		}
+	}
+	c, err := t.dial(ctx)
+	if err != nil {
+		sampler.Stop()
+		return err
+	}
+	defer c.Close()
+	received, elapsed, err := slowRead(c, t)
+	sampler.Stop()
+	if err != nil {
+		return fmt.Errorf("after %.1f MB: %w", float64(received)/1e6, err)
+	}
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Streamed %.1f MB in %v (%.1f MB/s)\n", float64(received)/1e6, elapsed.Round(time.Millisecond), float64(received)/1e6/elapsed.Seconds())
+	}
+	if sampler == nil {
+		return nil
+	}
+	sum := sampler.Summary()
+	if sum.RSSStart < 0 {
+		return errors.New("server stats report no memory figure")
+	}
+	growth := sum.RSSPeak - sum.RSSStart
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Server RSS: %.1f MB, peak %.1f MB (+%.1f MB)\n", float64(sum.RSSStart)/1e6, float64(sum.RSSPeak)/1e6, float64(growth)/1e6)
+	}
+	if growth > t.MaxGrowth {
+		return fmt.Errorf("server RSS grew %.1f MB while streaming, more than %.1f MB", float64(growth)/1e6, float64(t.MaxGrowth)/1e6)
+	}
+	return nil
+}
+
+// slowRead reads the response to a GET of t.Stream the way a slow client
+// does: it lets the server fill the window, pauses long enough to hold the
+// rate to t.StreamRate, and only then gives the window back. A server that
+// ignores the window shows up as DATA arriving during the pause.
+func slowRead(c *Conn, t Target) (received int64, elapsed time.Duration, err error) {
+	id, err := c.Request(http.MethodGet, t.Stream, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := time.Now()
+	defer func() { elapsed = time.Since(start) }()
+	// Dial leaves both windows at the protocol default; the stream's is
+	// never larger than the connection's, so one figure tracks both
+	window := int64(defaultWindow)
+	for {
+		wait := t.Timeout
+		if window < lowWindow {
+			due := time.Duration(float64(received) / t.StreamRate * float64(time.Second))
+			wait = max(due-time.Since(start), minPause)
+		}
+		f, err := c.NextWithin(wait)
+		if errors.Is(err, errIdle) && window < lowWindow {
+			if err := c.Framer.WriteWindowUpdate(id, uint32(defaultWindow-window)); err != nil {
+				return received, 0, err
+			}
+			if err := c.Framer.WriteWindowUpdate(0, uint32(defaultWindow-window)); err != nil {
+				return received, 0, err
+			}
+			window = defaultWindow
+			continue
+		}
+		if err != nil {
+			return received, 0, err
+		}
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return received, 0, fmt.Errorf("GOAWAY %v", f.ErrCode)
+		case *http2.RSTStreamFrame:
+			if f.StreamID == id {
+				return received, 0, fmt.Errorf("stream reset with %v", f.ErrCode)
+			}
+		case *http2.MetaHeadersFrame:
+			if s := f.PseudoValue("status"); f.StreamID == id && s != "" && s != "200" {
+				return received, 0, fmt.Errorf("status %s, want 200", s)
+			}
+		case *http2.DataFrame:
+			if f.StreamID != id {
+				continue
+			}
+			received += int64(len(f.Data()))
+			if window -= int64(f.Length); window < 0 {
+				return received, 0, fmt.Errorf("server overran the window by %d bytes", -window)
+			}
+		}
+		if f.Header().StreamID == id && endsStream(f) {
+			return received, 0, nil
+		}
+	}
+}
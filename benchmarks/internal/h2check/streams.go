@@ -0,0 +1,91 @@
+package h2check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// maxStreamsLimit is the most streams maxStreams opens to reach the
+// server's limit.
+const maxStreamsLimit = 1000
+
+// maxStreams opens a few more streams than the server's
+// SETTINGS_MAX_CONCURRENT_STREAMS, keeping every one open by never ending
+// its request body. The excess must be reset with REFUSED_STREAM, which
+// tells a client the request was not processed and is safe to retry,
+// rather than queued or answered, and the streams within the limit must
+// carry on. Without a limit, which HTTP/2 allows, or with one above
+// maxStreamsLimit, the check is skipped.
+func maxStreams(ctx context.Context, t Target) error {
+	const extra = 3
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	limit, ok := c.Settings[http2.SettingMaxConcurrentStreams]
+	switch {
+	case !ok:
+		return skip(t, "server advertises no %v", http2.SettingMaxConcurrentStreams)
+	case limit > maxStreamsLimit:
+		return skip(t, "%v of %d is more streams than the check opens", http2.SettingMaxConcurrentStreams, limit)
+	}
+	// Once our ACK of its SETTINGS is acknowledged in turn, the server
+	// cannot blame the excess on a settings race
+	if err := c.Framer.WriteSettings(); err != nil {
+		return err
+	}
+	if err := settingsAck(c); err != nil {
+		return err
+	}
+	within := map[uint32]bool{}
+	var first uint32
+	for i := range int(limit) + extra {
+		id, err := c.Request(http.MethodPost, t.Echo, false)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			first = id
+		}
+		within[id] = i < int(limit)
+	}
+	for refused := 0; refused < extra; {
+		f, err := c.Next()
+		if errors.Is(err, errIdle) {
+			return fmt.Errorf("%d of %d streams beyond the limit of %d were not refused", extra-refused, extra, limit)
+		}
+		if err != nil {
+			return err
+		}
+		id := f.Header().StreamID
+		switch f := f.(type) {
+		case *http2.GoAwayFrame:
+			return fmt.Errorf("GOAWAY %v: the connection was dropped instead of the excess streams", f.ErrCode)
+		case *http2.RSTStreamFrame:
+			if within[id] {
+				return fmt.Errorf("stream %d, within the limit of %d, reset with %v", id, limit, f.ErrCode)
+			}
+			if f.ErrCode != http2.ErrCodeRefusedStream {
+				return fmt.Errorf("stream beyond the limit of %d reset with %v, want REFUSED_STREAM", limit, f.ErrCode)
+			}
+			refused++
+		case *http2.MetaHeadersFrame:
+			if !within[id] {
+				return fmt.Errorf("stream %d, beyond the limit of %d, was answered", id, limit)
+			}
+		}
+	}
+	// The streams within the limit still work
+	if err := c.Framer.WriteData(first, true, nil); err != nil {
+		return err
+	}
+	if err := expectStatus(c, first, "200"); err != nil {
+		return fmt.Errorf("stream %d after the refusals: %w", first, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package h2check
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// TLSChecks check what a TLS listener negotiates before any HTTP/2 frame:
+// ALPN, in both directions, and the server's certificate. They need
+// Target.TLS, and replace Upgrades for a TLS listener.
+var TLSChecks = []Check{
+	{"ALPN negotiates h2", alpnH2},
+	{"Certificate validation", certificate},
+	{"ALPN falls back to HTTP/1.1", alpnFallback},
+}
+
+// otherName is a host name no certificate should cover.
+const otherName = "h2check.invalid"
+
+// alpnH2 offers h2 and http/1.1, as browsers do, and requires h2 over TLS
+// 1.2 or later, which RFC 9113 makes the minimum, and a working request.
+func alpnH2(ctx context.Context, t Target) error {
+	nc, err := dialTLS(ctx, t, http2.NextProtoTLS, "http/1.1")
+	if err != nil {
+		return err
+	}
+	state := nc.ConnectionState()
+	nc.Close()
+	if state.NegotiatedProtocol != http2.NextProtoTLS {
+		return fmt.Errorf("server selected ALPN protocol %q from h2 and http/1.1, want h2", state.NegotiatedProtocol)
+	}
+	if state.Version < tls.VersionTLS12 {
+		return fmt.Errorf("negotiated %s, but HTTP/2 needs TLS 1.2 or later", tls.VersionName(state.Version))
+	}
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, err := c.Request(http.MethodGet, t.Path, true)
+	if err != nil {
+		return err
+	}
+	return expectStatus(c, id, "200")
+}
+
+// certificate requires the server's chain to verify against the target's
+// roots for its server name, and to fail for an unrelated name, so that a
+// client checking names would reject an impostor using the same
+// certificate. The leaf's subject and expiry go to Target.Log.
+func certificate(ctx context.Context, t Target) error {
+	if t.TLS == nil {
+		return errors.New("no TLS config")
+	}
+	if t.TLS.InsecureSkipVerify {
+		return errors.New("certificate verification is disabled")
+	}
+	nc, err := dialTLS(ctx, t, http2.NextProtoTLS)
+	if err != nil {
+		return fmt.Errorf("certificate not trusted: %w", err)
+	}
+	leaf := nc.ConnectionState().PeerCertificates[0]
+	nc.Close()
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, "  Certificate: %s, issued by %s, expires in %d days\n",
+			leaf.Subject, leaf.Issuer, int(time.Until(leaf.NotAfter).Hours()/24))
+	}
+
+	other := t
+	other.TLS = t.TLS.Clone()
+	other.TLS.ServerName = otherName
+	nc, err = dialTLS(ctx, other, http2.NextProtoTLS)
+	if err == nil {
+		nc.Close()
+		return fmt.Errorf("certificate also verifies for %s", otherName)
+	}
+	var mismatch x509.HostnameError
+	if !errors.As(err, &mismatch) {
+		return fmt.Errorf("handshake for %s: %w, want a host name mismatch", otherName, err)
+	}
+	return nil
+}
+
+// alpnFallback offers only http/1.1, as an HTTP/1 client does. The server
+// must accept the handshake and answer a plain HTTP/1.1 request.
+func alpnFallback(ctx context.Context, t Target) error {
+	nc, err := dialTLS(ctx, t, "http/1.1")
+	if err != nil {
+		return fmt.Errorf("server refused a client offering only http/1.1: %w", err)
+	}
+	defer nc.Close()
+	// Selecting nothing leaves HTTP/1.1 as the default, which Go's own
+	// server does when it has no protocol in common
+	if p := nc.ConnectionState().NegotiatedProtocol; p != "http/1.1" && p != "" {
+		return fmt.Errorf("server selected ALPN protocol %q from http/1.1 alone", p)
+	}
+	nc.SetDeadline(time.Now().Add(t.Timeout))
+	if _, err := fmt.Fprintf(nc, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", t.Path, t.Addr); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(nc), nil)
+	if err != nil {
+		return fmt.Errorf("reading HTTP/1.1 response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s, want HTTP/1.1 200", resp.Proto, resp.Status)
+	}
+	return nil
+}
+
+// dialTLS completes a TLS handshake with the target, offering protos by
+// ALPN.
+func dialTLS(ctx context.Context, t Target, protos ...string) (*tls.Conn, error) {
+	if t.TLS == nil {
+		return nil, errors.New("no TLS config")
+	}
+	cfg := t.TLS.Clone()
+	cfg.NextProtos = protos
+	d := tls.Dialer{Config: cfg}
+	nc, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return nc.(*tls.Conn), nil
+}
@@ -0,0 +1,149 @@
+package h2check
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"benchmarks/internal/ws"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// WebSocket checks WebSocket over HTTP/2 (RFC 8441): the server must
+// advertise SETTINGS_ENABLE_CONNECT_PROTOCOL, accept an Extended CONNECT
+// with :protocol websocket to Target.WebSocket with 200, and echo text and
+// binary messages sent on the stream. It ends with the close handshake. It
+// is not in Checks since it needs a WebSocket echo path.
+var WebSocket = Check{"WebSocket over HTTP/2", websocketH2}
+
+func websocketH2(ctx context.Context, t Target) error {
+	c, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if c.Settings[http2.SettingEnableConnectProtocol] != 1 {
+		return fmt.Errorf("server does not advertise %v=1", http2.SettingEnableConnectProtocol)
+	}
+	id, err := c.Request(http.MethodConnect, t.WebSocket, false,
+		hpack.HeaderField{Name: ":protocol", Value: "websocket"},
+		hpack.HeaderField{Name: "sec-websocket-version", Value: "13"})
+	if err != nil {
+		return err
+	}
+	for status := ""; status != "200"; {
+		f, err := c.Next()
+		if err != nil {
+			return fmt.Errorf("waiting for the CONNECT response: %w", err)
+		}
+		if ga, ok := f.(*http2.GoAwayFrame); ok {
+			return fmt.Errorf("GOAWAY %v", ga.ErrCode)
+		}
+		if f.Header().StreamID != id {
+			continue
+		}
+		switch f := f.(type) {
+		case *http2.RSTStreamFrame:
+			return fmt.Errorf("CONNECT reset with %v", f.ErrCode)
+		case *http2.MetaHeadersFrame:
+			if status = f.PseudoValue("status"); status != "200" {
+				return fmt.Errorf("CONNECT answered with status %s, want 200", status)
+			}
+			if f.StreamEnded() {
+				return errors.New("CONNECT response ended the stream")
+			}
+		}
+	}
+
+	conn := ws.NewConn(&stream{c: c, id: id})
+	binary := make([]byte, 4096)
+	for i := range binary {
+		binary[i] = byte(i % 251)
+	}
+	for _, m := range []struct {
+		op   byte
+		data []byte
+	}{
+		{ws.OpText, []byte("hello over HTTP/2")},
+		{ws.OpBinary, binary},
+	} {
+		if err := conn.WriteMessage(m.op, m.data); err != nil {
+			return err
+		}
+		op, got, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("waiting for the echo: %w", err)
+		}
+		if op != m.op || !bytes.Equal(got, m.data) {
+			return fmt.Errorf("sent opcode %d with %d bytes, echoed opcode %d with %d bytes", m.op, len(m.data), op, len(got))
+		}
+	}
+	if err := conn.WriteMessage(ws.OpClose, []byte{0x03, 0xE8}); err != nil {
+		return err
+	}
+	if _, _, err := conn.ReadMessage(); !errors.Is(err, ws.ErrClosed) {
+		return fmt.Errorf("close frame answered with %v, want a close frame", err)
+	}
+	return nil
+}
+
+// stream carries the bytes of one stream's DATA frames, for a protocol
+// tunnelled through Extended CONNECT. Reads open the windows again as
+// data arrives. Writes ignore the send window, so they must stay within
+// the initial 65535 bytes.
+type stream struct {
+	c   *Conn
+	id  uint32
+	buf []byte
+	eof bool
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		f, err := s.c.Next()
+		if err != nil {
+			return 0, err
+		}
+		if ga, ok := f.(*http2.GoAwayFrame); ok {
+			return 0, fmt.Errorf("GOAWAY %v", ga.ErrCode)
+		}
+		if f.Header().StreamID != s.id {
+			continue
+		}
+		switch f := f.(type) {
+		case *http2.DataFrame:
+			s.buf = append(s.buf, f.Data()...)
+			if n := f.Length; n > 0 {
+				s.c.Framer.WriteWindowUpdate(0, n)
+				if !f.StreamEnded() {
+					s.c.Framer.WriteWindowUpdate(s.id, n)
+				}
+			}
+		case *http2.RSTStreamFrame:
+			return 0, fmt.Errorf("stream reset with %v", f.ErrCode)
+		}
+		s.eof = endsStream(f)
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	limit := int(s.c.maxFrameSize())
+	for n := 0; n < len(p); {
+		chunk := p[n:min(len(p), n+limit)]
+		if err := s.c.Framer.WriteData(s.id, false, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,178 @@
+// Package h2ping measures the round trip time of HTTP/2 connections with
+// PING frames. A PING is acknowledged by the server's frame reader without
+// touching a handler, so its RTT is the network plus the server's read
+// loop, while request latency adds queuing and handling on top. Reporting
+// both tells a network-bound p99 from a server-bound one.
+package h2ping
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/report"
+
+	"golang.org/x/net/http2"
+)
+
+// Pinger pings every connection of the transports it pools for, once per
+// Interval. It is safe for concurrent use; a nil *Pinger pings nothing.
+type Pinger struct {
+	Interval time.Duration
+	RTT      *hdr.Histogram
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	conns  int
+}
+
+// New returns a pinger, or nil when interval is not positive.
+func New(interval time.Duration) *Pinger {
+	if interval <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pinger{Interval: interval, RTT: hdr.New(), ctx: ctx, cancel: cancel}
+}
+
+// Pool returns a connection pool for t that starts pinging each
+// connection it opens with t.DialTLSContext, which must be set. Set it as
+// t.ConnPool; a nil pinger returns nil, which keeps the transport's own
+// pool.
+func (p *Pinger) Pool(t *http2.Transport) http2.ClientConnPool {
+	if p == nil {
+		return nil
+	}
+	return &pool{p: p, t: t, conns: map[string][]*http2.ClientConn{}}
+}
+
+// Stop ends the pinging and waits for the PINGs in flight.
+func (p *Pinger) Stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.cancel()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Pinger) watch(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.conns++
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		tick := time.NewTicker(p.Interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-tick.C:
+			}
+			start := time.Now()
+			if err := cc.Ping(p.ctx); err != nil {
+				// The connection is gone, or the run is over
+				return
+			}
+			p.RTT.Record(time.Since(start))
+		}
+	}()
+}
+
+// Annotate prints the PING RTT to w and records its percentiles in the
+// config of every result.
+func (p *Pinger) Annotate(w io.Writer, results []report.Result) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	conns := p.conns
+	p.mu.Unlock()
+	if p.RTT.Count() == 0 {
+		fmt.Fprintf(w, "\nPING RTT: no acknowledged PINGs on %d connections\n", conns)
+		return
+	}
+	sum := p.RTT.Summary()
+	fmt.Fprintf(w, "\nPING RTT (%d PINGs on %d connections): p50=%v p99=%v max=%v\n", sum.Count, conns, sum.P50, sum.P99, sum.Max)
+	for _, r := range results {
+		r.Config["ping_interval"] = p.Interval.String()
+		r.Config["ping_rtt_p50"] = sum.P50.String()
+		r.Config["ping_rtt_p99"] = sum.P99.String()
+		r.Config["ping_rtt_max"] = sum.Max.String()
+	}
+}
+
+// pool is a minimal http2.ClientConnPool: requests share the first
+// connection with a free stream and misses dial one at a time, as the
+// transport's own pool does.
+type pool struct {
+	p *Pinger
+	t *http2.Transport
+
+	mu    sync.Mutex
+	conns map[string][]*http2.ClientConn
+}
+
+func (cp *pool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, cc := range cp.conns[addr] {
+		if cc.ReserveNewRequest() {
+			return cc, nil
+		}
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	// The transport's own TLS config for addr: h2 first in ALPN and the
+	// host as server name
+	cfg := new(tls.Config)
+	if cp.t.TLSClientConfig != nil {
+		cfg = cp.t.TLSClientConfig.Clone()
+	}
+	if !slices.Contains(cfg.NextProtos, http2.NextProtoTLS) {
+		cfg.NextProtos = append([]string{http2.NextProtoTLS}, cfg.NextProtos...)
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	conn, err := cp.t.DialTLSContext(req.Context(), "tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := cp.t.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	cp.conns[addr] = append(cp.conns[addr], cc)
+	cp.p.watch(cc)
+	if !cc.ReserveNewRequest() {
+		return nil, fmt.Errorf("new connection to %s refused the request", addr)
+	}
+	return cc, nil
+}
+
+func (cp *pool) MarkDead(cc *http2.ClientConn) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for addr, conns := range cp.conns {
+		cp.conns[addr] = slices.DeleteFunc(conns, func(c *http2.ClientConn) bool { return c == cc })
+	}
+}
@@ -0,0 +1,69 @@
+package h2ping
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"benchmarks/internal/report"
+
+	"golang.org/x/net/http2"
+)
+
+func TestPinger(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	p := New(5 * time.Millisecond)
+	transport := &http2.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return (&tls.Dialer{Config: cfg}).DialContext(ctx, network, addr)
+		},
+	}
+	transport.ConnPool = p.Pool(transport)
+	client := &http.Client{Transport: transport}
+
+	for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	p.Stop()
+
+	if p.RTT.Count() == 0 {
+		t.Fatal("no PING RTT recorded")
+	}
+	results := []report.Result{{Config: map[string]string{}}}
+	p.Annotate(io.Discard, results)
+	if p.conns != 1 {
+		t.Errorf("pinged %d connections, want the 1 every request shared", p.conns)
+	}
+	if results[0].Config["ping_rtt_p50"] == "" {
+		t.Errorf("config %v has no ping_rtt_p50", results[0].Config)
+	}
+}
+
+func TestNilPinger(t *testing.T) {
+	p := New(0)
+	if p != nil {
+		t.Fatal("New(0) returned a pinger")
+	}
+	if p.Pool(&http2.Transport{}) != nil {
+		t.Error("nil pinger returned a pool")
+	}
+	p.Stop()
+	p.Annotate(io.Discard, nil)
+}
@@ -0,0 +1,295 @@
+// Package hdr implements a fixed-size, log-linear latency histogram in the
+// spirit of HdrHistogram. Every benchmark tool records into it so that all
+// of them report the same statistics computed the same way.
+//
+// Values below 256ns are stored exactly; above that each power-of-two range
+// is split into 128 linear sub-buckets, which bounds the relative error of
+// any reported quantile to under 1% while keeping a histogram at ~30KB no
+// matter how many samples it holds.
+package hdr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subBucketBits  = 8
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+	maxValueBits   = 36
+	bucketCount    = subBucketCount + (maxValueBits-subBucketBits)*subBucketHalf
+)
+
+// MaxValue is the largest latency the histogram distinguishes (~68.7s).
+// Longer samples are clamped to it.
+const MaxValue = time.Duration(1<<maxValueBits - 1)
+
+// Histogram records durations with bounded memory.
+//
+// Record is lock-free and safe for concurrent use, but the intended pattern
+// is one histogram per worker goroutine merged with Merge once the run is
+// over, so the hot path never contends on a shared cache line.
+type Histogram struct {
+	counts [bucketCount]atomic.Int64
+	total  atomic.Int64
+	sum    atomic.Int64
+	min    atomic.Int64
+	max    atomic.Int64
+}
+
+// New returns an empty histogram.
+func New() *Histogram {
+	h := &Histogram{}
+	h.min.Store(math.MaxInt64)
+	return h
+}
+
+// Record adds a single sample.
+func (h *Histogram) Record(d time.Duration) {
+	v := clamp(int64(d))
+	h.counts[indexOf(v)].Add(1)
+	h.total.Add(1)
+	h.sum.Add(v)
+	storeMin(&h.min, v)
+	storeMax(&h.max, v)
+}
+
+// Merge adds every sample recorded in other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	n := other.total.Load()
+	if n == 0 {
+		return
+	}
+	for i := range other.counts {
+		if c := other.counts[i].Load(); c != 0 {
+			h.counts[i].Add(c)
+		}
+	}
+	h.total.Add(n)
+	h.sum.Add(other.sum.Load())
+	storeMin(&h.min, other.min.Load())
+	storeMax(&h.max, other.max.Load())
+}
+
+// Reset empties h so it can be reused, e.g. for the next reporting
+// interval. It must not race with Record.
+func (h *Histogram) Reset() {
+	if h.total.Load() == 0 {
+		return
+	}
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.total.Store(0)
+	h.sum.Store(0)
+	h.min.Store(math.MaxInt64)
+	h.max.Store(0)
+}
+
+// Merged returns a new histogram holding the samples of all hs.
+func Merged(hs []*Histogram) *Histogram {
+	h := New()
+	for _, o := range hs {
+		h.Merge(o)
+	}
+	return h
+}
+
+// Counts returns the number of samples in each of hs, e.g. the requests
+// each worker completed when every worker has its own histogram.
+func Counts(hs []*Histogram) []int64 {
+	counts := make([]int64, len(hs))
+	for i, h := range hs {
+		counts[i] = h.Count()
+	}
+	return counts
+}
+
+// Count returns the number of recorded samples.
+func (h *Histogram) Count() int64 {
+	return h.total.Load()
+}
+
+// Min returns the smallest recorded sample, or 0 if the histogram is empty.
+func (h *Histogram) Min() time.Duration {
+	if h.total.Load() == 0 {
+		return 0
+	}
+	return time.Duration(h.min.Load())
+}
+
+// Max returns the largest recorded sample.
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max.Load())
+}
+
+// Mean returns the arithmetic mean of the recorded samples.
+func (h *Histogram) Mean() time.Duration {
+	n := h.total.Load()
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(h.sum.Load() / n)
+}
+
+// ValueAtQuantile returns the sample at quantile q (0..1). The result is the
+// upper bound of the bucket holding that sample, limited to the observed
+// min and max so that q=0 and q=1 are exact.
+func (h *Histogram) ValueAtQuantile(q float64) time.Duration {
+	n := h.total.Load()
+	if n == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(n)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i := range h.counts {
+		seen += h.counts[i].Load()
+		if seen >= rank {
+			_, hi := valueRange(i)
+			hi = min(hi, h.max.Load())
+			hi = max(hi, h.min.Load())
+			return time.Duration(hi)
+		}
+	}
+	return h.Max()
+}
+
+// wireHistogram is the JSON form of a histogram: only non-empty buckets,
+// as [index, count] pairs, so a sparse histogram stays a few hundred bytes.
+type wireHistogram struct {
+	Buckets [][2]int64 `json:"buckets"`
+	Sum     int64      `json:"sum"`
+	Min     int64      `json:"min"`
+	Max     int64      `json:"max"`
+}
+
+// MarshalJSON encodes h losslessly so histograms recorded on another
+// machine can be merged as if they were local.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	w := wireHistogram{Buckets: [][2]int64{}, Sum: h.sum.Load(), Min: h.min.Load(), Max: h.max.Load()}
+	for i := range h.counts {
+		if c := h.counts[i].Load(); c != 0 {
+			w.Buckets = append(w.Buckets, [2]int64{int64(i), c})
+		}
+	}
+	return json.Marshal(w)
+}
+
+// UnmarshalJSON replaces the contents of h with an encoded histogram.
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var w wireHistogram
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*h = Histogram{}
+	var total int64
+	for _, b := range w.Buckets {
+		if b[0] < 0 || b[0] >= bucketCount || b[1] < 0 {
+			return fmt.Errorf("hdr: bad bucket %v", b)
+		}
+		h.counts[b[0]].Add(b[1])
+		total += b[1]
+	}
+	h.total.Store(total)
+	h.sum.Store(w.Sum)
+	h.min.Store(w.Min)
+	h.max.Store(w.Max)
+	return nil
+}
+
+// Summary is the fixed set of statistics every benchmark reports.
+type Summary struct {
+	Count int64         `json:"count"`
+	Min   time.Duration `json:"min"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	P999  time.Duration `json:"p999"`
+	Max   time.Duration `json:"max"`
+}
+
+// Summary computes the standard statistics for h.
+func (h *Histogram) Summary() Summary {
+	return Summary{
+		Count: h.Count(),
+		Min:   h.Min(),
+		Mean:  h.Mean(),
+		P50:   h.ValueAtQuantile(0.50),
+		P90:   h.ValueAtQuantile(0.90),
+		P99:   h.ValueAtQuantile(0.99),
+		P999:  h.ValueAtQuantile(0.999),
+		Max:   h.Max(),
+	}
+}
+
+// WriteText prints the summary in the layout shared by all benchmark tools.
+func (s Summary) WriteText(w io.Writer) {
+	if s.Count == 0 {
+		fmt.Fprintln(w, "Latency: no successful requests")
+		return
+	}
+	fmt.Fprintln(w, "\nLatency:")
+	fmt.Fprintf(w, "  Min:   %v\n", s.Min)
+	fmt.Fprintf(w, "  Mean:  %v\n", s.Mean)
+	fmt.Fprintf(w, "  p50:   %v\n", s.P50)
+	fmt.Fprintf(w, "  p90:   %v\n", s.P90)
+	fmt.Fprintf(w, "  p99:   %v\n", s.P99)
+	fmt.Fprintf(w, "  p99.9: %v\n", s.P999)
+	fmt.Fprintf(w, "  Max:   %v\n", s.Max)
+}
+
+func clamp(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	return min(v, int64(MaxValue))
+}
+
+// indexOf maps a clamped value to its bucket.
+func indexOf(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits
+	return subBucketCount + (shift-1)*subBucketHalf + int(v>>shift) - subBucketHalf
+}
+
+// valueRange returns the inclusive range of values that map to bucket idx.
+func valueRange(idx int) (lo, hi int64) {
+	if idx < subBucketCount {
+		return int64(idx), int64(idx)
+	}
+	k := idx - subBucketCount
+	shift := k/subBucketHalf + 1
+	lo = int64(subBucketHalf+k%subBucketHalf) << shift
+	return lo, lo + 1<<shift - 1
+}
+
+func storeMin(a *atomic.Int64, v int64) {
+	for {
+		cur := a.Load()
+		if v >= cur || a.CompareAndSwap(cur, v) {
+			return
+		}
+	}
+}
+
+func storeMax(a *atomic.Int64, v int64) {
+	for {
+		cur := a.Load()
+		if v <= cur || a.CompareAndSwap(cur, v) {
+			return
+		}
+	}
+}
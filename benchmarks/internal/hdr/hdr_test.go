@@ -0,0 +1,123 @@
+package hdr
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	for _, v := range []int64{0, 1, 255, 256, 257, 511, 512, 1000, 123456789, int64(MaxValue)} {
+		lo, hi := valueRange(indexOf(v))
+		if v < lo || v > hi {
+			t.Errorf("value %d mapped to bucket [%d, %d]", v, lo, hi)
+		}
+	}
+	if got := indexOf(int64(MaxValue)); got != bucketCount-1 {
+		t.Errorf("MaxValue index = %d, want %d", got, bucketCount-1)
+	}
+}
+
+func TestBucketsAreContiguous(t *testing.T) {
+	_, prevHi := valueRange(0)
+	for i := 1; i < bucketCount; i++ {
+		lo, hi := valueRange(i)
+		if lo != prevHi+1 {
+			t.Fatalf("bucket %d starts at %d, previous ended at %d", i, lo, prevHi)
+		}
+		prevHi = hi
+	}
+}
+
+func TestQuantilesWithinRelativeError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	h := New()
+	samples := make([]time.Duration, 100000)
+	for i := range samples {
+		samples[i] = time.Duration(rng.ExpFloat64() * float64(time.Millisecond))
+		h.Record(samples[i])
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		want := samples[int(q*float64(len(samples)))-1]
+		got := h.ValueAtQuantile(q)
+		if diff := float64(got-want) / float64(want); diff < -0.01 || diff > 0.01 {
+			t.Errorf("q%.3f = %v, want %v (±1%%)", q, got, want)
+		}
+	}
+	if h.Min() != samples[0] || h.Max() != samples[len(samples)-1] {
+		t.Errorf("min/max = %v/%v, want %v/%v", h.Min(), h.Max(), samples[0], samples[len(samples)-1])
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b, all := New(), New(), New()
+	for i := 1; i <= 1000; i++ {
+		d := time.Duration(i) * time.Microsecond
+		if i%2 == 0 {
+			a.Record(d)
+		} else {
+			b.Record(d)
+		}
+		all.Record(d)
+	}
+	merged := Merged([]*Histogram{a, b, New()})
+
+	if merged.Summary() != all.Summary() {
+		t.Errorf("merged summary %+v, want %+v", merged.Summary(), all.Summary())
+	}
+}
+
+func TestClamp(t *testing.T) {
+	h := New()
+	h.Record(-time.Second)
+	h.Record(2 * MaxValue)
+	if h.Min() != 0 || h.Max() != MaxValue {
+		t.Errorf("min/max = %v/%v, want 0/%v", h.Min(), h.Max(), MaxValue)
+	}
+}
+
+func TestReset(t *testing.T) {
+	h := New()
+	h.Record(time.Second)
+	h.Reset()
+	if s := h.Summary(); s != (Summary{}) {
+		t.Errorf("summary after Reset = %+v", s)
+	}
+	h.Record(time.Millisecond)
+	if h.Min() != time.Millisecond || h.Count() != 1 {
+		t.Errorf("reused histogram: min %v, count %d", h.Min(), h.Count())
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	h := New()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i*i) * time.Microsecond)
+	}
+	for _, src := range []*Histogram{h, New()} {
+		data, err := json.Marshal(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := New()
+		if err := json.Unmarshal(data, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Summary() != src.Summary() {
+			t.Errorf("round trip summary %+v, want %+v", got.Summary(), src.Summary())
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"buckets":[[999999,1]]}`), New()); err == nil {
+		t.Error("out-of-range bucket accepted")
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	if s := New().Summary(); s != (Summary{}) {
+		t.Errorf("empty summary = %+v", s)
+	}
+}
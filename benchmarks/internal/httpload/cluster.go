@@ -0,0 +1,254 @@
+package httpload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"benchmarks/internal/distrib"
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/report"
+	"benchmarks/internal/retry"
+	"benchmarks/internal/scenario"
+	"gopkg.in/yaml.v3"
+)
+
+// Job is the workload part of a Config, which the coordinator of a
+// distributed run sends to its workers. Transport settings such as
+// -timeout, -tls or -unix stay local to each worker.
+type Job struct {
+	URL         string
+	Concurrency int
+	Duration    time.Duration
+	Requests    int64
+	Method      string
+	Body        []byte
+	ContentType string
+	Headers     Headers
+	Warmup      time.Duration
+	Rate        float64
+	Routes      Routes
+	Stages      Stages
+	Validate    string
+	Retry       retry.Policy
+	Login       *scenario.Step
+	Upload      Upload
+	Think       Think
+	Seed        uint64
+
+	// Scenario travels as YAML since a parsed scenario carries compiled
+	// extractors.
+	Scenario []byte
+}
+
+// Job extracts the workload from c.
+func (c *Config) Job() (Job, error) {
+	j := Job{
+		URL:         c.URL,
+		Concurrency: c.Concurrency,
+		Duration:    c.Duration,
+		Requests:    c.Requests,
+		Method:      c.Method,
+		Body:        c.Body,
+		ContentType: c.ContentType,
+		Headers:     c.Headers,
+		Warmup:      c.Warmup,
+		Rate:        c.Rate,
+		Routes:      c.Routes,
+		Stages:      c.Stages,
+		Validate:    c.Check.String(),
+		Retry:       c.Retry,
+		Login:       c.Login,
+		Upload:      c.Upload,
+		Think:       c.Think,
+		Seed:        c.Seed,
+	}
+	if c.Scenario != nil {
+		data, err := yaml.Marshal(c.Scenario)
+		if err != nil {
+			return Job{}, err
+		}
+		j.Scenario = data
+	}
+	return j, nil
+}
+
+// Apply replaces the workload of c with j.
+func (j Job) Apply(c *Config) error {
+	c.URL, c.Concurrency, c.Duration, c.Requests = j.URL, j.Concurrency, j.Duration, j.Requests
+	c.Method, c.Body, c.ContentType, c.Headers = j.Method, j.Body, j.ContentType, j.Headers
+	c.Warmup, c.Rate, c.Routes, c.Stages = j.Warmup, j.Rate, j.Routes, j.Stages
+	c.Retry, c.Login, c.Upload, c.Think = j.Retry, j.Login, j.Upload, j.Think
+	c.Seed = j.Seed
+	if c.Login != nil {
+		if err := c.Login.Compile(); err != nil {
+			return err
+		}
+	}
+	c.Check = BodyCheck{}
+	if j.Validate != "" {
+		// A file: check travels as its spec, so the file must exist on
+		// the worker too
+		if err := c.Check.Set(j.Validate); err != nil {
+			return err
+		}
+	}
+	c.Scenario = nil
+	if j.Scenario != nil {
+		s, err := scenario.Parse(j.Scenario)
+		if err != nil {
+			return err
+		}
+		c.Scenario = s
+	}
+	return nil
+}
+
+// Merge combines the results of the same stages run on several machines:
+// counts and worker totals add up, histograms and error classes merge, the
+// per-worker counts of every machine line up, and the elapsed time is that
+// of the slowest machine.
+func Merge(runs [][]Result) ([]Result, error) {
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	out := make([]Result, len(runs[0]))
+	for i := range out {
+		out[i] = Result{Latency: hdr.New(), ErrorClasses: errclass.Counts{}}
+		for _, rr := range runs[0][i].Routes {
+			out[i].Routes = append(out[i].Routes, RouteResult{Path: rr.Path, URL: rr.URL, Latency: hdr.New()})
+		}
+	}
+	for _, run := range runs {
+		if len(run) != len(out) {
+			return nil, fmt.Errorf("got %d stage results, want %d", len(run), len(out))
+		}
+		for i, res := range run {
+			m := &out[i]
+			if len(res.Routes) != len(m.Routes) {
+				return nil, errors.New("workers reported different routes")
+			}
+			m.Concurrency += res.Concurrency
+			m.Elapsed = max(m.Elapsed, res.Elapsed)
+			m.Requests += res.Requests
+			m.Errors += res.Errors
+			m.Latency.Merge(res.Latency)
+			m.ErrorClasses.Merge(res.ErrorClasses)
+			m.Retried += res.Retried
+			m.Retries += res.Retries
+			m.Uploaded += res.Uploaded
+			m.Workers = append(m.Workers, res.Workers...)
+			for j, rr := range res.Routes {
+				m.Routes[j].Requests += rr.Requests
+				m.Routes[j].Errors += rr.Errors
+				m.Routes[j].Latency.Merge(rr.Latency)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Cluster runs a Config either locally, as the coordinator of a
+// distributed run, or as one of its workers.
+type Cluster struct {
+	distrib.Options
+
+	session *distrib.Session // set in worker mode once joined
+}
+
+// Coordinating reports whether this process only coordinates and so
+// generates no load of its own.
+func (cl *Cluster) Coordinating() bool {
+	return cl.Coordinator != ""
+}
+
+// Join, in worker mode, connects to the coordinator and replaces the
+// workload of cfg with the job it hands out. It must be called before
+// cfg is used to size the transport.
+func (cl *Cluster) Join(cfg *Config, info io.Writer) error {
+	if err := cl.Validate(); err != nil || cl.Worker == "" {
+		return err
+	}
+	fmt.Fprintf(info, "Waiting for coordinator at %s...\n", cl.Worker)
+	var j Job
+	s, err := distrib.Join(cl.Worker, &j)
+	if err != nil {
+		return err
+	}
+	cl.session = s
+	fmt.Fprintf(info, "Joined as worker %d/%d\n", s.Index+1, s.Total)
+	return j.Apply(cfg)
+}
+
+// Run executes cfg with RunStages. A worker runs its share in step with
+// the others and reports it to the coordinator; a coordinator splits -rate
+// and -n evenly between its workers, each of which runs -c workers of its
+// own, and returns the merged results of all of them.
+func (cl *Cluster) Run(cfg Config, info io.Writer) ([]Result, error) {
+	switch {
+	case cl.session != nil:
+		cl.session.Wait()
+		results := RunStages(cfg)
+		return results, cl.session.Finish(results, nil)
+	case cl.Coordinator == "":
+		return RunStages(cfg), nil
+	}
+
+	job, err := cfg.Job()
+	if err != nil {
+		return nil, err
+	}
+	if job.Requests > 0 && job.Requests < int64(cl.Workers) {
+		return nil, fmt.Errorf("-n %d is less than one request per worker", job.Requests)
+	}
+	c, err := distrib.Listen(cl.Coordinator)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	fmt.Fprintf(info, "Waiting for %d workers on %s (each runs -c workers", cl.Workers, c.Addr())
+	if cfg.Rate > 0 {
+		fmt.Fprintf(info, " at %.0f req/s", cfg.Rate/float64(cl.Workers))
+	}
+	fmt.Fprintln(info, ")...")
+	raw, err := c.Run(cl.Workers, func(i, n int) any {
+		share := job
+		share.Rate = job.Rate / float64(n)
+		if job.Seed != 0 {
+			// Machines repeat their own sequences, not each other's
+			share.Seed = job.Seed + uint64(i)
+		}
+		if job.Requests > 0 {
+			share.Requests = job.Requests / int64(n)
+			if int64(i) < job.Requests%int64(n) {
+				share.Requests++
+			}
+		}
+		return share
+	}, info)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([][]Result, len(raw))
+	for i, data := range raw {
+		if err := json.Unmarshal(data, &runs[i]); err != nil {
+			return nil, fmt.Errorf("worker %d: %w", i+1, err)
+		}
+	}
+	return Merge(runs)
+}
+
+// Annotate records the number of load generators in a coordinator's
+// reports.
+func (cl *Cluster) Annotate(results []report.Result) {
+	if !cl.Coordinating() {
+		return
+	}
+	for _, r := range results {
+		r.Config["workers"] = strconv.Itoa(cl.Workers)
+	}
+}
@@ -0,0 +1,92 @@
+package httpload
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/scenario"
+)
+
+func TestJobRoundTrip(t *testing.T) {
+	sc, err := scenario.Parse([]byte("name: login\nsteps:\n  - path: /login\n    extract:\n      token: json:token\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := Config{URL: "http://localhost/", Concurrency: 4, Duration: time.Second, Rate: 50, Scenario: sc}
+	in.Headers.Set("X-Test: 1")
+	job, err := in.Job()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Job
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	var out Config
+	if err := decoded.Apply(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.URL != in.URL || out.Concurrency != 4 || out.Rate != 50 || out.Headers.String() != "X-Test: 1" {
+		t.Errorf("applied %+v", out)
+	}
+	if out.Scenario == nil || out.Scenario.Steps[0].Extract["token"] != "json:token" {
+		t.Errorf("scenario lost in transit: %+v", out.Scenario)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	result := func(n int64, d time.Duration, elapsed time.Duration) Result {
+		h := hdr.New()
+		for range n {
+			h.Record(d)
+		}
+		return Result{
+			Concurrency:  10,
+			Elapsed:      elapsed,
+			Requests:     n,
+			Errors:       1,
+			Latency:      h,
+			ErrorClasses: errclass.Counts{"timeout": 1},
+			Routes:       []RouteResult{{Path: "/", Requests: n, Errors: 1, Latency: h}},
+		}
+	}
+	// Through JSON, as the coordinator receives them
+	var runs [][]Result
+	for _, r := range [][]Result{{result(100, time.Millisecond, time.Second)}, {result(50, 3*time.Millisecond, 2*time.Second)}} {
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded []Result
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, decoded)
+	}
+
+	merged, err := Merge(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := merged[0]
+	if m.Concurrency != 20 || m.Requests != 150 || m.Errors != 2 || m.Elapsed != 2*time.Second {
+		t.Errorf("merged totals %+v", m)
+	}
+	if m.Latency.Count() != 150 || m.Latency.Max() != 3*time.Millisecond {
+		t.Errorf("merged histogram holds %d samples, max %v", m.Latency.Count(), m.Latency.Max())
+	}
+	if m.ErrorClasses["timeout"] != 2 || m.Routes[0].Requests != 150 {
+		t.Errorf("merged classes %v, routes %+v", m.ErrorClasses, m.Routes)
+	}
+
+	if _, err := Merge([][]Result{runs[0], append(runs[1], runs[1]...)}); err == nil {
+		t.Error("merged runs with different stage counts")
+	}
+}
@@ -0,0 +1,58 @@
+package httpload
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"benchmarks/internal/scenario"
+)
+
+// Headers is a repeatable flag.Value collecting "Key: Value" pairs.
+type Headers http.Header
+
+func (h *Headers) String() string {
+	if h == nil {
+		return ""
+	}
+	var parts []string
+	for k, vs := range *h {
+		for _, v := range vs {
+			parts = append(parts, k+": "+v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *Headers) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" || strings.ContainsAny(k, " \t") {
+		return fmt.Errorf("header %q is not \"Key: Value\"", s)
+	}
+	if *h == nil {
+		*h = Headers{}
+	}
+	http.Header(*h).Add(k, strings.TrimSpace(v))
+	return nil
+}
+
+// apply copies the headers onto req, expanding ${name} from vars unless
+// vars is nil. A Host header overrides the request's Host, since net/http
+// ignores it in the header map.
+func (h Headers) apply(req *http.Request, vars map[string]string) {
+	for k, vs := range h {
+		if vars != nil {
+			expanded := make([]string, len(vs))
+			for i, v := range vs {
+				expanded[i] = scenario.Expand(v, vars)
+			}
+			vs = expanded
+		}
+		if k == "Host" {
+			req.Host = vs[len(vs)-1]
+			continue
+		}
+		req.Header[k] = append(req.Header[k], vs...)
+	}
+}
@@ -0,0 +1,50 @@
+package httpload
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHeaderFlags(t *testing.T) {
+	var cfg Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg.Register(fs)
+	err := fs.Parse([]string{"-H", "X-Trace: a", "-H", "x-trace:b", "-H", "Host: api.example.com", "-bearer", "tok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header(cfg.Headers)
+	if got := h.Values("X-Trace"); len(got) != 2 || got[1] != "b" {
+		t.Errorf("X-Trace = %v", got)
+	}
+	if h.Get("Authorization") != "Bearer tok" {
+		t.Errorf("Authorization = %q", h.Get("Authorization"))
+	}
+	if err := fs.Parse([]string{"-H", "no colon"}); err == nil {
+		t.Error("malformed header accepted")
+	}
+}
+
+func TestHeadersAreSent(t *testing.T) {
+	var bad atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" || r.Host != "api.example.com" {
+			bad.Add(1)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := Config{Client: srv.Client(), URL: srv.URL, Concurrency: 1, Duration: 20 * time.Millisecond}
+	cfg.Headers.Set("Authorization: Bearer tok")
+	cfg.Headers.Set("Host: api.example.com")
+	res := Run(cfg)
+	if res.Requests == 0 || bad.Load() != 0 {
+		t.Errorf("requests=%d without expected headers=%d", res.Requests, bad.Load())
+	}
+}
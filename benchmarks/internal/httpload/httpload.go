@@ -0,0 +1,674 @@
+// Package httpload is the HTTP load engine shared by bench_http and
+// bench_http2. The tools differ only in the transport they hand it.
+package httpload
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/metrics"
+	"benchmarks/internal/payload"
+	"benchmarks/internal/progress"
+	"benchmarks/internal/rawlog"
+	"benchmarks/internal/report"
+	"benchmarks/internal/retry"
+	"benchmarks/internal/scenario"
+)
+
+// Config describes one load run.
+type Config struct {
+	Client *http.Client
+
+	// Clients, when set, replaces Client: worker i sends through
+	// Clients[i%len(Clients)], so each can own a separate connection.
+	Clients []*http.Client
+
+	URL         string
+	Concurrency int
+	Duration    time.Duration
+
+	// Requests, when positive, ends the run after exactly this many
+	// successful measured requests. Duration then only caps the run, and
+	// zero means no cap.
+	Requests int64
+
+	// Timeouts limit each request; the clients should set no Timeout of
+	// their own, or timeouts lose their phase.
+	Timeouts Timeouts
+
+	// Retry re-sends requests that failed for a transient reason. A
+	// retried request counts once, with its latency covering every
+	// attempt and backoff.
+	Retry retry.Policy
+
+	// Method defaults to GET, or POST when Body is set.
+	Method      string
+	Body        []byte
+	ContentType string
+
+	// Upload, when enabled, replaces Body with a generated file sent as
+	// multipart/form-data.
+	Upload Upload
+
+	// Headers are added to every request; -bearer is shorthand for an
+	// Authorization header.
+	Headers Headers
+
+	// Warmup runs the workload for this long before measuring. Requests
+	// issued during warmup are excluded from every counter and histogram.
+	Warmup time.Duration
+
+	// Rate switches to open-loop mode: requests are scheduled at this many
+	// per second across all workers, and latency is measured from each
+	// request's intended send time so that server stalls are not hidden
+	// (coordinated omission). Concurrency then bounds requests in flight.
+	Rate float64
+
+	// Think pauses each worker between a response and its next request
+	// in closed-loop mode. Latency does not include it.
+	Think Think
+
+	// Seed, when non-zero, fixes the random choices of every worker: route
+	// picks, upload sizes and think times repeat exactly from run to run.
+	// Retry backoff jitter stays random.
+	Seed uint64
+
+	// Routes spreads requests over several paths, resolved against URL,
+	// in proportion to their weights.
+	Routes Routes
+
+	// Scenario replaces the single request with a multi-step sequence that
+	// each worker runs as a virtual user. Results are broken down per step.
+	Scenario *scenario.Scenario
+
+	// Login, when set, is sent once by each worker before its first
+	// request, through a client with a cookie jar of the worker's own.
+	// Values it extracts fill ${name} in Headers. A scenario may carry
+	// its login instead.
+	Login *scenario.Step
+
+	// Stages, when set, replaces Concurrency and Duration with a load
+	// profile that steps the worker count up or down over time.
+	Stages Stages
+
+	// Check, when enabled, must pass for a 2xx response to count as a
+	// success. It does not apply to scenario steps, which have their own
+	// expectations.
+	Check BodyCheck
+
+	// Progress, when non-nil, receives every request as it completes for
+	// live interval stats, warmup included. It must have a slot for each
+	// of MaxConcurrency workers.
+	Progress *progress.Meter
+
+	// Metrics, when non-nil, exports in-flight requests, latency and error
+	// classes for Prometheus, warmup included.
+	Metrics *metrics.Metrics
+
+	// Raw, when non-nil, receives every measured request.
+	Raw *rawlog.Writer
+
+	// Context, when non-nil, ends the run early once cancelled, as on
+	// Ctrl-C: requests in flight are abandoned and not counted, and the
+	// result covers what completed until then.
+	Context context.Context
+}
+
+// context returns the context requests are sent under.
+func (c *Config) context() context.Context {
+	if c.Context == nil {
+		return context.Background()
+	}
+	return c.Context
+}
+
+// Register adds the HTTP-specific flags to fs.
+func (c *Config) Register(fs *flag.FlagSet) {
+	fs.StringVar(&c.Method, "method", c.Method, "request method (default GET, or POST with a body)")
+	fs.Func("body", "request body", func(s string) error {
+		c.Body = []byte(s)
+		return nil
+	})
+	fs.Func("body-file", "read the request body from a file", func(path string) error {
+		b, err := os.ReadFile(path)
+		c.Body = b
+		return err
+	})
+	if c.ContentType == "" {
+		c.ContentType = "application/json"
+	}
+	fs.StringVar(&c.ContentType, "content-type", c.ContentType, "Content-Type sent with a request body")
+	c.Upload.Register(fs)
+	fs.Var(&c.Headers, "H", "request header as \"Key: Value\" (repeatable)")
+	fs.Func("bearer", "bearer token sent as an Authorization header", func(token string) error {
+		return c.Headers.Set("Authorization: Bearer " + token)
+	})
+	fs.DurationVar(&c.Warmup, "warmup", c.Warmup, "unmeasured warmup period before the benchmark")
+	fs.Func("n", "stop after this many successful requests, e.g. 1_000_000, instead of after -d (an explicit -d still caps the run)", func(s string) error {
+		n, err := strconv.ParseInt(s, 0, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid request count %q", s)
+		}
+		c.Requests = n
+		return nil
+	})
+	fs.Float64Var(&c.Rate, "rate", c.Rate, "open-loop arrival rate in requests/sec across all workers (0 = closed loop)")
+	c.Think.Register(fs)
+	fs.Uint64Var(&c.Seed, "seed", c.Seed, "seed the random route picks, upload sizes and think times so runs repeat the same request sequence (0 = random)")
+	fs.Var(&c.Routes, "routes", "weighted route mix relative to -url, e.g. /:70,/json:20,/users/42:10")
+	fs.Func("scenario", "YAML file describing a multi-step request sequence per virtual user", func(path string) error {
+		s, err := scenario.Load(path)
+		c.Scenario = s
+		return err
+	})
+	fs.Var(&c.Check, "validate", "check every response body: sha256:<hex>, equals:<text>, contains:<text>, file:<path> or len:<n>")
+	c.Timeouts.Register(fs)
+	c.Retry.Register(fs)
+	c.registerLogin(fs)
+	fs.Var(&c.Stages, "stages", "load profile as workers:duration steps, e.g. 10:5s,100:10s,500:10s,100:5s (overrides -c and -d)")
+}
+
+// Validate reports settings that cannot produce a valid request.
+func (c *Config) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if c.Scenario != nil && len(c.Routes) > 0 {
+		return errors.New("-scenario and -routes are mutually exclusive")
+	}
+	if c.Requests > 0 && len(c.Stages) > 0 {
+		return errors.New("-n and -stages are mutually exclusive")
+	}
+	if err := c.Think.validate(); err != nil {
+		return err
+	}
+	if c.Think.Enabled() && c.Rate > 0 {
+		return errors.New("-think paces closed-loop workers; -rate already sets the arrival rate")
+	}
+	if c.Upload.Enabled() {
+		if len(c.Body) > 0 || c.Scenario != nil {
+			return errors.New("-upload replaces the request body; drop -body and -scenario")
+		}
+		if err := c.Upload.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Scenario != nil && c.Check.Enabled() {
+		return errors.New("-validate does not apply to -scenario; use expect in the scenario steps")
+	}
+	if _, err := c.targets(); err != nil {
+		return err
+	}
+	if c.Login != nil {
+		if c.Scenario != nil && c.Scenario.Login != nil {
+			return errors.New("-login and a scenario login are mutually exclusive")
+		}
+		if err := c.Login.Compile(); err != nil {
+			return fmt.Errorf("-login: %w", err)
+		}
+	}
+	if c.Method != "" && strings.ContainsAny(c.Method, " \t\r\n") {
+		return fmt.Errorf("invalid method %q", c.Method)
+	}
+	return c.Retry.Validate()
+}
+
+// Describe prints the run settings that differ from a plain GET benchmark.
+func (c *Config) Describe(w io.Writer) {
+	if len(c.Stages) > 0 {
+		fmt.Fprintf(w, "Stages: %v\n", c.Stages.String())
+	} else {
+		fmt.Fprintf(w, "Concurrency: %d connections\n", c.Concurrency)
+		switch {
+		case c.Requests > 0 && c.Duration > 0:
+			fmt.Fprintf(w, "Requests: %d successful, within %v\n", c.Requests, c.Duration)
+		case c.Requests > 0:
+			fmt.Fprintf(w, "Requests: %d successful\n", c.Requests)
+		default:
+			fmt.Fprintf(w, "Duration: %v\n", c.Duration)
+		}
+	}
+	if u := c.Upload; u.Enabled() {
+		fmt.Fprintf(w, "Request: %s uploading %d-byte files (%s) as multipart field %q\n", c.method(), u.Size, u.Dist, u.Field)
+	} else if len(c.Body) > 0 {
+		fmt.Fprintf(w, "Request: %s with %d-byte %s body\n", c.method(), len(c.Body), c.ContentType)
+	} else if c.Method != "" {
+		fmt.Fprintf(w, "Request: %s\n", c.method())
+	}
+	if len(c.Headers) > 0 {
+		fmt.Fprintf(w, "Headers: %d extra\n", len(c.Headers))
+	}
+	if len(c.Routes) > 0 {
+		fmt.Fprintf(w, "Routes: %s\n", c.Routes.String())
+	}
+	if c.Scenario != nil {
+		fmt.Fprintf(w, "Scenario: %s (%d steps per virtual user)\n", c.Scenario.Name, len(c.Scenario.Steps))
+	}
+	if st := c.loginStep(); st != nil {
+		fmt.Fprintf(w, "Login: %s %s once per virtual user\n", st.Method, st.Path)
+	}
+	if c.Warmup > 0 {
+		fmt.Fprintf(w, "Warmup: %v\n", c.Warmup)
+	}
+	if c.Rate > 0 {
+		fmt.Fprintf(w, "Rate: %.0f req/s (open loop)\n", c.Rate)
+	}
+	if c.Think.Enabled() {
+		fmt.Fprintf(w, "Think time: %s between requests\n", c.Think.String())
+	}
+	if c.Seed != 0 {
+		fmt.Fprintf(w, "Seed: %d\n", c.Seed)
+	}
+	if c.Check.Enabled() {
+		fmt.Fprintf(w, "Validate: %s\n", c.Check.String())
+	}
+	if t := c.Timeouts; t.phased() {
+		fmt.Fprintf(w, "Timeouts: connect %v, header %v, body %v, request %v\n", t.Connect, t.Header, t.Body, t.Request)
+	}
+	if c.Retry.Enabled() {
+		fmt.Fprintf(w, "Retries: %s\n", c.Retry.String())
+	}
+}
+
+// Summarize prints what results measured beyond the common report: the
+// upload throughput, and with think time the rate each user sustained,
+// which sizes the user population a target rate needs.
+func (c *Config) Summarize(w io.Writer, results []Result) {
+	for i, res := range results {
+		label := ""
+		if len(results) > 1 {
+			label = fmt.Sprintf(" (stage %d/%d)", i+1, len(results))
+		}
+		if c.Upload.Enabled() {
+			fmt.Fprintf(w, "\nUploaded%s: %.2f MB/s, %.1f MB in total\n", label, res.uploadRate(), float64(res.Uploaded)/1e6)
+		}
+		if rate := res.userRate(); c.Think.Enabled() && rate > 0 {
+			fmt.Fprintf(w, "\nPer user%s: %.2f req/s, so 1000 req/s needs about %.0f users\n", label, rate, math.Ceil(1000/rate))
+		}
+	}
+}
+
+// client returns the client worker id sends through.
+func (c *Config) client(id int) *http.Client {
+	if len(c.Clients) > 0 {
+		return c.Clients[id%len(c.Clients)]
+	}
+	return c.Client
+}
+
+// MaxConcurrency returns the largest worker count the run will use, for
+// sizing connection pools.
+func (c *Config) MaxConcurrency() int {
+	n := c.Concurrency
+	for _, st := range c.Stages {
+		n = max(n, st.Concurrency)
+	}
+	return n
+}
+
+// Result is what a run measured.
+type Result struct {
+	Concurrency int
+	Elapsed     time.Duration
+	Requests    int64
+	Errors      int64
+	Latency     *hdr.Histogram
+
+	// ErrorClasses breaks Errors down by cause.
+	ErrorClasses errclass.Counts
+
+	// Retried is how many of Requests needed a retry to succeed, and
+	// Retries how many retries were sent in all.
+	Retried int64
+	Retries int64
+
+	// Uploaded is the file bytes of the successful requests under Upload.
+	Uploaded int64
+
+	// Routes breaks the totals down per route when a route mix was used,
+	// or per step for a scenario.
+	Routes []RouteResult
+
+	// Workers is the successful requests of each worker.
+	Workers []int64
+}
+
+// Sent returns how many requests results sent, failed ones and retries
+// included.
+func Sent(results []Result) int64 {
+	var n int64
+	for _, r := range results {
+		n += r.Requests + r.Errors + r.Retries
+	}
+	return n
+}
+
+// RouteResult is the share of a run that went to one route or step.
+type RouteResult struct {
+	Path     string
+	URL      string
+	Requests int64
+	Errors   int64
+	Latency  *hdr.Histogram
+}
+
+// Reports converts the results of RunStages into the common result format,
+// recording c in each result's config. A run with a route mix or scenario
+// yields its total followed by one entry per route or step.
+func (c *Config) Reports(tool string, results []Result) []report.Result {
+	var out []report.Result
+	for i, res := range results {
+		r := report.NewResult(tool, c.URL, res.Concurrency, res.Elapsed, res.Requests, res.Errors, res.Latency)
+		r.ErrorClasses = res.ErrorClasses
+		r.Retried, r.Retries = res.Retried, res.Retries
+		r.Workers = report.NewFairness(res.Workers)
+		r.Config = map[string]string{
+			"duration": c.Duration.String(),
+			"timeout":  c.Timeouts.Request.String(),
+			"warmup":   c.Warmup.String(),
+			"method":   c.method(),
+		}
+		if c.Requests > 0 {
+			r.Config["n"] = strconv.FormatInt(c.Requests, 10)
+		}
+		if len(c.Headers) > 0 {
+			r.Config["headers"] = strconv.Itoa(len(c.Headers))
+		}
+		if len(c.Body) > 0 {
+			r.Config["body_bytes"] = strconv.Itoa(len(c.Body))
+			r.Config["content_type"] = c.ContentType
+		}
+		if u := c.Upload; u.Enabled() {
+			r.Config["upload_bytes"] = u.Size.String()
+			r.Config["upload_dist"] = u.Dist
+			r.Config["uploaded_bytes"] = strconv.FormatInt(res.Uploaded, 10)
+			r.Config["upload_mb_per_sec"] = strconv.FormatFloat(res.uploadRate(), 'f', 2, 64)
+		}
+		if c.Scenario != nil {
+			r.Config["scenario"] = c.Scenario.Name
+		}
+		if st := c.loginStep(); st != nil {
+			r.Config["login"] = st.Path
+		}
+		if c.Check.Enabled() {
+			r.Config["validate"] = c.Check.String()
+		}
+		if t := c.Timeouts; t.phased() {
+			r.Config["connect_timeout"] = t.Connect.String()
+			r.Config["header_timeout"] = t.Header.String()
+			r.Config["body_timeout"] = t.Body.String()
+		}
+		c.Retry.Record(r.Config)
+		if c.Rate > 0 {
+			r.Config["rate"] = strconv.FormatFloat(c.Rate, 'f', -1, 64)
+		}
+		if c.Seed != 0 {
+			r.Config["seed"] = strconv.FormatUint(c.Seed, 10)
+		}
+		if c.Think.Enabled() {
+			r.Config["think"] = c.Think.Mean.String()
+			r.Config["think_dist"] = c.Think.Dist
+			r.Config["user_rps"] = strconv.FormatFloat(res.userRate(), 'f', 2, 64)
+		}
+		if len(c.Stages) > 0 {
+			r.Label = fmt.Sprintf("stage %d/%d", i+1, len(c.Stages))
+			r.Config["duration"] = c.Stages[i].Duration.String()
+		}
+		out = append(out, r)
+		for _, rr := range res.Routes {
+			route := report.NewResult(tool, rr.URL, res.Concurrency, res.Elapsed, rr.Requests, rr.Errors, rr.Latency)
+			route.Config = r.Config
+			route.Label = strings.TrimSpace(r.Label + " " + c.breakdown() + " " + rr.Path)
+			out = append(out, route)
+		}
+	}
+	return out
+}
+
+// run is the state shared by the workers of one Run call.
+type run struct {
+	cfg       Config
+	ctx       context.Context
+	base      *url.URL
+	measuring atomic.Bool
+	begin     time.Time
+	deadline  time.Time
+	targets   []*target
+	classes   []errclass.Counts // per worker
+	retried   atomic.Int64
+	retries   atomic.Int64
+
+	loginErrors atomic.Int64
+	uploaded    atomic.Int64
+
+	// left is the successes still to go under Config.Requests. A worker
+	// takes one before each measured request and returns it if the
+	// request fails, so the run stops at exactly Requests successes.
+	left atomic.Int64
+}
+
+// Run executes the workload and blocks until it finishes.
+func Run(cfg Config) Result {
+	ts, _ := cfg.targets()        // checked by Validate
+	base, _ := url.Parse(cfg.URL) // checked by Validate
+	r := &run{cfg: cfg, ctx: cfg.context(), base: base, targets: ts, classes: make([]errclass.Counts, cfg.Concurrency)}
+	begin := time.Now()
+	r.begin = begin
+	r.deadline = begin.Add(cfg.Warmup + cfg.Duration)
+	if cfg.Requests > 0 && cfg.Duration <= 0 {
+		r.deadline = begin.Add(math.MaxInt64)
+	}
+	r.left.Store(cfg.Requests)
+
+	// One histogram per worker and route so the hot path never contends
+	for _, t := range ts {
+		t.histograms = make([]*hdr.Histogram, cfg.Concurrency)
+		for i := range t.histograms {
+			t.histograms[i] = hdr.New()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		r.classes[i] = make(errclass.Counts)
+		wg.Add(1)
+		go r.worker(i, &wg)
+	}
+
+	start := begin
+	if cfg.Warmup > 0 {
+		sleep(r.ctx, cfg.Warmup)
+		start = time.Now()
+	}
+	r.measuring.Store(true)
+
+	wg.Wait()
+	res := Result{
+		Concurrency:  cfg.Concurrency,
+		Elapsed:      time.Since(start),
+		Latency:      hdr.New(),
+		ErrorClasses: errclass.Merged(r.classes),
+		Errors:       r.loginErrors.Load(),
+		Retried:      r.retried.Load(),
+		Retries:      r.retries.Load(),
+		Uploaded:     r.uploaded.Load(),
+		Workers:      make([]int64, cfg.Concurrency),
+	}
+	for _, t := range ts {
+		for id, h := range t.histograms {
+			res.Workers[id] += h.Count()
+		}
+		rr := RouteResult{
+			Path:     t.path,
+			URL:      t.url,
+			Requests: t.requests.Load(),
+			Errors:   t.errors.Load(),
+			Latency:  hdr.Merged(t.histograms),
+		}
+		res.Requests += rr.Requests
+		res.Errors += rr.Errors
+		res.Latency.Merge(rr.Latency)
+		if len(cfg.Routes) > 0 || cfg.Scenario != nil {
+			res.Routes = append(res.Routes, rr)
+		}
+	}
+	return res
+}
+
+func (r *run) worker(id int, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	rng := payload.NewRand(r.cfg.Seed, id)
+	sched := r.newSchedule(id, rng)
+	client := r.cfg.client(id)
+	var session map[string]string
+	if r.cfg.loginStep() != nil {
+		var ok bool
+		if client, session, ok = r.login(id, client, sched); !ok {
+			return
+		}
+	}
+	var issue func() (target int, class string, retries int)
+	var uploaded int64 // file size of the last request under Upload
+	if r.cfg.Scenario != nil {
+		issue = newVirtualUser(r, id, client, session).issue
+	} else {
+		reqs := make([]*request, len(r.targets))
+		for i, t := range r.targets {
+			reqs[i] = newRequest(&r.cfg, t.url, session, rng)
+		}
+		routes := newPicker(r.targets, rng)
+		issue = func() (int, string, int) {
+			i := routes.pick()
+			class, retries := r.cfg.Retry.Do(r.deadline, func() string {
+				return r.do(client, reqs[i].next())
+			})
+			uploaded = reqs[i].size
+			return i, class, retries
+		}
+	}
+
+	raw := r.cfg.Raw.Worker(id)
+	defer raw.Flush()
+	for {
+		reqStart, ok := sched.wait()
+		if !ok {
+			return
+		}
+
+		measured := r.measuring.Load()
+		counted := measured && r.cfg.Requests > 0
+		if counted && !r.take() {
+			return
+		}
+		r.cfg.Metrics.Begin()
+		i, class, retries := issue()
+		d := time.Since(reqStart)
+		r.cfg.Metrics.End(d, class)
+		if class != "" && r.ctx.Err() != nil {
+			// Cut short by the interrupt, not the server's failure
+			return
+		}
+		if class == "" {
+			r.cfg.Progress.Record(id, d)
+		} else {
+			r.cfg.Progress.Error(id)
+		}
+		if counted && class != "" {
+			r.left.Add(1)
+		}
+		if !measured {
+			continue
+		}
+		raw.Record(reqStart, d, class == "")
+		if retries > 0 {
+			r.retries.Add(int64(retries))
+			if class == "" {
+				r.retried.Add(1)
+			}
+		}
+		if class == "" && uploaded > 0 {
+			r.uploaded.Add(uploaded)
+		}
+		t := r.targets[i]
+		if class == "" {
+			t.requests.Add(1)
+			t.histograms[id].Record(d)
+		} else {
+			t.errors.Add(1)
+			r.classes[id][class]++
+		}
+	}
+}
+
+// sleep pauses for d, or until ctx is cancelled, and reports whether the
+// full pause elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// take claims one of the successes left under Config.Requests, or reports
+// false once none are left. Tickets of failed requests return to the pool,
+// so a worker that fails keeps going until the count is reached.
+func (r *run) take() bool {
+	if r.left.Add(-1) >= 0 {
+		return true
+	}
+	r.left.Add(1)
+	return false
+}
+
+// do issues a single request and returns its error class, or "" when it
+// succeeded.
+func (r *run) do(client *http.Client, req *http.Request) string {
+	req, a := r.cfg.Timeouts.start(req)
+	defer a.finish()
+	resp, err := client.Do(req)
+	if err != nil {
+		return a.class(err)
+	}
+	a.responded()
+
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Read and discard body
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			return a.class(err)
+		}
+		return errclass.Status(resp.StatusCode)
+	}
+
+	ok, err := r.cfg.Check.verify(resp.Body)
+	switch {
+	case err != nil:
+		return a.class(err)
+	case !ok:
+		return classMismatch
+	}
+	return ""
+}
@@ -0,0 +1,334 @@
+package httpload
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"benchmarks/internal/benchtest"
+	"benchmarks/internal/retry"
+	"golang.org/x/net/http2"
+)
+
+func TestRunCountsRequests(t *testing.T) {
+	var served atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1)%2 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	res := Run(Config{Client: srv.Client(), URL: srv.URL, Concurrency: 2, Duration: 100 * time.Millisecond})
+	if res.Requests == 0 || res.Errors == 0 {
+		t.Fatalf("expected both successes and errors, got %+v", res)
+	}
+	if res.Latency.Count() != res.Requests {
+		t.Errorf("histogram holds %d samples for %d requests", res.Latency.Count(), res.Requests)
+	}
+	if len(res.Workers) != 2 || res.Workers[0]+res.Workers[1] != res.Requests {
+		t.Errorf("per-worker counts %v do not add up to %d requests", res.Workers, res.Requests)
+	}
+	if total := res.Requests + res.Errors; total > served.Load() {
+		t.Errorf("counted %d requests but server saw %d", total, served.Load())
+	}
+	if got := res.ErrorClasses["status_500"]; got != res.Errors {
+		t.Errorf("classified %d of %d errors as status_500: %v", got, res.Errors, res.ErrorClasses)
+	}
+}
+
+func TestRunRequestCount(t *testing.T) {
+	var served atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1)%3 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := Config{Client: srv.Client(), URL: srv.URL, Concurrency: 8, Requests: 500}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests != 500 || res.Errors == 0 {
+		t.Errorf("%d requests, %d errors; want exactly 500 successes despite errors", res.Requests, res.Errors)
+	}
+}
+
+func TestRunInterrupt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Millisecond):
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	res := Run(Config{Client: srv.Client(), URL: srv.URL, Concurrency: 4, Duration: time.Minute, Rate: 200, Context: ctx})
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("run took %v after the interrupt", d)
+	}
+	// Requests cut short by the interrupt are not errors
+	if res.Requests == 0 || res.Errors != 0 {
+		t.Errorf("%d requests, %d errors (%v); want partial results without errors", res.Requests, res.Errors, res.ErrorClasses)
+	}
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	http.RoundTripper
+	n atomic.Int64
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return c.RoundTripper.RoundTrip(req)
+}
+
+// refusingTransport refuses the first n requests as a restarting server
+// would.
+type refusingTransport struct {
+	http.RoundTripper
+	n atomic.Int64
+}
+
+func (f *refusingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if f.n.Add(-1) >= 0 {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	}
+	return f.RoundTripper.RoundTrip(req)
+}
+
+func TestRunRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// Three refusals exhaust the first request's retries, the next two
+	// delay the second
+	tr := &refusingTransport{RoundTripper: srv.Client().Transport}
+	tr.n.Store(5)
+	res := Run(Config{
+		Client:      &http.Client{Transport: tr},
+		URL:         srv.URL,
+		Concurrency: 1,
+		Duration:    100 * time.Millisecond,
+		Retry:       retry.Policy{Attempts: 2, Backoff: time.Millisecond},
+	})
+	if res.Errors != 1 || res.ErrorClasses["refused"] != 1 {
+		t.Errorf("errors %d %v, want one refused", res.Errors, res.ErrorClasses)
+	}
+	if res.Retried != 1 || res.Retries != 4 {
+		t.Errorf("retried %d with %d retries, want 1 with 4", res.Retried, res.Retries)
+	}
+	if res.Requests < 2 {
+		t.Errorf("only %d requests", res.Requests)
+	}
+}
+
+func TestClientsSpreadWorkers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var ts [3]*countingTransport
+	var clients []*http.Client
+	for i := range ts {
+		ts[i] = &countingTransport{RoundTripper: srv.Client().Transport}
+		clients = append(clients, &http.Client{Transport: ts[i]})
+	}
+	res := Run(Config{Clients: clients, URL: srv.URL, Concurrency: 3, Duration: 50 * time.Millisecond})
+	if res.Requests == 0 {
+		t.Fatal("no requests")
+	}
+	for i, tr := range ts {
+		if tr.n.Load() == 0 {
+			t.Errorf("client %d sent nothing", i)
+		}
+	}
+}
+
+func TestWarmupIsExcluded(t *testing.T) {
+	var served atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+	}))
+	defer srv.Close()
+
+	res := Run(Config{Client: srv.Client(), URL: srv.URL, Concurrency: 1, Duration: 50 * time.Millisecond, Warmup: 100 * time.Millisecond})
+	if res.Requests == 0 {
+		t.Fatal("no requests measured")
+	}
+	if res.Requests >= served.Load() {
+		t.Errorf("measured %d of %d served requests; warmup was not excluded", res.Requests, served.Load())
+	}
+	if res.Elapsed >= 100*time.Millisecond {
+		t.Errorf("elapsed %v includes the warmup", res.Elapsed)
+	}
+}
+
+func TestStagesFlag(t *testing.T) {
+	var s Stages
+	if err := s.Set("10:5s, 100:1m"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 2 || s[1].Concurrency != 100 || s[1].Duration != time.Minute {
+		t.Errorf("parsed %+v", s)
+	}
+	if s.String() != "10:5s,100:1m0s" {
+		t.Errorf("String() = %q", s.String())
+	}
+	for _, bad := range []string{"10", "0:1s", "x:1s", "10:0s", "10:soon"} {
+		if err := new(Stages).Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRunStages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	results := RunStages(Config{
+		Client: srv.Client(),
+		URL:    srv.URL,
+		Stages: Stages{{Concurrency: 1, Duration: 30 * time.Millisecond}, {Concurrency: 4, Duration: 30 * time.Millisecond}},
+	})
+	if len(results) != 2 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, want := range []int{1, 4} {
+		if results[i].Concurrency != want || results[i].Requests == 0 {
+			t.Errorf("stage %d: %+v", i, results[i])
+		}
+	}
+}
+
+func TestOpenLoopRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	res := Run(Config{Client: srv.Client(), URL: srv.URL, Concurrency: 4, Duration: 500 * time.Millisecond, Rate: 200})
+	if total := res.Requests + res.Errors; total < 80 || total > 120 {
+		t.Errorf("sent %d requests at 200/s over 500ms, want ~100", total)
+	}
+}
+
+func TestOpenLoopChargesStalls(t *testing.T) {
+	var served atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	// The first request stalls for 100ms. Closed-loop would record a single
+	// slow sample; open-loop must also charge the ~9 requests scheduled
+	// behind it.
+	res := Run(Config{Client: srv.Client(), URL: srv.URL, Concurrency: 1, Duration: 300 * time.Millisecond, Rate: 100})
+	if p := res.Latency.ValueAtQuantile(0.75); p < 5*time.Millisecond {
+		t.Errorf("p75 = %v; stalled requests were not charged their wait", p)
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	body := []byte(`{"name":"bench"}`)
+	var bad atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut || !bytes.Equal(got, body) || r.Header.Get("Content-Type") != "application/json" {
+			bad.Add(1)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	cfg := Config{Client: srv.Client(), URL: srv.URL, Concurrency: 2, Duration: 50 * time.Millisecond, Method: http.MethodPut, Body: body, ContentType: "application/json"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests < 2 || res.Errors != 0 || bad.Load() != 0 {
+		t.Errorf("requests=%d errors=%d malformed=%d", res.Requests, res.Errors, bad.Load())
+	}
+}
+
+func TestRequestBodyPerSend(t *testing.T) {
+	// The transport may still be sending a body after RoundTrip returns,
+	// when the server answered early or the request timed out
+	body := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	cfg := Config{URL: "http://localhost/", Body: body, ContentType: "text/plain"}
+	r := newRequest(&cfg, cfg.URL, nil, nil)
+	first := r.next()
+	half := make([]byte, len(body)/2)
+	io.ReadFull(first.Body, half)
+	second := r.next()
+	rest, _ := io.ReadAll(first.Body)
+	if got := append(half, rest...); !bytes.Equal(got, body) {
+		t.Errorf("first body read %d bytes, want %d intact", len(got), len(body))
+	}
+	if got, _ := io.ReadAll(second.Body); !bytes.Equal(got, body) {
+		t.Errorf("second body read %d bytes, want %d intact", len(got), len(body))
+	}
+	if first == second || first.GetBody == nil {
+		t.Error("sends share a request, or the body cannot be replayed")
+	}
+}
+
+func TestDefaultMethod(t *testing.T) {
+	if m := (&Config{}).method(); m != http.MethodGet {
+		t.Errorf("method without body = %s", m)
+	}
+	if m := (&Config{Body: []byte("x")}).method(); m != http.MethodPost {
+		t.Errorf("method with body = %s", m)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, u := range []string{"localhost:8070", "ftp://x/", "http://[::1"} {
+		if err := (&Config{URL: u}).Validate(); err == nil {
+			t.Errorf("Validate(%q) succeeded", u)
+		}
+	}
+}
+
+func BenchmarkHTTP1(b *testing.B) {
+	srv := benchtest.HTTPServer(b)
+	transport := &http.Transport{MaxIdleConnsPerHost: benchtest.Concurrency}
+	b.Cleanup(transport.CloseIdleConnections)
+	benchmarkRun(b, srv.URL, &http.Client{Transport: transport})
+}
+
+func BenchmarkHTTP2(b *testing.B) {
+	srv := benchtest.HTTPServer(b)
+	var dialer net.Dialer
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	b.Cleanup(transport.CloseIdleConnections)
+	benchmarkRun(b, srv.URL, &http.Client{Transport: transport})
+}
+
+func benchmarkRun(b *testing.B, url string, client *http.Client) {
+	cfg := Config{Client: client, URL: url, Concurrency: benchtest.Concurrency, Requests: int64(b.N)}
+	if err := cfg.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	results := cfg.Reports("httpload", []Result{Run(cfg)})
+	benchtest.Report(b, results[0])
+}
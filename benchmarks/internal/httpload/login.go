@@ -0,0 +1,96 @@
+package httpload
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+
+	"benchmarks/internal/scenario"
+)
+
+// classLogin prefixes the error class of a failed login, e.g.
+// login_status_401.
+const classLogin = "login_"
+
+// registerLogin adds the flags that build Config.Login.
+func (c *Config) registerLogin(fs *flag.FlagSet) {
+	step := func() *scenario.Step {
+		if c.Login == nil {
+			c.Login = &scenario.Step{Name: "login"}
+		}
+		return c.Login
+	}
+	fs.Func("login", "path or URL each virtual user requests once before its first request to open a session; its cookies are kept per user", func(s string) error {
+		step().Path = s
+		return nil
+	})
+	fs.Func("login-body", "body of the -login request, sent as JSON with POST; ${vu} is the virtual user's number", func(s string) error {
+		step().Body = s
+		return nil
+	})
+	fs.Func("login-extract", "capture name=source from the -login response for use as ${name} in -H, e.g. token=json:access_token (repeatable)", func(s string) error {
+		name, src, ok := strings.Cut(s, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("%q is not name=source", s)
+		}
+		st := step()
+		if st.Extract == nil {
+			st.Extract = map[string]string{}
+		}
+		st.Extract[name] = src
+		return nil
+	})
+}
+
+// loginStep returns the step that opens each virtual user's session, from
+// the -login flags or the scenario, or nil.
+func (c *Config) loginStep() *scenario.Step {
+	if c.Login != nil {
+		return c.Login
+	}
+	if c.Scenario != nil {
+		return c.Scenario.Login
+	}
+	return nil
+}
+
+// initialVars returns the variables virtual user id starts with.
+func (c *Config) initialVars(id int) map[string]string {
+	if c.Scenario != nil {
+		return c.Scenario.InitialVars(id)
+	}
+	return (&scenario.Scenario{}).InitialVars(id)
+}
+
+// login opens worker id's session: a client of its own with a cookie jar,
+// sharing client's transport, and the variables the login extracted. A
+// failed login is counted and retried at the worker's next turn; it
+// returns false if the run ends first.
+func (r *run) login(id int, client *http.Client, sched *schedule) (*http.Client, map[string]string, bool) {
+	st := r.cfg.loginStep()
+	jar, _ := cookiejar.New(nil) // fails only for bad options
+	own := *client
+	own.Jar = jar
+	for {
+		vars := r.cfg.initialVars(id)
+		class, _ := r.cfg.Retry.Do(r.deadline, func() string {
+			return r.send(&own, st, vars)
+		})
+		if class == "" {
+			return &own, vars, true
+		}
+		if r.ctx.Err() != nil {
+			return nil, nil, false
+		}
+		r.cfg.Progress.Error(id)
+		if r.measuring.Load() {
+			r.loginErrors.Add(1)
+			r.classes[id][classLogin+class]++
+		}
+		if _, ok := sched.wait(); !ok {
+			return nil, nil, false
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package httpload
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLogin(t *testing.T) {
+	var logins atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != `{"user":"bench-`+r.URL.Query().Get("vu")+`"}` {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			logins.Add(1)
+			vu := r.URL.Query().Get("vu")
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s" + vu})
+			io.WriteString(w, `{"token":"t`+vu+`"}`)
+		case "/private":
+			c, err := r.Cookie("session")
+			if err != nil || r.Header.Get("Authorization") != "Bearer t"+c.Value[1:] {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	cfg := Config{Client: srv.Client(), URL: srv.URL + "/private", Concurrency: 3, Duration: 100 * time.Millisecond}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.Register(fs)
+	err := fs.Parse([]string{
+		"-login", "/login?vu=${vu}",
+		"-login-body", `{"user":"bench-${vu}"}`,
+		"-login-extract", "token=json:token",
+		"-H", "Authorization: Bearer ${token}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests == 0 || res.Errors != 0 {
+		t.Errorf("%d requests, %d errors (%s)", res.Requests, res.Errors, res.ErrorClasses)
+	}
+	if logins.Load() != 3 {
+		t.Errorf("%d logins, want one per worker", logins.Load())
+	}
+
+	cfg.Login.Body = "wrong"
+	res = Run(cfg)
+	if res.Requests != 0 || res.ErrorClasses["login_status_401"] == 0 || res.Errors != res.ErrorClasses.Total() {
+		t.Errorf("failed login: %d requests, %d errors (%s)", res.Requests, res.Errors, res.ErrorClasses)
+	}
+}
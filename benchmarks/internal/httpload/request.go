@@ -0,0 +1,99 @@
+package httpload
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"net/http"
+
+	"benchmarks/internal/payload"
+)
+
+// request is a worker's request template. Each worker builds one up front
+// and sends a shallow copy of it with a body of its own every time, since
+// the transport may still be reading the previous send's body after
+// RoundTrip returns: when the server answered before reading it all, or
+// the request timed out. A GET is sent as it is.
+type request struct {
+	req  *http.Request
+	body []byte
+
+	// With Config.Upload the body is a generated file instead, of a size
+	// drawn afresh for every request.
+	upload *uploadBody
+	sizer  *payload.Sizer
+	size   int64
+}
+
+// bodyReader lets a bytes.Reader serve as a request body without the
+// allocation io.NopCloser would cost.
+type bodyReader struct {
+	bytes.Reader
+}
+
+func (*bodyReader) Close() error { return nil }
+
+func (c *Config) method() string {
+	switch {
+	case c.Method != "":
+		return c.Method
+	case len(c.Body) > 0, c.Upload.Enabled():
+		return http.MethodPost
+	default:
+		return http.MethodGet
+	}
+}
+
+// newRequest builds the template for c and url, with vars expanded in the
+// headers unless nil and upload sizes drawn from rng. The URL and method
+// were checked by Validate, so construction cannot fail.
+func newRequest(c *Config, url string, vars map[string]string, rng *rand.Rand) *request {
+	req, err := http.NewRequestWithContext(c.context(), c.method(), url, nil)
+	if err != nil {
+		panic(err)
+	}
+	r := &request{req: req}
+	c.Headers.apply(req, vars)
+	if len(c.Body) > 0 {
+		body := c.Body
+		r.body = body
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Type", c.ContentType)
+		// GetBody lets the transport replay the body after a retryable
+		// connection failure.
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	if c.Upload.Enabled() {
+		var contentType string
+		r.upload, contentType = newUploadBody(c.Upload.Field)
+		r.sizer, _ = payload.NewSizer(c.Upload.Dist, int(c.Upload.Size), rng) // checked by Validate
+		req.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+// next returns the request ready to send again.
+func (r *request) next() *http.Request {
+	if r.upload == nil && r.body == nil {
+		return r.req
+	}
+	req := new(http.Request)
+	*req = *r.req
+	if r.upload != nil {
+		size := int64(r.sizer.Next())
+		r.size = size
+		req.Body, req.ContentLength = r.upload.clone(size)
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, _ := r.upload.clone(size)
+			return body, nil
+		}
+	}
+	if r.body != nil {
+		rd := new(bodyReader)
+		rd.Reset(r.body)
+		req.Body = rd
+	}
+	return req
+}
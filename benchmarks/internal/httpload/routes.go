@@ -0,0 +1,128 @@
+package httpload
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"benchmarks/internal/hdr"
+)
+
+// Route is one entry of a weighted route mix.
+type Route struct {
+	Path   string
+	Weight int
+}
+
+// Routes is a flag.Value parsing comma-separated path:weight pairs such as
+// "/:70,/json:20,/users/42:10". A missing weight counts as 1.
+type Routes []Route
+
+func (rs *Routes) String() string {
+	if rs == nil {
+		return ""
+	}
+	parts := make([]string, len(*rs))
+	for i, r := range *rs {
+		parts[i] = fmt.Sprintf("%s:%d", r.Path, r.Weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (rs *Routes) Set(v string) error {
+	var out Routes
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		r := Route{Path: part, Weight: 1}
+		if i := strings.LastIndexByte(part, ':'); i >= 0 {
+			if w, err := strconv.Atoi(part[i+1:]); err == nil {
+				r.Path, r.Weight = part[:i], w
+			}
+		}
+		if r.Path == "" || r.Weight < 1 {
+			return fmt.Errorf("invalid route %q", part)
+		}
+		out = append(out, r)
+	}
+	*rs = out
+	return nil
+}
+
+// target is a resolved route with the counters every worker feeds.
+type target struct {
+	path       string
+	url        string
+	weight     int
+	requests   atomic.Int64
+	errors     atomic.Int64
+	histograms []*hdr.Histogram // one per worker
+}
+
+// targets resolves the route mix or scenario steps against the base URL.
+// Without either the base URL is the only target.
+func (c *Config) targets() ([]*target, error) {
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if c.Scenario != nil {
+		ts := make([]*target, len(c.Scenario.Steps))
+		for i, st := range c.Scenario.Steps {
+			// Step paths may hold ${vars}; the raw path is only a label.
+			ts[i] = &target{path: st.Name, url: st.Path, weight: 1}
+		}
+		return ts, nil
+	}
+	if len(c.Routes) == 0 {
+		return []*target{{path: c.URL, url: c.URL, weight: 1}}, nil
+	}
+	ts := make([]*target, len(c.Routes))
+	for i, r := range c.Routes {
+		ref, err := url.Parse(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", r.Path, err)
+		}
+		ts[i] = &target{path: r.Path, url: base.ResolveReference(ref).String(), weight: r.Weight}
+	}
+	return ts, nil
+}
+
+// breakdown names what the per-target results are split by.
+func (c *Config) breakdown() string {
+	if c.Scenario != nil {
+		return "step"
+	}
+	return "route"
+}
+
+// picker chooses targets in proportion to their weights.
+type picker struct {
+	rng        *rand.Rand
+	cumulative []int
+}
+
+func newPicker(ts []*target, rng *rand.Rand) picker {
+	p := picker{rng: rng, cumulative: make([]int, len(ts))}
+	sum := 0
+	for i, t := range ts {
+		sum += t.weight
+		p.cumulative[i] = sum
+	}
+	return p
+}
+
+func (p picker) pick() int {
+	if len(p.cumulative) == 1 {
+		return 0
+	}
+	n := p.rng.IntN(p.cumulative[len(p.cumulative)-1])
+	for i, c := range p.cumulative {
+		if n < c {
+			return i
+		}
+	}
+	return len(p.cumulative) - 1
+}
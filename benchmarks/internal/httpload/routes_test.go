@@ -0,0 +1,120 @@
+package httpload
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoutesFlag(t *testing.T) {
+	var rs Routes
+	if err := rs.Set("/:70, /json:20,/users/42"); err != nil {
+		t.Fatal(err)
+	}
+	want := Routes{{"/", 70}, {"/json", 20}, {"/users/42", 1}}
+	if len(rs) != len(want) {
+		t.Fatalf("parsed %+v", rs)
+	}
+	for i := range want {
+		if rs[i] != want[i] {
+			t.Errorf("route %d = %+v, want %+v", i, rs[i], want[i])
+		}
+	}
+	for _, bad := range []string{"", "/:0", ":5"} {
+		if err := new(Routes).Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPickerFollowsWeights(t *testing.T) {
+	ts := []*target{{weight: 70}, {weight: 20}, {weight: 10}}
+	p := newPicker(ts, rand.New(rand.NewPCG(1, 2)))
+	counts := make([]int, len(ts))
+	for i := 0; i < 100000; i++ {
+		counts[p.pick()]++
+	}
+	for i, want := range []int{70000, 20000, 10000} {
+		if d := counts[i] - want; d < -1000 || d > 1000 {
+			t.Errorf("target %d picked %d times, want ~%d", i, counts[i], want)
+		}
+	}
+}
+
+func TestRouteMix(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := Config{Client: srv.Client(), URL: srv.URL + "/api/", Concurrency: 2, Duration: 100 * time.Millisecond}
+	cfg.Routes.Set("/:3,json:1,/missing:1")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+
+	if len(res.Routes) != 3 {
+		t.Fatalf("got %d route results", len(res.Routes))
+	}
+	byPath := map[string]RouteResult{}
+	var total int64
+	for _, rr := range res.Routes {
+		byPath[rr.Path] = rr
+		total += rr.Requests
+	}
+	if byPath["/"].Requests == 0 || byPath["json"].Requests == 0 || byPath["/missing"].Errors == 0 {
+		t.Errorf("unexpected per-route results %+v", res.Routes)
+	}
+	if byPath["json"].URL != srv.URL+"/api/json" {
+		t.Errorf("relative route resolved to %s", byPath["json"].URL)
+	}
+	if total != res.Requests || res.Latency.Count() != res.Requests {
+		t.Errorf("route totals %d do not add up to %d", total, res.Requests)
+	}
+	if seen["/api/json"] == 0 {
+		t.Errorf("server never saw /api/json: %v", seen)
+	}
+
+	reports := cfg.Reports("bench_http", []Result{res})
+	if len(reports) != 4 || reports[1].Label != "route /" {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+}
+
+func TestSeedRepeatsRoutes(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	sequence := func(seed uint64) string {
+		paths = nil
+		cfg := Config{Client: srv.Client(), URL: srv.URL, Concurrency: 1, Requests: 50, Seed: seed}
+		cfg.Routes.Set("/a:1,/b:1,/c:1")
+		Run(cfg)
+		return strings.Join(paths, ",")
+	}
+	first := sequence(42)
+	if again := sequence(42); again != first {
+		t.Errorf("seed 42 picked\n%s\nthen\n%s", first, again)
+	}
+	if other := sequence(43); other == first {
+		t.Error("seeds 42 and 43 picked the same routes")
+	}
+}
@@ -0,0 +1,95 @@
+package httpload
+
+import (
+	"io"
+	"net/http"
+
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/scenario"
+)
+
+// maxExtractBody bounds how much of a response is kept for extraction.
+const maxExtractBody = 1 << 20
+
+// Error classes specific to scenario steps.
+const (
+	classRequest = "request" // the step could not be built, e.g. a bad ${var}
+	classExpect  = "expect"  // a 2xx that failed expect or extract
+)
+
+// virtualUser walks a scenario's steps in order, carrying extracted
+// variables from one step to the next. A failed step restarts the sequence
+// with fresh variables, since later steps usually depend on its output.
+type virtualUser struct {
+	r       *run
+	id      int
+	client  *http.Client
+	step    int
+	vars    map[string]string
+	session map[string]string // extracted by the login, kept across iterations
+}
+
+func newVirtualUser(r *run, id int, client *http.Client, session map[string]string) *virtualUser {
+	v := &virtualUser{r: r, id: id, client: client, session: session}
+	v.reset()
+	return v
+}
+
+// reset starts a new iteration with fresh variables.
+func (v *virtualUser) reset() {
+	v.vars = v.r.cfg.Scenario.InitialVars(v.id)
+	for k, val := range v.session {
+		v.vars[k] = val
+	}
+}
+
+// issue sends the current step, retrying it under the run's policy, and
+// reports its index, error class and the retries made.
+func (v *virtualUser) issue() (int, string, int) {
+	i := v.step
+	st := &v.r.cfg.Scenario.Steps[i]
+	class, retries := v.r.cfg.Retry.Do(v.r.deadline, func() string { return v.r.send(v.client, st, v.vars) })
+	v.step = (i + 1) % len(v.r.cfg.Scenario.Steps)
+	if class != "" {
+		v.step = 0
+	}
+	if v.step == 0 {
+		v.reset()
+	}
+	return i, class, retries
+}
+
+// send issues step st with vars, storing what it extracts in vars, and
+// returns its error class.
+func (r *run) send(client *http.Client, st *scenario.Step, vars map[string]string) string {
+	req, err := st.NewRequest(r.base, vars)
+	if err != nil {
+		return classRequest
+	}
+	req = req.WithContext(r.ctx)
+	r.cfg.Headers.apply(req, vars)
+	req, a := r.cfg.Timeouts.start(req)
+	defer a.finish()
+	resp, err := client.Do(req)
+	if err != nil {
+		return a.class(err)
+	}
+	a.responded()
+	defer resp.Body.Close()
+
+	var body []byte
+	if st.NeedsBody() {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxExtractBody))
+		if err != nil {
+			return a.class(err)
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	if err := st.Check(resp, body, vars); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return errclass.Status(resp.StatusCode)
+		}
+		return classExpect
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package httpload
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"benchmarks/internal/scenario"
+)
+
+func TestScenarioRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			io.WriteString(w, `{"token":"vu-`+r.URL.Query().Get("vu")+`"}`)
+		case "/items/vu-0", "/items/vu-1":
+			if r.Header.Get("X-Bench") != "1" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	sc, err := scenario.Parse([]byte(`
+name: login-fetch
+steps:
+  - name: login
+    path: /login?vu=${vu}
+    extract: {token: 'json:token'}
+  - name: fetch
+    path: /items/${token}
+  - name: broken
+    path: /nowhere
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Client: srv.Client(), URL: srv.URL, Concurrency: 2, Duration: 100 * time.Millisecond, Scenario: sc}
+	cfg.Headers.Set("X-Bench: 1")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if len(res.Routes) != 3 {
+		t.Fatalf("got %d step results", len(res.Routes))
+	}
+	login, fetch, broken := res.Routes[0], res.Routes[1], res.Routes[2]
+	if login.Requests == 0 || login.Errors != 0 || fetch.Requests == 0 || fetch.Errors != 0 {
+		t.Errorf("login %+v, fetch %+v", login, fetch)
+	}
+	if broken.Requests != 0 || broken.Errors == 0 {
+		t.Errorf("broken step %+v", broken)
+	}
+	if reports := cfg.Reports("bench_http", []Result{res}); reports[2].Label != "step fetch" {
+		t.Errorf("label = %q", reports[2].Label)
+	}
+
+	cfg.Routes.Set("/")
+	if err := cfg.Validate(); err == nil {
+		t.Error("-scenario with -routes validated")
+	}
+}
@@ -0,0 +1,71 @@
+package httpload
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// schedule decides when a worker sends its next request.
+type schedule struct {
+	ctx      context.Context
+	deadline time.Time
+
+	// In closed-loop mode with think time the worker pauses before every
+	// request but its first.
+	think   *Think
+	rng     *rand.Rand
+	started bool
+
+	// In open-loop mode each worker owns an evenly spaced slice of the
+	// global schedule, offset so the workers interleave.
+	interval time.Duration
+	next     time.Time
+}
+
+func (r *run) newSchedule(id int, rng *rand.Rand) *schedule {
+	s := &schedule{ctx: r.ctx, deadline: r.deadline}
+	if r.cfg.Think.Enabled() {
+		s.think, s.rng = &r.cfg.Think, rng
+	}
+	if r.cfg.Rate > 0 {
+		s.interval = time.Duration(float64(time.Second) * float64(r.cfg.Concurrency) / r.cfg.Rate)
+		s.next = r.begin.Add(s.interval * time.Duration(id) / time.Duration(r.cfg.Concurrency))
+	}
+	return s
+}
+
+// wait blocks until the next request is due and returns the time its
+// latency is measured from, or false once the run is over or interrupted.
+func (s *schedule) wait() (time.Time, bool) {
+	if s.ctx.Err() != nil {
+		return time.Time{}, false
+	}
+	if s.interval == 0 {
+		if s.think != nil && s.started {
+			pause := s.think.next(s.rng)
+			if time.Until(s.deadline) <= pause || !sleep(s.ctx, pause) {
+				return time.Time{}, false
+			}
+		}
+		s.started = true
+		now := time.Now()
+		return now, now.Before(s.deadline)
+	}
+	if !s.next.Before(s.deadline) {
+		return time.Time{}, false
+	}
+	due := s.next
+	s.next = s.next.Add(s.interval)
+	if wait := time.Until(due); wait > 0 {
+		// On schedule. Timer overshoot is the client's doing, so measure
+		// from the actual send.
+		if !sleep(s.ctx, wait) {
+			return time.Time{}, false
+		}
+		return time.Now(), true
+	}
+	// Behind schedule because earlier responses were slow: send
+	// immediately and charge the backlog to the request.
+	return due, true
+}
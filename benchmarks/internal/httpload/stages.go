@@ -0,0 +1,73 @@
+package httpload
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stage is one step of a load profile.
+type Stage struct {
+	Concurrency int
+	Duration    time.Duration
+}
+
+// Stages is a flag.Value parsing comma-separated workers:duration steps.
+type Stages []Stage
+
+func (s *Stages) String() string {
+	if s == nil {
+		return ""
+	}
+	parts := make([]string, len(*s))
+	for i, st := range *s {
+		parts[i] = fmt.Sprintf("%d:%v", st.Concurrency, st.Duration)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *Stages) Set(v string) error {
+	var out Stages
+	for _, part := range strings.Split(v, ",") {
+		workers, dur, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return fmt.Errorf("stage %q is not workers:duration", part)
+		}
+		c, err := strconv.Atoi(workers)
+		if err != nil || c < 1 {
+			return fmt.Errorf("stage %q: invalid worker count", part)
+		}
+		d, err := time.ParseDuration(dur)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("stage %q: invalid duration", part)
+		}
+		out = append(out, Stage{Concurrency: c, Duration: d})
+	}
+	*s = out
+	return nil
+}
+
+// RunStages runs cfg once per stage and returns one result per stage, or a
+// single result when cfg has no stages. The client's connection pool is
+// reused across stages, so stepping the worker count does not reconnect;
+// only the first stage is preceded by the warmup. Cancelling cfg.Context
+// ends the profile with the stage it cut short.
+func RunStages(cfg Config) []Result {
+	if len(cfg.Stages) == 0 {
+		return []Result{Run(cfg)}
+	}
+	results := make([]Result, 0, len(cfg.Stages))
+	for i, st := range cfg.Stages {
+		if i > 0 && cfg.context().Err() != nil {
+			break
+		}
+		c := cfg
+		c.Concurrency, c.Duration = st.Concurrency, st.Duration
+		if i > 0 {
+			c.Warmup = 0
+		}
+		results = append(results, Run(c))
+	}
+	return results
+}
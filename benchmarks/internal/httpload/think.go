@@ -0,0 +1,74 @@
+package httpload
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Think-time distributions.
+const (
+	ThinkFixed       = "fixed"
+	ThinkExponential = "exponential"
+)
+
+// Think is a pause each virtual user takes between receiving a response
+// and sending its next request, as a real user reads a page. With it a
+// closed-loop run models a user population instead of saturating the
+// server, and the per-user rate it reports sizes the population a target
+// RPS needs.
+type Think struct {
+	// Mean is the average pause; zero disables think time.
+	Mean time.Duration
+	// Dist is ThinkFixed or ThinkExponential, whose pauses vary as the
+	// gaps between independent arrivals do.
+	Dist string
+}
+
+// Register adds the think-time flags to fs.
+func (t *Think) Register(fs *flag.FlagSet) {
+	if t.Dist == "" {
+		t.Dist = ThinkFixed
+	}
+	fs.DurationVar(&t.Mean, "think", t.Mean, "pause each worker takes between a response and its next request, e.g. 50ms (closed loop only)")
+	fs.StringVar(&t.Dist, "think-dist", t.Dist, "think time distribution: fixed or exponential (mean -think)")
+}
+
+// Enabled reports whether workers pause between requests.
+func (t *Think) Enabled() bool {
+	return t.Mean > 0
+}
+
+func (t *Think) validate() error {
+	switch t.Dist {
+	case "":
+		t.Dist = ThinkFixed
+	case ThinkFixed, ThinkExponential:
+	default:
+		return fmt.Errorf("unknown think time distribution %q (want fixed or exponential)", t.Dist)
+	}
+	if t.Mean < 0 {
+		return errors.New("-think must not be negative")
+	}
+	return nil
+}
+
+// next draws a pause.
+func (t *Think) next(rng *rand.Rand) time.Duration {
+	if t.Dist == ThinkExponential {
+		return time.Duration(rng.ExpFloat64() * float64(t.Mean))
+	}
+	return t.Mean
+}
+
+// userRate returns the requests per second each worker of res completed.
+func (res *Result) userRate() float64 {
+	return float64(res.Requests) / res.Elapsed.Seconds() / float64(res.Concurrency)
+}
+
+// String describes t for run headers.
+func (t *Think) String() string {
+	return fmt.Sprintf("%v (%s)", t.Mean, t.Dist)
+}
@@ -0,0 +1,49 @@
+package httpload
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cfg := Config{Client: srv.Client(), URL: srv.URL, Concurrency: 2, Duration: 200 * time.Millisecond, Think: Think{Mean: 20 * time.Millisecond}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	// Ten turns per worker at most, the first without a pause
+	if res.Requests < 10 || res.Requests > 20 {
+		t.Errorf("%d requests, want 10 to 20", res.Requests)
+	}
+	if res.Latency.Max() >= 20*time.Millisecond {
+		t.Errorf("latency %v includes think time", res.Latency.Max())
+	}
+
+	cfg.Rate = 100
+	if err := cfg.Validate(); err == nil {
+		t.Error("-think with -rate validated")
+	}
+	cfg.Rate, cfg.Think.Dist = 0, "gamma"
+	if err := cfg.Validate(); err == nil {
+		t.Error("unknown distribution validated")
+	}
+}
+
+func TestThinkExponential(t *testing.T) {
+	th := Think{Mean: time.Millisecond, Dist: ThinkExponential}
+	rng := rand.New(rand.NewPCG(1, 2))
+	var sum time.Duration
+	const n = 10000
+	for range n {
+		sum += th.next(rng)
+	}
+	if mean := sum / n; mean < 900*time.Microsecond || mean > 1100*time.Microsecond {
+		t.Errorf("mean %v, want about 1ms", mean)
+	}
+}
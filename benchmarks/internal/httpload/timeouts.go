@@ -0,0 +1,165 @@
+package httpload
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/errclass"
+)
+
+// Timeouts bound each request as a whole and phase by phase, so a slow
+// connect is not mistaken for a slow response. Zero disables a limit.
+type Timeouts struct {
+	// Request covers the whole exchange, body included (-timeout).
+	Request time.Duration
+	// Connect runs until the transport has a connection ready for the
+	// request, TLS handshake included.
+	Connect time.Duration
+	// Header runs from then until the first byte of the response.
+	Header time.Duration
+	// Body runs from the first response byte until the body is read.
+	Body time.Duration
+}
+
+// Register adds the phase timeout flags to fs. Request is left to the
+// tools' common -timeout flag.
+func (t *Timeouts) Register(fs *flag.FlagSet) {
+	fs.DurationVar(&t.Connect, "connect-timeout", t.Connect, "limit on obtaining a connection, TLS handshake included (0 = only -timeout)")
+	fs.DurationVar(&t.Header, "header-timeout", t.Header, "limit from sending the request to the first response byte (0 = only -timeout)")
+	fs.DurationVar(&t.Body, "body-timeout", t.Body, "limit on reading the response body (0 = only -timeout)")
+}
+
+func (t *Timeouts) phased() bool {
+	return t.Connect > 0 || t.Header > 0 || t.Body > 0
+}
+
+// Error classes of timeouts, named after the phase the request was in
+// whichever limit fired. A timeout whose phase the transport did not report
+// stays errclass.Timeout.
+const (
+	classConnectTimeout = "timeout_connect"
+	classHeaderTimeout  = "timeout_header"
+	classBodyTimeout    = "timeout_body"
+)
+
+type phase int32
+
+const (
+	phaseUnknown phase = iota // the transport has not reported progress
+	phaseConnect
+	phaseHeader
+	phaseBody
+)
+
+// phaseTimeout is the cancellation cause when a phase limit fires.
+type phaseTimeout struct{ phase phase }
+
+func (e *phaseTimeout) Error() string { return "httpload: " + timeoutClass(e.phase) + " exceeded" }
+
+var phaseTimeouts = [...]*phaseTimeout{{phaseUnknown}, {phaseConnect}, {phaseHeader}, {phaseBody}}
+
+func timeoutClass(p phase) string {
+	switch p {
+	case phaseConnect:
+		return classConnectTimeout
+	case phaseHeader:
+		return classHeaderTimeout
+	case phaseBody:
+		return classBodyTimeout
+	}
+	return errclass.Timeout
+}
+
+// attempt is one request in flight under Timeouts. The transport reports
+// its progress through httptrace, which the HTTP/1.1 and HTTP/2 transports
+// support; without it only the Request and Body limits apply.
+type attempt struct {
+	limits *Timeouts
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   context.CancelFunc // releases the Request deadline
+	timer  *time.Timer        // the current phase's limit
+	phase  atomic.Int32
+}
+
+// start prepares req to be sent under t. The caller must call finish once
+// the response body has been read.
+func (t *Timeouts) start(req *http.Request) (*http.Request, *attempt) {
+	a := &attempt{limits: t}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	a.cancel = cancel
+	if t.Request > 0 {
+		ctx, a.stop = context.WithTimeout(ctx, t.Request)
+	}
+	if t.phased() {
+		a.timer = time.AfterFunc(time.Hour, a.expire)
+		a.timer.Stop()
+	}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn:              func(string) { a.enter(phaseConnect) },
+		GotConn:              func(httptrace.GotConnInfo) { a.enter(phaseHeader) },
+		GotFirstResponseByte: func() { a.enter(phaseBody) },
+	})
+	a.ctx = ctx
+	return req.WithContext(ctx), a
+}
+
+// enter moves the attempt to phase p and arms that phase's limit.
+func (a *attempt) enter(p phase) {
+	if phase(a.phase.Swap(int32(p))) == p || a.timer == nil {
+		return
+	}
+	var limit time.Duration
+	switch p {
+	case phaseConnect:
+		limit = a.limits.Connect
+	case phaseHeader:
+		limit = a.limits.Header
+	case phaseBody:
+		limit = a.limits.Body
+	}
+	if limit > 0 {
+		a.timer.Reset(limit)
+	} else {
+		a.timer.Stop()
+	}
+}
+
+func (a *attempt) expire() {
+	a.cancel(phaseTimeouts[a.phase.Load()])
+}
+
+// responded marks the response headers as received, for transports that
+// do not trace GotFirstResponseByte.
+func (a *attempt) responded() {
+	a.enter(phaseBody)
+}
+
+// class returns the error class of err, naming the phase of a timeout.
+func (a *attempt) class(err error) string {
+	var pt *phaseTimeout
+	if errors.As(context.Cause(a.ctx), &pt) {
+		return timeoutClass(pt.phase)
+	}
+	class := errclass.Of(err)
+	if class == errclass.Timeout {
+		return timeoutClass(phase(a.phase.Load()))
+	}
+	return class
+}
+
+// finish releases the attempt's timers.
+func (a *attempt) finish() {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	if a.stop != nil {
+		a.stop()
+	}
+	a.cancel(nil)
+}
@@ -0,0 +1,67 @@
+package httpload
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutPhases(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-header":
+			<-release
+		case "/slow-body":
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			<-release
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	slowDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	plain := &http.Client{Transport: &http.Transport{}}
+	slow := &http.Client{Transport: &http.Transport{DialContext: slowDial}}
+	const limit = 50 * time.Millisecond
+
+	for _, tc := range []struct {
+		name     string
+		client   *http.Client
+		path     string
+		timeouts Timeouts
+		want     string
+	}{
+		{"connect", slow, "/", Timeouts{Connect: limit}, classConnectTimeout},
+		{"header", plain, "/slow-header", Timeouts{Header: limit}, classHeaderTimeout},
+		{"body", plain, "/slow-body", Timeouts{Body: limit}, classBodyTimeout},
+		// The overall limit is still reported by the phase it cut short
+		{"request in connect", slow, "/", Timeouts{Request: limit}, classConnectTimeout},
+		{"request in header", plain, "/slow-header", Timeouts{Request: limit}, classHeaderTimeout},
+		{"request in body", plain, "/slow-body", Timeouts{Request: limit}, classBodyTimeout},
+		{"no timeout", plain, "/", Timeouts{Connect: limit, Header: limit, Body: limit}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &run{cfg: Config{Timeouts: tc.timeouts}}
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tc.path, nil)
+			start := time.Now()
+			if got := r.do(tc.client, req); got != tc.want {
+				t.Fatalf("class = %q, want %q", got, tc.want)
+			}
+			if d := time.Since(start); d > 500*time.Millisecond {
+				t.Fatalf("took %v", d)
+			}
+		})
+	}
+}
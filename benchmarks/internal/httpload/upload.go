@@ -0,0 +1,163 @@
+package httpload
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strconv"
+	"strings"
+
+	"benchmarks/internal/payload"
+)
+
+// Upload turns every request into a multipart/form-data upload of one
+// file, to load the server's upload parsing. File contents are generated
+// as the transport reads them, so even large files cost the client no
+// memory per request.
+type Upload struct {
+	// Size is the mean file size in bytes; zero disables uploads.
+	Size ByteSize
+	// Dist is the payload distribution file sizes are drawn from.
+	Dist string
+	// Field is the form field carrying the file.
+	Field string
+}
+
+// Register adds the upload flags to fs.
+func (u *Upload) Register(fs *flag.FlagSet) {
+	if u.Dist == "" {
+		u.Dist = payload.Fixed
+	}
+	if u.Field == "" {
+		u.Field = "file"
+	}
+	fs.Var(&u.Size, "upload", "send each request as a multipart/form-data upload of a file this size, e.g. 64KiB or 10MB")
+	fs.StringVar(&u.Dist, "upload-dist", u.Dist, "upload size distribution: fixed, uniform (1 to 2x -upload) or lognormal (long tail, capped at 16x -upload)")
+	fs.StringVar(&u.Field, "upload-field", u.Field, "form field name of the uploaded file")
+}
+
+// Enabled reports whether requests are uploads.
+func (u *Upload) Enabled() bool {
+	return u.Size > 0
+}
+
+func (u *Upload) validate() error {
+	if u.Dist == "" {
+		u.Dist = payload.Fixed
+	}
+	if u.Field == "" {
+		u.Field = "file"
+	}
+	_, err := payload.NewSizer(u.Dist, int(u.Size), nil)
+	return err
+}
+
+// uploadRate returns the file bytes res ingested per second, in MB.
+func (res *Result) uploadRate() float64 {
+	return float64(res.Uploaded) / res.Elapsed.Seconds() / 1e6
+}
+
+// ByteSize is a flag.Value for a byte count with an optional decimal (KB,
+// MB, GB) or binary (KiB, MiB, GiB) suffix.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+func (b *ByteSize) String() string {
+	if b == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *ByteSize) Set(s string) error {
+	num, unit := strings.TrimSpace(s), int64(1)
+	for _, u := range byteUnits {
+		if trimmed, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, unit = strings.TrimSpace(trimmed), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = ByteSize(n * unit)
+	return nil
+}
+
+// uploadBody is a request body: the multipart headers, a file of
+// the pattern repeated and the closing boundary.
+type uploadBody struct {
+	head, tail []byte
+	fill       []byte
+	pos        int   // read position in head or tail
+	left       int64 // file bytes still to send
+	inTail     bool
+}
+
+// fillSize is the slab of pattern bytes file contents are copied from.
+const fillSize = 32 << 10
+
+// newUploadBody returns a body for field and the request's Content-Type.
+func newUploadBody(field string) (*uploadBody, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.CreateFormFile(field, "upload.bin")
+	head := bytes.Clone(buf.Bytes())
+	buf.Reset()
+	mw.Close()
+	// The pattern holds no CR or '-', so the file cannot contain the
+	// boundary
+	fill := make([]byte, fillSize)
+	payload.Fill(fill)
+	return &uploadBody{head: head, tail: bytes.Clone(buf.Bytes()), fill: fill}, mw.FormDataContentType()
+}
+
+// reset rewinds the body for a file of size bytes and returns the body's
+// total length.
+func (b *uploadBody) reset(size int64) int64 {
+	b.pos, b.left, b.inTail = 0, size, false
+	return int64(len(b.head)+len(b.tail)) + size
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	switch {
+	case !b.inTail && b.pos < len(b.head):
+		n := copy(p, b.head[b.pos:])
+		b.pos += n
+		return n, nil
+	case b.left > 0:
+		n := copy(p[:min(int64(len(p)), b.left)], b.fill)
+		b.left -= int64(n)
+		return n, nil
+	case !b.inTail:
+		b.inTail, b.pos = true, 0
+	}
+	if b.pos == len(b.tail) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.tail[b.pos:])
+	b.pos += n
+	return n, nil
+}
+
+func (*uploadBody) Close() error { return nil }
+
+// clone returns a fresh copy rewound for a file of size bytes and its
+// length. Every send gets one, as does the transport when it replays the
+// body after a connection failure, since an earlier send's body may still
+// be in use.
+func (b *uploadBody) clone(size int64) (*uploadBody, int64) {
+	c := &uploadBody{head: b.head, tail: b.tail, fill: b.fill}
+	return c, c.reset(size)
+}
@@ -0,0 +1,96 @@
+package httpload
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"benchmarks/internal/payload"
+)
+
+func TestByteSize(t *testing.T) {
+	for in, want := range map[string]ByteSize{"512": 512, "64KiB": 64 << 10, "10MB": 10e6, "1 GiB": 1 << 30, "7B": 7} {
+		var b ByteSize
+		if err := b.Set(in); err != nil || b != want {
+			t.Errorf("Set(%q) = %d, %v; want %d", in, b, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-1", "1.5MB", "3TB"} {
+		var b ByteSize
+		if err := b.Set(in); err == nil {
+			t.Errorf("Set(%q) accepted", in)
+		}
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var files, fileBytes atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		part, err := mr.NextPart()
+		if err != nil || part.FormName() != "doc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n, err := io.Copy(io.Discard, part)
+		if _, next := mr.NextPart(); err != nil || next != io.EOF {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		files.Add(1)
+		fileBytes.Add(n)
+	}))
+	defer srv.Close()
+
+	cfg := Config{
+		Client:      srv.Client(),
+		URL:         srv.URL,
+		Concurrency: 2,
+		Duration:    100 * time.Millisecond,
+		Upload:      Upload{Size: 100 << 10, Dist: payload.Uniform, Field: "doc"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests == 0 || res.Errors != 0 {
+		t.Fatalf("%d requests, %d errors (%s)", res.Requests, res.Errors, res.ErrorClasses)
+	}
+	// The server may have parsed a request the run ended before counting
+	if res.Requests > files.Load() || res.Uploaded > fileBytes.Load() || res.Uploaded < res.Requests {
+		t.Errorf("counted %d files of %d bytes, server saw %d of %d", res.Requests, res.Uploaded, files.Load(), fileBytes.Load())
+	}
+	if r := cfg.Reports("bench_http", []Result{res})[0]; r.Config["upload_mb_per_sec"] == "" || r.Config["method"] != http.MethodPost {
+		t.Errorf("config %v", r.Config)
+	}
+
+	cfg.Body = []byte("x")
+	if err := cfg.Validate(); err == nil {
+		t.Error("-upload with -body validated")
+	}
+}
+
+func TestUploadBodyPerSend(t *testing.T) {
+	cfg := Config{URL: "http://localhost/", Upload: Upload{Size: 64 << 10, Dist: payload.Uniform, Field: "doc"}}
+	r := newRequest(&cfg, cfg.URL, nil, rand.New(rand.NewPCG(1, 2)))
+	first := r.next()
+	io.ReadFull(first.Body, make([]byte, first.ContentLength/2))
+	r.next()
+	// The first body must carry on where it was, to its full length
+	rest, _ := io.ReadAll(first.Body)
+	if n := first.ContentLength - first.ContentLength/2; int64(len(rest)) != n {
+		t.Errorf("first body had %d bytes left, want %d", len(rest), n)
+	}
+	replay, _ := first.GetBody()
+	if all, _ := io.ReadAll(replay); int64(len(all)) != first.ContentLength {
+		t.Errorf("replayed %d bytes of a %d-byte body", len(all), first.ContentLength)
+	}
+}
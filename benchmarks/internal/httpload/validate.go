@@ -0,0 +1,113 @@
+package httpload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// classMismatch is the error class of a 2xx response whose body failed
+// -validate.
+const classMismatch = "mismatch"
+
+// BodyCheck is a flag.Value describing what every response body must be,
+// so that a server returning truncated or corrupted data does not pass as
+// a fast one:
+//
+//	sha256:<hex>     the body hashes to this digest
+//	equals:<text>    the body is exactly this text
+//	contains:<text>  the body includes this text
+//	file:<path>      the body equals the file's contents
+//	len:<n>          the body is n bytes long
+//
+// The zero value checks nothing.
+type BodyCheck struct {
+	spec string
+	kind string
+	want []byte
+	size int64
+}
+
+func (c *BodyCheck) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.spec
+}
+
+func (c *BodyCheck) Set(s string) error {
+	kind, arg, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("-validate %q is not kind:value", s)
+	}
+	out := BodyCheck{spec: s, kind: kind}
+	switch kind {
+	case "sha256":
+		sum, err := hex.DecodeString(arg)
+		if err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("-validate: %q is not a SHA-256 digest", arg)
+		}
+		out.want = sum
+	case "equals", "contains":
+		out.want = []byte(arg)
+	case "file":
+		b, err := os.ReadFile(arg)
+		if err != nil {
+			return err
+		}
+		out.kind, out.want = "equals", b
+	case "len":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("-validate: invalid length %q", arg)
+		}
+		out.size = n
+	default:
+		return fmt.Errorf("-validate: unknown check %q (want sha256, equals, contains, file or len)", kind)
+	}
+	*c = out
+	return nil
+}
+
+// Enabled reports whether c checks anything.
+func (c *BodyCheck) Enabled() bool {
+	return c.kind != ""
+}
+
+// verify consumes body and reports whether it passed. A read error is
+// returned as is so it is classified like any other transport failure.
+func (c *BodyCheck) verify(body io.Reader) (bool, error) {
+	switch c.kind {
+	case "sha256":
+		h := sha256.New()
+		if _, err := io.Copy(h, body); err != nil {
+			return false, err
+		}
+		return bytes.Equal(h.Sum(nil), c.want), nil
+	case "len":
+		n, err := io.Copy(io.Discard, body)
+		return n == c.size, err
+	case "equals":
+		// One byte more than wanted is enough to spot a longer body
+		b, err := io.ReadAll(io.LimitReader(body, int64(len(c.want))+1))
+		if err != nil {
+			return false, err
+		}
+		_, err = io.Copy(io.Discard, body)
+		return bytes.Equal(b, c.want), err
+	case "contains":
+		b, err := io.ReadAll(io.LimitReader(body, maxExtractBody))
+		if err != nil {
+			return false, err
+		}
+		_, err = io.Copy(io.Discard, body)
+		return bytes.Contains(b, c.want), err
+	}
+	_, err := io.Copy(io.Discard, body)
+	return true, err
+}
@@ -0,0 +1,64 @@
+package httpload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBodyCheckVerify(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello world"))
+	for _, tc := range []struct {
+		spec, body string
+		want       bool
+	}{
+		{"sha256:" + hex.EncodeToString(sum[:]), "hello world", true},
+		{"sha256:" + hex.EncodeToString(sum[:]), "hello worl", false},
+		{"equals:hello world", "hello world", true},
+		{"equals:hello", "hello world", false},
+		{"contains:lo wo", "hello world", true},
+		{"contains:bye", "hello world", false},
+		{"len:11", "hello world", true},
+		{"len:11", "hello", false},
+	} {
+		var c BodyCheck
+		if err := c.Set(tc.spec); err != nil {
+			t.Fatalf("Set(%q): %v", tc.spec, err)
+		}
+		ok, err := c.verify(strings.NewReader(tc.body))
+		if err != nil || ok != tc.want {
+			t.Errorf("%s on %q = %v, %v; want %v", tc.spec, tc.body, ok, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"sha256", "sha256:abc", "len:-1", "md5:00", "file:/nonexistent"} {
+		if err := new(BodyCheck).Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRunCountsMismatches(t *testing.T) {
+	var served atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1)%2 == 0 {
+			w.Write([]byte(`{"ok":tr`)) // truncated
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	cfg := Config{Client: srv.Client(), URL: srv.URL, Concurrency: 1, Duration: 50 * time.Millisecond}
+	if err := cfg.Check.Set(`equals:{"ok":true}`); err != nil {
+		t.Fatal(err)
+	}
+	res := Run(cfg)
+	if res.Requests == 0 || res.ErrorClasses[classMismatch] == 0 || res.ErrorClasses[classMismatch] != res.Errors {
+		t.Errorf("requests %d, errors %v", res.Requests, res.ErrorClasses)
+	}
+}
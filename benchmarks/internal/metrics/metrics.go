@@ -0,0 +1,135 @@
+// Package metrics exposes the client's view of a run on a Prometheus
+// endpoint, so Grafana can chart it next to the server's own metrics
+// during soak tests.
+//
+// A nil *Metrics is valid and records nothing, which lets tools call it
+// unconditionally whether or not -metrics was given.
+package metrics
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the collectors for one tool.
+type Metrics struct {
+	inFlight prometheus.Gauge
+	requests prometheus.Counter
+	errors   *prometheus.CounterVec
+	latency  prometheus.Histogram
+
+	ln  net.Listener
+	srv *http.Server
+}
+
+// New registers the collectors on reg, labelled with the tool name.
+func New(reg prometheus.Registerer, tool string) *Metrics {
+	labels := prometheus.Labels{"tool": tool}
+	m := &Metrics{
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "bench_client_in_flight_requests",
+			Help:        "Requests sent and not yet answered.",
+			ConstLabels: labels,
+		}),
+		requests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "bench_client_requests_total",
+			Help:        "Requests (or streamed messages) that succeeded.",
+			ConstLabels: labels,
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "bench_client_errors_total",
+			Help:        "Failed requests by error class.",
+			ConstLabels: labels,
+		}, []string{"class"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "bench_client_request_duration_seconds",
+			Help:        "Latency of successful requests as seen by the client.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(50e-6, 2, 20), // 50µs to ~26s
+		}),
+	}
+	reg.MustRegister(m.inFlight, m.requests, m.errors, m.latency)
+	return m
+}
+
+// Serve registers fresh collectors and serves them at addr/metrics until
+// Close. The listener is bound before Serve returns so a busy port is
+// reported up front rather than after the run.
+func Serve(addr, tool string) (*Metrics, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	reg := prometheus.NewRegistry()
+	m := New(reg, tool)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	m.ln = ln
+	m.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go m.srv.Serve(ln)
+	return m, nil
+}
+
+// Addr returns the address the endpoint listens on.
+func (m *Metrics) Addr() string {
+	if m == nil || m.ln == nil {
+		return ""
+	}
+	return m.ln.Addr().String()
+}
+
+// Close stops the endpoint.
+func (m *Metrics) Close() error {
+	if m == nil || m.srv == nil {
+		return nil
+	}
+	if err := m.srv.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Begin marks a request as in flight.
+func (m *Metrics) Begin() {
+	if m == nil {
+		return
+	}
+	m.inFlight.Inc()
+}
+
+// End completes a request started with Begin. An empty class means it
+// succeeded after d; anything else is counted as an error of that class.
+func (m *Metrics) End(d time.Duration, class string) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Dec()
+	m.Observe(d, class)
+}
+
+// Observe records a finished request that was not tracked as in flight.
+func (m *Metrics) Observe(d time.Duration, class string) {
+	if m == nil {
+		return
+	}
+	if class != "" {
+		m.errors.WithLabelValues(class).Inc()
+		return
+	}
+	m.requests.Inc()
+	m.latency.Observe(d.Seconds())
+}
+
+// Event counts a success that has no latency of its own, such as a
+// streamed message.
+func (m *Metrics) Event() {
+	if m == nil {
+		return
+	}
+	m.requests.Inc()
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	m, err := Serve("127.0.0.1:0", "bench_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	m.Begin()
+	m.Begin()
+	m.End(2*time.Millisecond, "")
+	m.End(0, "timeout")
+	m.Observe(time.Millisecond, "")
+	m.Event()
+
+	resp, err := http.Get("http://" + m.Addr() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`bench_client_in_flight_requests{tool="bench_test"} 0`,
+		`bench_client_requests_total{tool="bench_test"} 3`,
+		`bench_client_errors_total{class="timeout",tool="bench_test"} 1`,
+		`bench_client_request_duration_seconds_count{tool="bench_test"} 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("scrape missing %q", want)
+		}
+	}
+}
+
+func TestServeBusyPort(t *testing.T) {
+	m, err := Serve("127.0.0.1:0", "bench_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if _, err := Serve(m.Addr(), "bench_test"); err == nil {
+		t.Fatal("second Serve on the same port succeeded")
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.Begin()
+	m.End(time.Millisecond, "")
+	m.Observe(time.Millisecond, "reset")
+	m.Event()
+	if m.Addr() != "" || m.Close() != nil {
+		t.Error("nil Metrics is not inert")
+	}
+}
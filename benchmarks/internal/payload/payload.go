@@ -0,0 +1,129 @@
+// Package payload generates newline-terminated messages of varying size for
+// the echo benchmarks, so the server's handling of partial reads, large
+// buffers and fragmentation is exercised rather than one tiny write.
+//
+// Message bodies come from a fixed printable pattern, which keeps them free
+// of newlines and lets a reader know exactly what the echo should contain.
+package payload
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Size distributions accepted by NewSizer.
+const (
+	Fixed     = "fixed"
+	Uniform   = "uniform"
+	LogNormal = "lognormal"
+)
+
+// tailFactor caps log-normal draws at this multiple of the mean so a rare
+// huge draw cannot dominate a run.
+const tailFactor = 16
+
+const pattern = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// NewRand returns the random source of one worker, numbered stream. A
+// non-zero seed makes every draw repeat from run to run, for bisecting a
+// regression with the same request sequence; zero seeds from the clock.
+func NewRand(seed uint64, stream int) *rand.Rand {
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	return rand.New(rand.NewPCG(seed, uint64(stream)))
+}
+
+// Sizer draws message sizes, newline included.
+type Sizer struct {
+	dist string
+	mean int
+	rng  *rand.Rand
+}
+
+// NewSizer returns a sizer whose draws average mean bytes. Fixed always
+// returns mean, uniform spreads sizes evenly over 1..2*mean-1 and lognormal
+// gives the long tail typical of real traffic.
+func NewSizer(dist string, mean int, rng *rand.Rand) (*Sizer, error) {
+	switch {
+	case dist != Fixed && dist != Uniform && dist != LogNormal:
+		return nil, fmt.Errorf("unknown size distribution %q (want fixed, uniform or lognormal)", dist)
+	case mean < 1:
+		return nil, fmt.Errorf("message size must be at least 1 byte")
+	}
+	return &Sizer{dist: dist, mean: mean, rng: rng}, nil
+}
+
+// Max returns the largest size Next can return.
+func (s *Sizer) Max() int {
+	switch s.dist {
+	case Uniform:
+		return max(2*s.mean-1, 1)
+	case LogNormal:
+		return tailFactor * s.mean
+	}
+	return s.mean
+}
+
+// Next draws a size.
+func (s *Sizer) Next() int {
+	switch s.dist {
+	case Uniform:
+		return 1 + s.rng.IntN(s.Max())
+	case LogNormal:
+		// sigma 1, with mu chosen so the distribution's mean is s.mean
+		const sigma = 1.0
+		mu := math.Log(float64(s.mean)) - sigma*sigma/2
+		n := int(math.Round(math.Exp(mu + sigma*s.rng.NormFloat64())))
+		return min(max(n, 1), s.Max())
+	}
+	return s.mean
+}
+
+// Buffer holds one message at a time, reusing its storage.
+type Buffer struct {
+	buf []byte
+	nl  int // index of the current newline, or -1
+}
+
+// NewBuffer returns a buffer for messages of up to size bytes.
+func NewBuffer(size int) *Buffer {
+	b := &Buffer{buf: make([]byte, size), nl: -1}
+	Fill(b.buf)
+	return b
+}
+
+// Message returns an n-byte message: pattern bytes ending in a newline.
+// It is valid until the next call.
+func (b *Buffer) Message(n int) []byte {
+	if b.nl >= 0 {
+		b.buf[b.nl] = pattern[b.nl%len(pattern)]
+	}
+	b.nl = n - 1
+	b.buf[b.nl] = '\n'
+	return b.buf[:n]
+}
+
+// Valid reports whether p is exactly a message as returned by Message, so
+// an echo that was truncated, corrupted or spliced with another is caught.
+func Valid(p []byte) bool {
+	n := len(p)
+	if n == 0 || p[n-1] != '\n' {
+		return false
+	}
+	for i := 0; i < n-1; i++ {
+		if p[i] != pattern[i%len(pattern)] {
+			return false
+		}
+	}
+	return true
+}
+
+// Fill writes the message pattern into p.
+func Fill(p []byte) {
+	for i := range p {
+		p[i] = pattern[i%len(pattern)]
+	}
+}
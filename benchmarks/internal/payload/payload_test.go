@@ -0,0 +1,78 @@
+package payload
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestSizerMeans(t *testing.T) {
+	for _, dist := range []string{Fixed, Uniform, LogNormal} {
+		s, err := NewSizer(dist, 100, rand.New(rand.NewPCG(1, 2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		const n = 20000
+		var sum int
+		for range n {
+			v := s.Next()
+			if v < 1 || v > s.Max() {
+				t.Fatalf("%s: size %d outside 1..%d", dist, v, s.Max())
+			}
+			sum += v
+		}
+		// The log-normal cap trims a little off its mean
+		if mean := float64(sum) / n; mean < 90 || mean > 110 {
+			t.Errorf("%s: mean size %.1f, want about 100", dist, mean)
+		}
+	}
+	if _, err := NewSizer("bimodal", 10, nil); err == nil {
+		t.Error("unknown distribution accepted")
+	}
+	if _, err := NewSizer(Fixed, 0, nil); err == nil {
+		t.Error("zero size accepted")
+	}
+}
+
+func TestBufferMessage(t *testing.T) {
+	b := NewBuffer(100)
+	for _, n := range []int{100, 1, 7, 100, 50} {
+		msg := b.Message(n)
+		if len(msg) != n || msg[n-1] != '\n' || bytes.Count(msg, []byte{'\n'}) != 1 {
+			t.Fatalf("Message(%d) = %q", n, msg)
+		}
+		want := make([]byte, n-1)
+		Fill(want)
+		if !bytes.Equal(msg[:n-1], want) {
+			t.Errorf("Message(%d) body %q, want %q", n, msg[:n-1], want)
+		}
+		if !Valid(msg) {
+			t.Errorf("Message(%d) is not Valid", n)
+		}
+	}
+}
+
+func TestValidRejects(t *testing.T) {
+	msg := append([]byte(nil), NewBuffer(20).Message(20)...)
+	for name, p := range map[string][]byte{
+		"empty":     nil,
+		"truncated": msg[:19],
+		"corrupted": append(append([]byte(nil), msg[:5]...), append([]byte{'#'}, msg[6:]...)...),
+	} {
+		if Valid(p) {
+			t.Errorf("%s message %q accepted", name, p)
+		}
+	}
+}
+
+func TestNewRandSeed(t *testing.T) {
+	a, b := NewRand(7, 3), NewRand(7, 3)
+	for range 100 {
+		if x, y := a.Uint64(), b.Uint64(); x != y {
+			t.Fatalf("seed 7 drew %d then %d", x, y)
+		}
+	}
+	if NewRand(7, 3).Uint64() == NewRand(7, 4).Uint64() {
+		t.Error("workers 3 and 4 drew the same")
+	}
+}
@@ -0,0 +1,227 @@
+// Package progress prints rolling statistics while a benchmark runs, so a
+// long soak can be watched and aborted early when something is obviously
+// wrong instead of waiting for the final summary.
+//
+// A nil *Meter is valid and records nothing, which lets tools call it
+// unconditionally whether or not -progress was given.
+//
+// A meter can also feed a Timeline, which keeps the statistics of every
+// second of the run for plotting afterwards.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/hdr"
+)
+
+// Meter collects per-interval samples from a fixed set of workers.
+type Meter struct {
+	slots  []slot
+	conns  atomic.Int64
+	dialed atomic.Bool // whether connections are being tracked at all
+
+	// Timeline, when set, receives a bucket for every second of the run.
+	// Progress lines are then printed every whole number of seconds.
+	Timeline *Timeline
+
+	// interval is reused between ticks to avoid a 30KB allocation each
+	// time; pending gathers the ticks since the last progress line
+	interval *hdr.Histogram
+	pending  *hdr.Histogram
+	start    time.Time
+	last     time.Time // last progress line
+	lastTick time.Time
+
+	ticks, printEvery int
+	events, errors    int64 // pending
+}
+
+// slot double-buffers one worker's samples: the worker records into
+// cur while the reporter drains the other buffer outside the lock.
+type slot struct {
+	mu     sync.Mutex
+	h      [2]*hdr.Histogram
+	cur    int
+	events int64
+	errors int64
+	_      [64]byte // keep neighbouring workers off each other's cache line
+}
+
+// New returns a meter for workers numbered 0 to workers-1.
+func New(workers int) *Meter {
+	m := &Meter{slots: make([]slot, workers), interval: hdr.New(), pending: hdr.New()}
+	for i := range m.slots {
+		m.slots[i].h = [2]*hdr.Histogram{hdr.New(), hdr.New()}
+	}
+	return m
+}
+
+// Record adds a successful request and its latency.
+func (m *Meter) Record(worker int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	s := &m.slots[worker]
+	s.mu.Lock()
+	s.h[s.cur].Record(d)
+	s.mu.Unlock()
+}
+
+// Event adds a success that has no latency of its own, such as a streamed
+// message.
+func (m *Meter) Event(worker int) {
+	if m == nil {
+		return
+	}
+	s := &m.slots[worker]
+	s.mu.Lock()
+	s.events++
+	s.mu.Unlock()
+}
+
+// Error adds a failed request.
+func (m *Meter) Error(worker int) {
+	if m == nil {
+		return
+	}
+	s := &m.slots[worker]
+	s.mu.Lock()
+	s.errors++
+	s.mu.Unlock()
+}
+
+// Conn tracks c as open until it is closed.
+func (m *Meter) Conn(c net.Conn) net.Conn {
+	if m == nil {
+		return c
+	}
+	m.dialed.Store(true)
+	m.conns.Add(1)
+	return &conn{Conn: c, m: m}
+}
+
+// DialContext wraps dial so every connection it opens is tracked.
+func (m *Meter) DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if m == nil {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return m.Conn(c), nil
+	}
+}
+
+type conn struct {
+	net.Conn
+	m      *Meter
+	closed atomic.Bool
+}
+
+func (c *conn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.m.conns.Add(-1)
+	}
+	return c.Conn.Close()
+}
+
+// Start prints one line to w every interval until the returned stop
+// function is called, and fills the timeline if there is one. Stop prints
+// nothing further but closes the timeline's last, partial second.
+func (m *Meter) Start(w io.Writer, every time.Duration) (stop func()) {
+	if m == nil || (every <= 0 && m.Timeline == nil) {
+		return func() {}
+	}
+	m.start = time.Now()
+	m.last, m.lastTick = m.start, m.start
+	m.printEvery = 1
+	if every <= 0 {
+		w = nil
+	}
+	if m.Timeline != nil {
+		m.printEvery = max(1, int((every+time.Second/2)/time.Second))
+		every = time.Second
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				m.tick(w, now)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		if m.Timeline != nil {
+			m.tick(nil, time.Now())
+		}
+	}
+}
+
+// tick drains every slot into the timeline and, every printEvery ticks,
+// prints the statistics since the last line to w. A nil w prints nothing.
+func (m *Meter) tick(w io.Writer, now time.Time) {
+	m.interval.Reset()
+	var events, errors int64
+	for i := range m.slots {
+		s := &m.slots[i]
+		s.mu.Lock()
+		old := s.h[s.cur]
+		s.cur ^= 1
+		events += s.events
+		errors += s.errors
+		s.events, s.errors = 0, 0
+		s.mu.Unlock()
+
+		m.interval.Merge(old)
+		old.Reset()
+	}
+	m.Timeline.add(m.lastTick, now, m.interval, events, errors)
+	m.lastTick = now
+	if w == nil {
+		return
+	}
+
+	m.pending.Merge(m.interval)
+	m.events += events
+	m.errors += errors
+	if m.ticks++; m.ticks < m.printEvery {
+		return
+	}
+	m.ticks = 0
+	events, errors = m.events, m.errors
+	m.events, m.errors = 0, 0
+	defer m.pending.Reset()
+
+	elapsed := now.Sub(m.last)
+	m.last = now
+	rate := float64(m.pending.Count()+events) / elapsed.Seconds()
+	p99 := "-"
+	if m.pending.Count() > 0 {
+		p99 = m.pending.ValueAtQuantile(0.99).String()
+	}
+	line := fmt.Sprintf("[%7s] %10.1f req/s  p99=%-10s errors=%d",
+		now.Sub(m.start).Round(100*time.Millisecond), rate, p99, errors)
+	if m.dialed.Load() {
+		line += fmt.Sprintf("  conns=%d", m.conns.Load())
+	}
+	fmt.Fprintln(w, line)
+}
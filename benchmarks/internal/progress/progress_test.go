@@ -0,0 +1,92 @@
+package progress
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTick(t *testing.T) {
+	m := New(2)
+	m.start = time.Now()
+	m.last = m.start
+	for i := 0; i < 10; i++ {
+		m.Record(i%2, time.Millisecond)
+	}
+	m.Event(0)
+	m.Error(1)
+	client, server := net.Pipe()
+	defer server.Close()
+	c := m.Conn(client)
+
+	var buf bytes.Buffer
+	m.tick(&buf, m.start.Add(time.Second))
+	line := buf.String()
+	for _, want := range []string{"11.0 req/s", "p99=1ms", "errors=1", "conns=1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("first interval %q missing %q", line, want)
+		}
+	}
+
+	// The next interval starts empty
+	c.Close()
+	c.Close()
+	buf.Reset()
+	m.tick(&buf, m.start.Add(2*time.Second))
+	line = buf.String()
+	for _, want := range []string{"0.0 req/s", "errors=0", "conns=0"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("second interval %q missing %q", line, want)
+		}
+	}
+}
+
+func TestNilMeter(t *testing.T) {
+	var m *Meter
+	m.Record(0, time.Millisecond)
+	m.Error(0)
+	m.Event(0)
+	m.Start(nil, time.Second)()
+	client, server := net.Pipe()
+	defer server.Close()
+	if m.Conn(client) != client {
+		t.Error("nil meter wrapped the connection")
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	tl := &Timeline{}
+	m := New(1)
+	m.Timeline = tl
+	m.start = time.Now()
+	m.lastTick = m.start
+	m.Record(0, time.Millisecond)
+	m.Record(0, 3*time.Millisecond)
+	m.Error(0)
+	m.tick(nil, m.start.Add(time.Second))
+	// A quiet second is a stall worth seeing; the empty tail at stop is not
+	m.tick(nil, m.start.Add(2*time.Second))
+	m.tick(nil, m.start.Add(2*time.Second+time.Millisecond))
+
+	if len(tl.Buckets) != 2 {
+		t.Fatalf("got %d buckets, want 2: %+v", len(tl.Buckets), tl.Buckets)
+	}
+	b := tl.Buckets[0]
+	if b.Requests != 2 || b.Errors != 1 || b.RPS() != 2 || b.Latency.Max != 3*time.Millisecond {
+		t.Errorf("first bucket %+v", b)
+	}
+	if b := tl.Buckets[1]; b.Start != time.Second || b.Requests != 0 {
+		t.Errorf("second bucket %+v", b)
+	}
+
+	var buf bytes.Buffer
+	if err := tl.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "0.0,1.000,2,1,2.0,") {
+		t.Errorf("CSV:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,94 @@
+package progress
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"benchmarks/internal/hdr"
+)
+
+// Timeline keeps the statistics of each second of a run, so GC pauses and
+// periodic stalls in the server show up as the seconds they happened in
+// instead of being smeared into one aggregate. Meters that run one after
+// another, such as the levels of a sweep, can share a timeline; its clock
+// starts with the first of them.
+type Timeline struct {
+	start   time.Time
+	Buckets []Bucket
+}
+
+// Bucket is one second of a timeline, or less for the last second of a
+// meter.
+type Bucket struct {
+	Start    time.Duration // since the timeline started
+	Length   time.Duration
+	Requests int64 // successes, latency-less events included
+	Errors   int64
+	Latency  hdr.Summary
+}
+
+// RPS returns the successes per second in b.
+func (b Bucket) RPS() float64 {
+	return float64(b.Requests) / b.Length.Seconds()
+}
+
+func (t *Timeline) add(from, to time.Time, h *hdr.Histogram, events, errors int64) {
+	if t == nil {
+		return
+	}
+	if t.start.IsZero() {
+		t.start = from
+	}
+	b := Bucket{
+		Start:    from.Sub(t.start),
+		Length:   to.Sub(from),
+		Requests: h.Count() + events,
+		Errors:   errors,
+		Latency:  h.Summary(),
+	}
+	if b.Requests+b.Errors == 0 && b.Length < time.Second/2 {
+		// The empty tail between the last tick and stop
+		return
+	}
+	t.Buckets = append(t.Buckets, b)
+}
+
+var timelineHeader = []string{"start_s", "length_s", "requests", "errors", "rps", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "max_ms"}
+
+// WriteCSV writes one row per bucket, latencies in milliseconds, for
+// plotting as a heatmap or line chart.
+func (t *Timeline) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(timelineHeader)
+	f := func(v float64, prec int) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	ms := func(d time.Duration) string { return f(float64(d)/float64(time.Millisecond), 3) }
+	for _, b := range t.Buckets {
+		cw.Write([]string{
+			f(b.Start.Seconds(), 1),
+			f(b.Length.Seconds(), 3),
+			strconv.FormatInt(b.Requests, 10),
+			strconv.FormatInt(b.Errors, 10),
+			f(b.RPS(), 1),
+			ms(b.Latency.P50),
+			ms(b.Latency.P90),
+			ms(b.Latency.P99),
+			ms(b.Latency.P999),
+			ms(b.Latency.Max),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable prints the buckets as an aligned table.
+func (t *Timeline) WriteTable(w io.Writer) {
+	fmt.Fprintln(w, "\nTimeline (per second):")
+	fmt.Fprintf(w, "%7s %11s %8s %10s %10s %10s %10s %10s\n", "start", "req/s", "errors", "p50", "p90", "p99", "p99.9", "max")
+	for _, b := range t.Buckets {
+		fmt.Fprintf(w, "%6.1fs %11.1f %8d %10v %10v %10v %10v %10v\n", b.Start.Seconds(), b.RPS(), b.Errors,
+			b.Latency.P50, b.Latency.P90, b.Latency.P99, b.Latency.P999, b.Latency.Max)
+	}
+}
@@ -0,0 +1,197 @@
+// Package proxy tunnels the tools' connections through a proxy, as when
+// FasterAPI sits behind a corporate proxy or an Envoy sidecar. An http://
+// proxy is asked to CONNECT to the target, so every protocol the tools
+// speak, TLS and h2c included, passes through unchanged; a socks5:// proxy
+// is asked the same through SOCKS5. The time the proxy takes to open each
+// tunnel is recorded apart from the requests that follow.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"benchmarks/internal/connstat"
+	"benchmarks/internal/hdr"
+	"benchmarks/internal/report"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// Dialer opens connections through one proxy. It is safe for concurrent
+// use. A nil *Dialer dials directly.
+type Dialer struct {
+	url *url.URL
+
+	// Tunnel records how long each tunnel took to open once the proxy
+	// had accepted the connection: the CONNECT exchange or the SOCKS5
+	// handshake.
+	Tunnel *hdr.Histogram
+}
+
+// Parse returns a dialer for a proxy URL: http://[user:pass@]host:port or
+// socks5://[user:pass@]host:port. The port defaults to 8080 and 1080.
+func Parse(raw string) (*Dialer, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	var port string
+	switch u.Scheme {
+	case "http":
+		port = "8080"
+	case "socks5", "socks5h":
+		port = "1080"
+	default:
+		return nil, fmt.Errorf("proxy %q: scheme must be http or socks5", raw)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy %q: no host", raw)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return &Dialer{url: u, Tunnel: hdr.New()}, nil
+}
+
+// String returns the proxy URL with any password masked.
+func (d *Dialer) String() string {
+	if d == nil {
+		return ""
+	}
+	return d.url.Redacted()
+}
+
+// DialContext wraps dial, which reaches the proxy, so connections to
+// addr tunnel through it. Only TCP can be tunnelled.
+func (d *Dialer) DialContext(dial connstat.DialFunc) connstat.DialFunc {
+	if d == nil {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return nil, fmt.Errorf("proxy: cannot tunnel %s", network)
+		}
+		if d.url.Scheme == "http" {
+			return d.connect(ctx, dial, network, addr)
+		}
+		return d.socks(ctx, dial, network, addr)
+	}
+}
+
+// connect opens an HTTP CONNECT tunnel to addr.
+func (d *Dialer) connect(ctx context.Context, dial connstat.DialFunc, network, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, network, d.url.Host)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.url.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy: CONNECT %s: %s", addr, resp.Status)
+	}
+	d.Tunnel.Record(time.Since(start))
+	if br.Buffered() > 0 {
+		// The target spoke first and the proxy passed it on already
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// socks opens a SOCKS5 tunnel to addr. The proxy resolves host names.
+func (d *Dialer) socks(ctx context.Context, dial connstat.DialFunc, network, addr string) (net.Conn, error) {
+	var auth *xproxy.Auth
+	if u := d.url.User; u != nil {
+		pass, _ := u.Password()
+		auth = &xproxy.Auth{User: u.Username(), Password: pass}
+	}
+	fwd := &forward{dial: dial}
+	sd, err := xproxy.SOCKS5("tcp", d.url.Host, auth, fwd)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	conn, err := sd.(xproxy.ContextDialer).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d.Tunnel.Record(time.Since(start) - fwd.took)
+	return conn, nil
+}
+
+// forward reaches a SOCKS5 proxy and times the connect, so the handshake
+// can be told apart from it.
+type forward struct {
+	dial connstat.DialFunc
+	took time.Duration
+}
+
+func (f *forward) Dial(network, addr string) (net.Conn, error) {
+	return f.DialContext(context.Background(), network, addr)
+}
+
+func (f *forward) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := f.dial(ctx, network, addr)
+	f.took = time.Since(start)
+	return conn, err
+}
+
+// bufferedConn reads what the CONNECT response's reader buffered first.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Annotate prints the tunnel setup times to w and records the proxy and
+// their percentiles in the config of every result.
+func (d *Dialer) Annotate(w io.Writer, results []report.Result) {
+	if d == nil {
+		return
+	}
+	sum := d.Tunnel.Summary()
+	fmt.Fprintf(w, "\nProxy %s: %d tunnels, setup p50=%v p99=%v max=%v\n", d, d.Tunnel.Count(), sum.P50, sum.P99, sum.Max)
+	for _, r := range results {
+		r.Config["proxy"] = d.String()
+		r.Config["proxy_tunnels"] = fmt.Sprint(d.Tunnel.Count())
+		r.Config["proxy_tunnel_p50"] = sum.P50.String()
+		r.Config["proxy_tunnel_p99"] = sum.P99.String()
+	}
+}
@@ -0,0 +1,307 @@
+// Package rawlog writes one record per request: when it started, how long
+// it took, which worker sent it and whether it succeeded. Histograms keep
+// only the distribution; the raw samples let external tools decompose a
+// tail or plot latency over time after the run.
+//
+// The format follows the file name. ".csv" writes CSV and ".csv.gz" gzipped
+// CSV, with the columns start_unix_ns, latency_ns, worker and ok. Anything
+// else gets the compact binary format:
+//
+//	"BENCHRAW" 0x01              magic and version
+//	varint     base              Unix nanoseconds
+//	then per request:
+//	varint     start delta       nanoseconds after the previous record's
+//	                             start (the base for the first); records
+//	                             from different workers interleave, so it
+//	                             may be negative
+//	uvarint    latency           nanoseconds
+//	uvarint    worker<<1 | fail  fail is 1 for a failed request
+//
+// Varints are those of encoding/binary. Reader decodes all three formats.
+//
+// A nil *Writer and a nil *Buffer are valid and record nothing.
+package rawlog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+const magic = "BENCHRAW\x01"
+
+// bufferSize is how many records a worker holds before taking the lock.
+const bufferSize = 4096
+
+// Sample is one request.
+type Sample struct {
+	Start   time.Time
+	Latency time.Duration
+	Worker  int
+	OK      bool
+}
+
+// record is a Sample as buffered by a worker.
+type record struct {
+	start   int64 // Unix nanoseconds
+	latency int64
+	worker  int
+	ok      bool
+}
+
+// Writer writes samples to a file. It is safe for concurrent use through
+// the Buffers it hands out.
+type Writer struct {
+	name string
+	f    *os.File
+	gz   *gzip.Writer
+	bw   *bufio.Writer
+	csv  bool
+
+	mu    sync.Mutex
+	last  int64 // start of the previous binary record
+	count int64
+	err   error
+	tmp   []byte
+}
+
+// Create creates the file at path and writes its header.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{name: path, f: f}
+	var out io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	w.bw = bufio.NewWriterSize(out, 64*1024)
+	w.csv = strings.HasSuffix(strings.TrimSuffix(path, ".gz"), ".csv")
+	if w.csv {
+		w.bw.WriteString("start_unix_ns,latency_ns,worker,ok\n")
+	} else {
+		w.last = time.Now().UnixNano()
+		w.bw.WriteString(magic)
+		w.bw.Write(binary.AppendVarint(nil, w.last))
+	}
+	return w, nil
+}
+
+// Count returns how many samples have been written so far.
+func (w *Writer) Count() int64 {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Worker returns a buffer for worker id. Each worker must use its own and
+// Flush it when done.
+func (w *Writer) Worker(id int) *Buffer {
+	if w == nil {
+		return nil
+	}
+	return &Buffer{w: w, worker: id, records: make([]record, 0, bufferSize)}
+}
+
+func (w *Writer) write(records []record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	for _, r := range records {
+		b := w.tmp[:0]
+		if w.csv {
+			b = strconv.AppendInt(b, r.start, 10)
+			b = append(b, ',')
+			b = strconv.AppendInt(b, r.latency, 10)
+			b = append(b, ',')
+			b = strconv.AppendInt(b, int64(r.worker), 10)
+			b = append(b, ',')
+			b = strconv.AppendBool(b, r.ok)
+			b = append(b, '\n')
+		} else {
+			tag := uint64(r.worker) << 1
+			if !r.ok {
+				tag |= 1
+			}
+			b = binary.AppendVarint(b, r.start-w.last)
+			b = binary.AppendUvarint(b, uint64(r.latency))
+			b = binary.AppendUvarint(b, tag)
+			w.last = r.start
+		}
+		w.tmp = b
+		if _, err := w.bw.Write(b); err != nil {
+			w.err = err
+			return
+		}
+		w.count++
+	}
+}
+
+// Close flushes and closes the file. Every Buffer must have been flushed.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	if ferr := w.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if w.gz != nil {
+		if gerr := w.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Annotate reports where the samples went on info and records the path in
+// the config of every result.
+func (w *Writer) Annotate(info io.Writer, results []report.Result) {
+	if w == nil {
+		return
+	}
+	fmt.Fprintf(info, "\nRaw samples: %d written to %s\n", w.Count(), w.name)
+	for _, r := range results {
+		r.Config["raw_out"] = w.name
+	}
+}
+
+// Buffer batches one worker's samples so the hot path takes the writer's
+// lock once per few thousand requests.
+type Buffer struct {
+	w       *Writer
+	worker  int
+	records []record
+}
+
+// Record adds a request that started at start and took d.
+func (b *Buffer) Record(start time.Time, d time.Duration, ok bool) {
+	if b == nil {
+		return
+	}
+	b.records = append(b.records, record{start: start.UnixNano(), latency: int64(d), worker: b.worker, ok: ok})
+	if len(b.records) == cap(b.records) {
+		b.Flush()
+	}
+}
+
+// Flush hands the buffered samples to the writer.
+func (b *Buffer) Flush() {
+	if b == nil || len(b.records) == 0 {
+		return
+	}
+	b.w.write(b.records)
+	b.records = b.records[:0]
+}
+
+// Reader decodes a file written by Writer in any of its formats.
+type Reader struct {
+	br   *bufio.Reader
+	csv  *csv.Reader
+	last int64
+}
+
+// NewReader reads the header from r, telling the formats apart by their
+// first bytes.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(magic))
+	switch {
+	case bytes.Equal(head, []byte(magic)):
+		br.Discard(len(magic))
+		base, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("rawlog: header: %w", err)
+		}
+		return &Reader{br: br, last: base}, nil
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return newCSVReader(gz)
+	}
+	return newCSVReader(br)
+}
+
+func newCSVReader(r io.Reader) (*Reader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+	cr.ReuseRecord = true
+	if _, err := cr.Read(); err != nil {
+		return nil, fmt.Errorf("rawlog: header: %w", err)
+	}
+	return &Reader{csv: cr}, nil
+}
+
+// Next returns the next sample, or io.EOF after the last.
+func (r *Reader) Next() (Sample, error) {
+	if r.csv != nil {
+		return r.nextCSV()
+	}
+	delta, err := binary.ReadVarint(r.br)
+	if err != nil {
+		return Sample{}, err // io.EOF at a record boundary
+	}
+	latency, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return Sample{}, truncated(err)
+	}
+	tag, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return Sample{}, truncated(err)
+	}
+	r.last += delta
+	return Sample{
+		Start:   time.Unix(0, r.last),
+		Latency: time.Duration(latency),
+		Worker:  int(tag >> 1),
+		OK:      tag&1 == 0,
+	}, nil
+}
+
+func (r *Reader) nextCSV() (Sample, error) {
+	rec, err := r.csv.Read()
+	if err != nil {
+		return Sample{}, err
+	}
+	start, err1 := strconv.ParseInt(rec[0], 10, 64)
+	latency, err2 := strconv.ParseInt(rec[1], 10, 64)
+	worker, err3 := strconv.Atoi(rec[2])
+	ok, err4 := strconv.ParseBool(rec[3])
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return Sample{}, fmt.Errorf("rawlog: %w", err)
+	}
+	return Sample{Start: time.Unix(0, start), Latency: time.Duration(latency), Worker: worker, OK: ok}, nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
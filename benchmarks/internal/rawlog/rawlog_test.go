@@ -0,0 +1,126 @@
+package rawlog
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	for _, name := range []string{"samples.bin", "samples.csv", "samples.csv.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			w, err := Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Two workers whose records interleave out of time order
+			var wg sync.WaitGroup
+			for id := range 2 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b := w.Worker(id)
+					defer b.Flush()
+					for i := range bufferSize + 10 {
+						start := base.Add(time.Duration(i*2+id) * time.Microsecond)
+						b.Record(start, time.Duration(i+1)*time.Microsecond, i%7 != 0)
+					}
+				}()
+			}
+			wg.Wait()
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := w.Count(), int64(2*(bufferSize+10)); got != want {
+				t.Fatalf("Count = %d, want %d", got, want)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			r, err := NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := [2]int{}
+			for {
+				s, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				i := next[s.Worker]
+				next[s.Worker]++
+				want := Sample{
+					Start:   base.Add(time.Duration(i*2+s.Worker) * time.Microsecond),
+					Latency: time.Duration(i+1) * time.Microsecond,
+					Worker:  s.Worker,
+					OK:      i%7 != 0,
+				}
+				if !s.Start.Equal(want.Start) || s.Latency != want.Latency || s.OK != want.OK {
+					t.Fatalf("worker %d record %d = %+v, want %+v", s.Worker, i, s, want)
+				}
+			}
+			if next != [2]int{bufferSize + 10, bufferSize + 10} {
+				t.Fatalf("read %v records per worker", next)
+			}
+		})
+	}
+}
+
+func TestBinaryIsCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.bin")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := w.Worker(3)
+	start := time.Now()
+	for i := range 1000 {
+		b.Record(start.Add(time.Duration(i)*10*time.Microsecond), 500*time.Microsecond, true)
+	}
+	b.Flush()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if per := float64(fi.Size()) / 1000; per > 8 {
+		t.Fatalf("%.1f bytes per record", per)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	buf.Write([]byte{0x02, 0x04, 0x80}) // base, delta, then a cut-off varint
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var w *Writer
+	b := w.Worker(0)
+	b.Record(time.Now(), time.Millisecond, true)
+	b.Flush()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
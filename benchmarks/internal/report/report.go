@@ -0,0 +1,311 @@
+// Package report renders benchmark results as human-readable text or as
+// JSON/CSV for CI dashboards and regression tracking.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/hdr"
+)
+
+// Format selects how results are written.
+type Format string
+
+const (
+	Text Format = "text"
+	JSON Format = "json"
+	CSV  Format = "csv"
+)
+
+// ParseFormat validates a -format flag value.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case Text, JSON, CSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (want text, json or csv)", s)
+}
+
+// Info returns where banners and progress messages should go: stdout for
+// text output, stderr otherwise so that stdout stays machine-readable.
+func (f Format) Info() io.Writer {
+	if f == Text {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// Result is one benchmark run.
+type Result struct {
+	Tool        string            `json:"tool"`
+	Label       string            `json:"label,omitempty"`
+	Target      string            `json:"target"`
+	Concurrency int               `json:"concurrency"`
+	Elapsed     time.Duration     `json:"elapsed_ns"`
+	Requests    int64             `json:"requests"`
+	Errors      int64             `json:"errors"`
+	RPS         float64           `json:"rps"`
+	Latency     hdr.Summary       `json:"latency_ns"`
+	Config      map[string]string `json:"config,omitempty"`
+
+	// ErrorClasses breaks Errors down by cause when the tool tracks it.
+	ErrorClasses errclass.Counts `json:"error_classes,omitempty"`
+
+	// Retried is how many of Requests succeeded only after a retry, and
+	// Retries how many retries were sent in all, failed ones included.
+	Retried int64 `json:"retried,omitempty"`
+	Retries int64 `json:"retries,omitempty"`
+
+	// Workers is how evenly the workers shared Requests, when the tool
+	// counts them per worker.
+	Workers *Fairness `json:"workers,omitempty"`
+}
+
+// Fairness summarizes the requests each worker completed. A wide spread
+// means some workers were starved, by client-side scheduling or by the
+// server favouring some connections, which the total alone hides.
+type Fairness struct {
+	Min    int64 `json:"min"`
+	Median int64 `json:"median"`
+	Max    int64 `json:"max"`
+	// Idle is the number of workers that completed nothing.
+	Idle int `json:"idle,omitempty"`
+}
+
+// NewFairness summarizes per-worker request counts, or returns nil when
+// there are none.
+func NewFairness(counts []int64) *Fairness {
+	if len(counts) == 0 {
+		return nil
+	}
+	sorted := slices.Sorted(slices.Values(counts))
+	n := len(sorted)
+	f := &Fairness{Min: sorted[0], Median: (sorted[(n-1)/2] + sorted[n/2]) / 2, Max: sorted[n-1]}
+	for _, c := range sorted {
+		if c > 0 {
+			break
+		}
+		f.Idle++
+	}
+	return f
+}
+
+// NewResult fills in the derived fields of a result.
+func NewResult(tool, target string, concurrency int, elapsed time.Duration, requests, errors int64, latency *hdr.Histogram) Result {
+	return Result{
+		Tool:        tool,
+		Target:      target,
+		Concurrency: concurrency,
+		Elapsed:     elapsed,
+		Requests:    requests,
+		Errors:      errors,
+		RPS:         float64(requests) / elapsed.Seconds(),
+		Latency:     latency.Summary(),
+	}
+}
+
+// Write renders results in format f. JSON output is one object per line.
+func Write(w io.Writer, f Format, results ...Result) error {
+	switch f {
+	case JSON:
+		enc := json.NewEncoder(w)
+		for _, r := range results {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case CSV:
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, r := range results {
+			cw.Write(r.csvRecord())
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		for _, r := range results {
+			r.writeText(w)
+		}
+		return nil
+	}
+}
+
+// Read decodes results written by Write in JSON format.
+func Read(r io.Reader) ([]Result, error) {
+	var out []Result
+	dec := json.NewDecoder(r)
+	for {
+		var res Result
+		err := dec.Decode(&res)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, fmt.Errorf("result %d: %w", len(out)+1, err)
+		}
+		out = append(out, res)
+	}
+}
+
+// ReadFile reads a JSON results file.
+func ReadFile(path string) ([]Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+var csvHeader = []string{
+	"tool", "target", "concurrency", "elapsed_s", "requests", "errors", "rps",
+	"min_ns", "mean_ns", "p50_ns", "p90_ns", "p99_ns", "p999_ns", "max_ns", "label",
+	"error_classes", "worker_min", "worker_median", "worker_max", "config",
+}
+
+// matrixHeader is the compact per-level layout of WriteMatrix, in
+// milliseconds so that spreadsheets and gnuplot can chart it directly.
+var matrixHeader = []string{"concurrency", "rps", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "errors"}
+
+// WriteMatrix writes one row per result with only the columns needed to plot
+// throughput and latency against concurrency. f must be CSV or JSON.
+func WriteMatrix(w io.Writer, f Format, results []Result) error {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	switch f {
+	case JSON:
+		type point struct {
+			Concurrency int     `json:"concurrency"`
+			RPS         float64 `json:"rps"`
+			P50         float64 `json:"p50_ms"`
+			P90         float64 `json:"p90_ms"`
+			P99         float64 `json:"p99_ms"`
+			P999        float64 `json:"p999_ms"`
+			Errors      int64   `json:"errors"`
+		}
+		points := make([]point, len(results))
+		for i, r := range results {
+			points[i] = point{r.Concurrency, r.RPS, ms(r.Latency.P50), ms(r.Latency.P90), ms(r.Latency.P99), ms(r.Latency.P999), r.Errors}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(points)
+	case CSV:
+		cw := csv.NewWriter(w)
+		cw.Write(matrixHeader)
+		f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+		for _, r := range results {
+			cw.Write([]string{
+				strconv.Itoa(r.Concurrency),
+				strconv.FormatFloat(r.RPS, 'f', 2, 64),
+				f(ms(r.Latency.P50)),
+				f(ms(r.Latency.P90)),
+				f(ms(r.Latency.P99)),
+				f(ms(r.Latency.P999)),
+				strconv.FormatInt(r.Errors, 10),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("matrix output must be csv or json, not %s", f)
+}
+
+// Knee returns the index of the result with the best throughput for its
+// tail latency (RPS divided by p99), which is where a sweep stops buying
+// throughput and starts buying queueing. It returns -1 if no result has
+// latency samples.
+func Knee(results []Result) int {
+	best, knee := 0.0, -1
+	for i, r := range results {
+		if r.Latency.P99 <= 0 {
+			continue
+		}
+		if power := r.RPS / r.Latency.P99.Seconds(); power > best {
+			best, knee = power, i
+		}
+	}
+	return knee
+}
+
+func (r Result) csvRecord() []string {
+	ns := func(d time.Duration) string { return strconv.FormatInt(int64(d), 10) }
+	record := []string{
+		r.Tool,
+		r.Target,
+		strconv.Itoa(r.Concurrency),
+		strconv.FormatFloat(r.Elapsed.Seconds(), 'f', 3, 64),
+		strconv.FormatInt(r.Requests, 10),
+		strconv.FormatInt(r.Errors, 10),
+		strconv.FormatFloat(r.RPS, 'f', 2, 64),
+		ns(r.Latency.Min),
+		ns(r.Latency.Mean),
+		ns(r.Latency.P50),
+		ns(r.Latency.P90),
+		ns(r.Latency.P99),
+		ns(r.Latency.P999),
+		ns(r.Latency.Max),
+		r.Label,
+		r.ErrorClasses.String(),
+	}
+	if f := r.Workers; f != nil {
+		record = append(record, strconv.FormatInt(f.Min, 10), strconv.FormatInt(f.Median, 10), strconv.FormatInt(f.Max, 10))
+	} else {
+		record = append(record, "", "", "")
+	}
+	return append(record, r.configString())
+}
+
+// configString joins the config as sorted key=value pairs separated by
+// semicolons, like the error_classes column.
+func (r Result) configString() string {
+	var b strings.Builder
+	for i, k := range slices.Sorted(maps.Keys(r.Config)) {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(r.Config[k])
+	}
+	return b.String()
+}
+
+func (r Result) writeText(w io.Writer) {
+	if r.Label != "" {
+		fmt.Fprintf(w, "\nResults (%s, %d workers):\n", r.Label, r.Concurrency)
+	} else {
+		fmt.Fprintln(w, "\nResults:")
+	}
+	fmt.Fprintf(w, "Total requests: %d\n", r.Requests)
+	if r.Retries > 0 {
+		fmt.Fprintf(w, "  first attempt: %d, after retry: %d (%d retries sent)\n", r.Requests-r.Retried, r.Retried, r.Retries)
+	}
+	fmt.Fprintf(w, "Errors: %d\n", r.Errors)
+	for _, class := range r.ErrorClasses.Sorted() {
+		fmt.Fprintf(w, "  %s: %d\n", class, r.ErrorClasses[class])
+	}
+	fmt.Fprintf(w, "Time elapsed: %v\n", r.Elapsed)
+	fmt.Fprintf(w, "Requests/sec: %.2f\n", r.RPS)
+	if f := r.Workers; f != nil {
+		fmt.Fprintf(w, "Per worker: min %d, median %d, max %d requests", f.Min, f.Median, f.Max)
+		switch {
+		case f.Idle > 0:
+			fmt.Fprintf(w, " (%d of %d workers completed none)", f.Idle, r.Concurrency)
+		case f.Min > 0 && f.Max > f.Min:
+			fmt.Fprintf(w, " (max/min %.2fx)", float64(f.Max)/float64(f.Min))
+		}
+		fmt.Fprintln(w)
+	}
+	r.Latency.WriteText(w)
+}
@@ -0,0 +1,171 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"benchmarks/internal/errclass"
+	"benchmarks/internal/hdr"
+)
+
+func sampleResult() Result {
+	h := hdr.New()
+	h.Record(time.Millisecond)
+	h.Record(3 * time.Millisecond)
+	r := NewResult("bench_http", "http://localhost:8070/", 10, 2*time.Second, 2, 1, h)
+	r.Config = map[string]string{"timeout": "5s", "interrupted": "true"}
+	r.ErrorClasses = errclass.Counts{errclass.Timeout: 1}
+	return r
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, s := range []string{"text", "json", "csv"} {
+		if _, err := ParseFormat(s); err != nil {
+			t.Errorf("ParseFormat(%q): %v", s, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat(xml) succeeded")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, sampleResult(), sampleResult()); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		var r Result
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if r.RPS != 1 || r.Errors != 1 || r.Latency.Max != 3*time.Millisecond || r.Config["timeout"] != "5s" || r.ErrorClasses["timeout"] != 1 {
+			t.Errorf("record %d round-tripped as %+v", i, r)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, sampleResult(), sampleResult()); err != nil {
+		t.Fatal(err)
+	}
+	results, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].Latency.Max != 3*time.Millisecond {
+		t.Errorf("read %+v", results)
+	}
+	if _, err := Read(strings.NewReader(`{"tool":"x"}` + "\n{oops")); err == nil {
+		t.Error("truncated input accepted")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, CSV, sampleResult()); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || len(rows[0]) != len(rows[1]) {
+		t.Fatalf("unexpected CSV shape: %v", rows)
+	}
+	if rows[1][6] != "1.00" || rows[1][13] != "3000000" || rows[1][15] != "timeout=1" || rows[1][19] != "interrupted=true;timeout=5s" {
+		t.Errorf("unexpected CSV row: %v", rows[1])
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var buf bytes.Buffer
+	Write(&buf, Text, sampleResult())
+	for _, want := range []string{"Total requests: 2", "Errors: 1", "  timeout: 1", "Requests/sec: 1.00", "p99.9:"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWriteTextRetries(t *testing.T) {
+	r := sampleResult()
+	r.Retried, r.Retries = 1, 3
+	var buf bytes.Buffer
+	Write(&buf, Text, r)
+	if want := "  first attempt: 1, after retry: 1 (3 retries sent)"; !strings.Contains(buf.String(), want) {
+		t.Errorf("text output missing %q:\n%s", want, buf.String())
+	}
+}
+
+func TestWriteMatrix(t *testing.T) {
+	r := sampleResult()
+	var buf bytes.Buffer
+	if err := WriteMatrix(&buf, CSV, []Result{r}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][0] != "10" || rows[1][4] != "3.000" {
+		t.Errorf("matrix rows %v", rows)
+	}
+
+	buf.Reset()
+	if err := WriteMatrix(&buf, JSON, []Result{r}); err != nil {
+		t.Fatal(err)
+	}
+	var points []map[string]float64
+	if err := json.Unmarshal(buf.Bytes(), &points); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0]["p99_ms"] != 3 || points[0]["rps"] != 1 {
+		t.Errorf("matrix points %v", points)
+	}
+	if err := WriteMatrix(&buf, Text, nil); err == nil {
+		t.Error("text matrix accepted")
+	}
+}
+
+func TestKnee(t *testing.T) {
+	level := func(c int, rps float64, p99 time.Duration) Result {
+		return Result{Concurrency: c, RPS: rps, Latency: hdr.Summary{P99: p99}}
+	}
+	results := []Result{
+		level(10, 10000, time.Millisecond),
+		level(50, 40000, 2*time.Millisecond),
+		level(100, 45000, 8*time.Millisecond),
+		level(500, 46000, 40*time.Millisecond),
+	}
+	if got := Knee(results); got != 1 {
+		t.Errorf("Knee = %d, want 1", got)
+	}
+	if got := Knee([]Result{{Concurrency: 1}}); got != -1 {
+		t.Errorf("Knee without latency = %d, want -1", got)
+	}
+}
+
+func TestFairness(t *testing.T) {
+	f := NewFairness([]int64{40, 0, 10, 30})
+	if *f != (Fairness{Min: 0, Median: 20, Max: 40, Idle: 1}) {
+		t.Errorf("NewFairness = %+v", *f)
+	}
+	if NewFairness(nil) != nil {
+		t.Error("NewFairness(nil) is not nil")
+	}
+
+	r := sampleResult()
+	r.Workers = NewFairness([]int64{10, 20, 30})
+	var buf bytes.Buffer
+	Write(&buf, Text, r)
+	if want := "Per worker: min 10, median 20, max 30 requests (max/min 3.00x)"; !strings.Contains(buf.String(), want) {
+		t.Errorf("text output missing %q:\n%s", want, buf.String())
+	}
+}
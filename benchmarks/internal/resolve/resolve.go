@@ -0,0 +1,205 @@
+// Package resolve controls which address the tools connect to for a
+// target. Overrides pin a host:port to an IP, like curl's --resolve, so a
+// benchmark can hit one backend behind a load balancer, or a staging
+// machine, while the URL, and with it the Host header and the TLS server
+// name, stays the production one. The address family and the happy
+// eyeballs race between IPv6 and IPv4 can be fixed too, to compare the two
+// or catch a listener bound to only one of them. A proxy, when given,
+// tunnels every connection; see package proxy.
+package resolve
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"benchmarks/internal/connstat"
+	"benchmarks/internal/proxy"
+	"benchmarks/internal/report"
+)
+
+// Options shapes how the tools dial. The zero Options dials like a plain
+// net.Dialer.
+type Options struct {
+	Overrides Overrides
+
+	// Family restricts connections to IPv4 ("4") or IPv6 ("6"); empty
+	// allows both.
+	Family string
+
+	// FallbackDelay is how long a dial to a dual-stack host waits on its
+	// preferred family, usually IPv6, before racing the other as well
+	// (RFC 6555 happy eyeballs). Zero keeps the Go default of 300ms and a
+	// negative delay turns racing off, so the addresses are tried one
+	// after another.
+	FallbackDelay time.Duration
+
+	// Proxy tunnels connections through an HTTP CONNECT or SOCKS5 proxy;
+	// nil connects directly. The overrides and family apply to both the
+	// proxy's address and the target the proxy is asked for.
+	Proxy *proxy.Dialer
+}
+
+// Register adds the dialing flags to fs.
+func (o *Options) Register(fs *flag.FlagSet) {
+	fs.Var(&o.Overrides, "resolve", "dial this IP for host:port while keeping the URL's Host header and TLS server name, like curl --resolve (host:port:ip, repeatable)")
+	family := func(f string) func(string) error {
+		return func(string) error {
+			if o.Family != "" && o.Family != f {
+				return errors.New("-4 and -6 are mutually exclusive")
+			}
+			o.Family = f
+			return nil
+		}
+	}
+	fs.BoolFunc("4", "connect over IPv4 only", family("4"))
+	fs.BoolFunc("6", "connect over IPv6 only", family("6"))
+	fs.Func("proxy", "tunnel connections through this proxy: http://[user:pass@]host:port (CONNECT) or socks5://[user:pass@]host:port", func(s string) (err error) {
+		o.Proxy, err = proxy.Parse(s)
+		return err
+	})
+	fs.DurationVar(&o.FallbackDelay, "fallback-delay", o.FallbackDelay, "happy eyeballs: how long a dual-stack dial waits on the preferred family, usually IPv6, before racing the other (0 = Go default of 300ms, negative = no racing, try addresses in turn)")
+}
+
+// Enabled reports whether o changes anything about dialing.
+func (o *Options) Enabled() bool {
+	return len(o.Overrides) > 0 || o.Family != "" || o.FallbackDelay != 0 || o.Proxy != nil
+}
+
+// Network returns network restricted to the chosen family, e.g. tcp6 for
+// tcp under -6.
+func (o *Options) Network(network string) string {
+	switch network {
+	case "tcp", "udp", "ip":
+		return network + o.Family
+	}
+	return network
+}
+
+// Addr returns the address to dial for addr under the overrides.
+func (o *Options) Addr(addr string) string {
+	return o.Overrides.Addr(addr)
+}
+
+// Dial returns d's DialContext with o applied. It sets d's FallbackDelay.
+func (o *Options) Dial(d *net.Dialer) connstat.DialFunc {
+	if o.FallbackDelay != 0 {
+		d.FallbackDelay = o.FallbackDelay
+	}
+	dial := o.DialContext(d.DialContext)
+	if o.Proxy == nil {
+		return dial
+	}
+	tunnel := o.Proxy.DialContext(dial)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return tunnel(ctx, o.Network(network), o.Addr(addr))
+	}
+}
+
+// DialContext wraps dial so overridden addresses connect to their pinned
+// IP over the chosen family. Wrappers outside it still see the original
+// address, so a TLS handshake layered on top names the original host.
+func (o *Options) DialContext(dial connstat.DialFunc) connstat.DialFunc {
+	if len(o.Overrides) == 0 && o.Family == "" {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, o.Network(network), o.Addr(addr))
+	}
+}
+
+// Describe prints the dialing options for the run header.
+func (o *Options) Describe(w io.Writer) {
+	if len(o.Overrides) > 0 {
+		fmt.Fprintf(w, "Resolve: %s\n", o.Overrides.String())
+	}
+	if o.Family != "" {
+		fmt.Fprintf(w, "Address family: IPv%s only\n", o.Family)
+	}
+	switch {
+	case o.FallbackDelay < 0:
+		fmt.Fprintln(w, "Happy eyeballs: off (addresses tried in turn)")
+	case o.FallbackDelay > 0:
+		fmt.Fprintf(w, "Happy eyeballs: fallback family raced after %v\n", o.FallbackDelay)
+	}
+	if o.Proxy != nil {
+		fmt.Fprintf(w, "Proxy: %s\n", o.Proxy)
+	}
+}
+
+// Annotate records the dialing options in the config of every result,
+// and prints the proxy's tunnel setup times to w when there is one.
+func (o *Options) Annotate(w io.Writer, results []report.Result) {
+	o.Proxy.Annotate(w, results)
+	for _, r := range results {
+		if len(o.Overrides) > 0 {
+			r.Config["resolve"] = o.Overrides.String()
+		}
+		if o.Family != "" {
+			r.Config["ip_family"] = "ipv" + o.Family
+		}
+		if o.FallbackDelay != 0 {
+			r.Config["fallback_delay"] = o.FallbackDelay.String()
+		}
+	}
+}
+
+// Overrides maps host:port to the IP dialed in its place. It is a
+// flag.Value taking host:port:ip, repeatable; an IPv6 address may be
+// bracketed. A nil Overrides dials every address as given.
+type Overrides map[string]string
+
+func (o *Overrides) String() string {
+	if o == nil {
+		return ""
+	}
+	entries := make([]string, 0, len(*o))
+	for hostPort, ip := range *o {
+		entries = append(entries, hostPort+":"+ip)
+	}
+	slices.Sort(entries)
+	return strings.Join(entries, ",")
+}
+
+func (o *Overrides) Set(s string) error {
+	host, rest, _ := strings.Cut(s, ":")
+	port, ip, ok := strings.Cut(rest, ":")
+	if !ok || host == "" {
+		return fmt.Errorf("%q is not host:port:ip", s)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q: invalid port %q", s, port)
+	}
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("%q: invalid IP address %q", s, ip)
+	}
+	if *o == nil {
+		*o = Overrides{}
+	}
+	(*o)[net.JoinHostPort(strings.ToLower(host), port)] = ip
+	return nil
+}
+
+// Addr returns the address to dial for addr: the pinned IP with addr's
+// port, or addr itself when it has no override.
+func (o Overrides) Addr(addr string) string {
+	if len(o) == 0 {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip, ok := o[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+	return addr
+}
@@ -0,0 +1,104 @@
+package resolve
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	var o Overrides
+	for _, s := range []string{"API.example.com:443:10.0.0.5", "example.com:80:[::1]"} {
+		if err := o.Set(s); err != nil {
+			t.Fatalf("Set(%q): %v", s, err)
+		}
+	}
+	for addr, want := range map[string]string{
+		"api.example.com:443": "10.0.0.5:443",
+		"api.example.com:80":  "api.example.com:80",
+		"example.com:80":      "[::1]:80",
+		"other.com:80":        "other.com:80",
+	} {
+		if got := o.Addr(addr); got != want {
+			t.Errorf("Addr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+	if got, want := o.String(), "api.example.com:443:10.0.0.5,example.com:80:::1"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"example.com", "example.com:80", "example.com:x:10.0.0.1", "example.com:80:not-an-ip", ":80:10.0.0.1"} {
+		if err := o.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	var o Options
+	if err := o.Overrides.Set("backend.invalid:" + port + ":127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{DialContext: o.Dial(&net.Dialer{})}}
+	resp, err := client.Get("http://backend.invalid:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	host, _ := io.ReadAll(resp.Body)
+	if want := "backend.invalid:" + port; string(host) != want {
+		t.Errorf("server saw Host %q, want %q", host, want)
+	}
+}
+
+func TestFamily(t *testing.T) {
+	lis, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	for _, tc := range []struct {
+		args []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{"-4"}, true},
+		// An IPv4-only listener is exactly what -6 should catch
+		{[]string{"-6"}, false},
+		{[]string{"-4", "-fallback-delay", "-1ms"}, true},
+	} {
+		var o Options
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		o.Register(fs)
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := o.Dial(&net.Dialer{})(context.Background(), "tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("%v: dial error %v, want success %v", tc.args, err, tc.ok)
+		}
+	}
+
+	var o Options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o.Register(fs)
+	if err := fs.Parse([]string{"-4", "-6"}); err == nil {
+		t.Error("-4 -6 parsed")
+	}
+}
@@ -0,0 +1,143 @@
+// Package retry re-sends requests that failed for a transient reason, such
+// as a refused dial while the server restarts, after an exponential
+// backoff. Without it such failures are simply counted as errors and the
+// worker moves on, or for a persistent connection stops altogether.
+package retry
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"benchmarks/internal/errclass"
+)
+
+// DefaultOn is the error classes retried unless -retry-on says otherwise:
+// those where the request most likely never reached the server, plus the
+// reset and EOF of a connection the server closed on its way down.
+// timeout_connect is the httpload class of a connect that timed out.
+var DefaultOn = []string{errclass.Refused, errclass.Dial, errclass.Reset, errclass.EOF, "timeout_connect"}
+
+// Policy says which failures to retry and how often. The zero Policy
+// retries nothing.
+type Policy struct {
+	// Attempts is how many times a request is retried after its first
+	// attempt fails.
+	Attempts int
+	// Backoff is the delay before the first retry. It doubles with each
+	// further retry up to MaxBackoff, and each delay is jittered down by
+	// up to half so workers that failed together do not retry together.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// On lists the error classes worth retrying; nil means DefaultOn.
+	On []string
+}
+
+// Register adds the retry flags to fs.
+func (p *Policy) Register(fs *flag.FlagSet) {
+	if p.Backoff == 0 {
+		p.Backoff = 10 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = time.Second
+	}
+	fs.IntVar(&p.Attempts, "retries", p.Attempts, "retry a request failing with a transient error up to this many times (0 = count it as an error)")
+	fs.DurationVar(&p.Backoff, "retry-backoff", p.Backoff, "delay before the first retry, doubling for each further one")
+	fs.DurationVar(&p.MaxBackoff, "retry-max-backoff", p.MaxBackoff, "upper bound on the delay between retries")
+	fs.Func("retry-on", "comma-separated error classes to retry (default "+strings.Join(DefaultOn, ",")+")", func(s string) error {
+		var on []string
+		for _, class := range strings.Split(s, ",") {
+			if class = strings.TrimSpace(class); class != "" {
+				on = append(on, class)
+			}
+		}
+		if len(on) == 0 {
+			return fmt.Errorf("no error classes in %q", s)
+		}
+		p.On = on
+		return nil
+	})
+}
+
+// Validate reports settings that cannot be applied.
+func (p *Policy) Validate() error {
+	switch {
+	case p.Attempts < 0:
+		return errors.New("-retries must not be negative")
+	case p.Attempts > 0 && p.Backoff < 0:
+		return errors.New("-retry-backoff must not be negative")
+	}
+	return nil
+}
+
+// Enabled reports whether p retries anything.
+func (p *Policy) Enabled() bool {
+	return p.Attempts > 0
+}
+
+// Retryable reports whether a failure of this class is retried.
+func (p *Policy) Retryable(class string) bool {
+	return slices.Contains(p.classes(), class)
+}
+
+func (p *Policy) classes() []string {
+	if p.On == nil {
+		return DefaultOn
+	}
+	return p.On
+}
+
+// Delay returns the backoff before retry n, counted from zero.
+func (p *Policy) Delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 0; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, p.MaxBackoff)
+	}
+	if d <= 1 {
+		return d
+	}
+	return d - rand.N(d/2)
+}
+
+// Do calls attempt until it returns "" or a class p does not retry, the
+// retries run out, or the next backoff would end past deadline (zero for
+// none). It returns the class of the last attempt and how many retries
+// were made.
+func (p *Policy) Do(deadline time.Time, attempt func() string) (class string, retries int) {
+	for {
+		class = attempt()
+		if class == "" || retries >= p.Attempts || !p.Retryable(class) {
+			return class, retries
+		}
+		d := p.Delay(retries)
+		if !deadline.IsZero() && time.Now().Add(d).After(deadline) {
+			return class, retries
+		}
+		time.Sleep(d)
+		retries++
+	}
+}
+
+// String describes p for run headers.
+func (p *Policy) String() string {
+	return fmt.Sprintf("up to %d on %s, backoff %v to %v", p.Attempts, strings.Join(p.classes(), ","), p.Backoff, p.MaxBackoff)
+}
+
+// Record adds p to a result's config when it retries anything.
+func (p *Policy) Record(config map[string]string) {
+	if !p.Enabled() {
+		return
+	}
+	config["retries"] = strconv.Itoa(p.Attempts)
+	config["retry_backoff"] = p.Backoff.String()
+	config["retry_max_backoff"] = p.MaxBackoff.String()
+	config["retry_on"] = strings.Join(p.classes(), ",")
+}
@@ -0,0 +1,67 @@
+package retry
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for n, want := range []time.Duration{10, 20, 40, 50, 50} {
+		want *= time.Millisecond
+		for range 100 {
+			if d := p.Delay(n); d > want || d < want/2 {
+				t.Fatalf("Delay(%d) = %v, want %v to %v", n, d, want/2, want)
+			}
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := Policy{Attempts: 3, Backoff: time.Microsecond, MaxBackoff: time.Microsecond}
+	for _, tc := range []struct {
+		name        string
+		classes     []string
+		deadline    time.Time
+		wantClass   string
+		wantRetries int
+	}{
+		{"first attempt", []string{""}, time.Time{}, "", 0},
+		{"after retries", []string{"refused", "reset", ""}, time.Time{}, "", 2},
+		{"exhausted", []string{"refused", "refused", "refused", "refused", ""}, time.Time{}, "refused", 3},
+		{"not retryable", []string{"status_500", ""}, time.Time{}, "status_500", 0},
+		{"past deadline", []string{"refused", ""}, time.Now(), "refused", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			class, retries := p.Do(tc.deadline, func() string {
+				calls++
+				return tc.classes[calls-1]
+			})
+			if class != tc.wantClass || retries != tc.wantRetries || calls != retries+1 {
+				t.Fatalf("class %q after %d retries and %d calls, want %q after %d", class, retries, calls, tc.wantClass, tc.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var p Policy
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	p.Register(fs)
+	if err := fs.Parse([]string{"-retries", "2", "-retry-on", "timeout, status_503"}); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Enabled() || !p.Retryable("status_503") || p.Retryable("refused") {
+		t.Fatalf("%+v", p)
+	}
+	if err := fs.Parse([]string{"-retry-on", ","}); err == nil {
+		t.Fatal("empty -retry-on accepted")
+	}
+	config := map[string]string{}
+	p.Record(config)
+	if config["retries"] != "2" || config["retry_on"] != "timeout,status_503" {
+		t.Fatalf("config %v", config)
+	}
+}
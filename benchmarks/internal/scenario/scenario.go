@@ -0,0 +1,292 @@
+// Package scenario describes multi-step request sequences, loaded from YAML,
+// that a benchmark's virtual users execute in a loop. Values captured from
+// one response can be substituted into later requests as ${name}.
+//
+//	name: browse
+//	variables:
+//	  user: bench-${vu}
+//	steps:
+//	  - name: login
+//	    method: POST
+//	    path: /login
+//	    body: '{"user":"${user}"}'
+//	    extract:
+//	      token: json:token
+//	  - name: profile
+//	    path: /users/me
+//	    headers:
+//	      Authorization: Bearer ${token}
+//	    expect: 200
+//
+// A login step runs once per virtual user before its first iteration
+// rather than in every one. The variables it extracts and the cookies it
+// receives last for the whole run:
+//
+//	login:
+//	  path: /login
+//	  body: '{"user":"bench-${vu}"}'
+//	  extract:
+//	    token: json:token
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a named sequence of steps.
+type Scenario struct {
+	Name      string            `yaml:"name"`
+	Variables map[string]string `yaml:"variables"`
+	Login     *Step             `yaml:"login,omitempty"`
+	Steps     []Step            `yaml:"steps"`
+}
+
+// Step is a single request of a scenario.
+type Step struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+
+	// Expect is the required status code; zero accepts any 2xx.
+	Expect int `yaml:"expect"`
+
+	// Extract maps variable names to a source in the response:
+	// "json:a.b.0" walks the JSON body, "header:Name" reads a header and
+	// "regex:expr" takes the first capture group matched in the body.
+	Extract map[string]string `yaml:"extract"`
+
+	extractors map[string]extractor
+}
+
+// Load reads and validates a scenario file.
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a YAML scenario.
+func Parse(data []byte) (*Scenario, error) {
+	var s Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("scenario: %w", err)
+	}
+	if len(s.Steps) == 0 {
+		return nil, errors.New("scenario: no steps")
+	}
+	if s.Login != nil {
+		if s.Login.Name == "" {
+			s.Login.Name = "login"
+		}
+		if err := s.Login.Compile(); err != nil {
+			return nil, fmt.Errorf("scenario: %w", err)
+		}
+	}
+	for i := range s.Steps {
+		st := &s.Steps[i]
+		if st.Name == "" {
+			st.Name = fmt.Sprintf("step%d", i+1)
+		}
+		if err := st.Compile(); err != nil {
+			return nil, fmt.Errorf("scenario: %w", err)
+		}
+	}
+	return &s, nil
+}
+
+// Compile fills in the step's default method and compiles its extractors.
+// Parse compiles the steps it reads; a step built in code must be compiled
+// before use.
+func (st *Step) Compile() error {
+	if st.Method == "" {
+		st.Method = http.MethodGet
+		if st.Body != "" {
+			st.Method = http.MethodPost
+		}
+	}
+	if st.Path == "" {
+		return fmt.Errorf("step %q has no path", st.Name)
+	}
+	st.extractors = make(map[string]extractor, len(st.Extract))
+	for name, src := range st.Extract {
+		ex, err := parseExtractor(src)
+		if err != nil {
+			return fmt.Errorf("step %q, variable %q: %w", st.Name, name, err)
+		}
+		st.extractors[name] = ex
+	}
+	return nil
+}
+
+// InitialVars returns the variables a virtual user starts each iteration
+// with. The built-in ${vu} is the virtual user's number.
+func (s *Scenario) InitialVars(vu int) map[string]string {
+	vars := map[string]string{"vu": strconv.Itoa(vu)}
+	for k, v := range s.Variables {
+		vars[k] = Expand(v, vars)
+	}
+	return vars
+}
+
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// Expand replaces ${name} with its value. Unknown names expand to "".
+func Expand(s string, vars map[string]string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		return vars[m[2:len(m)-1]]
+	})
+}
+
+// NeedsBody reports whether Check must be given the response body.
+func (st *Step) NeedsBody() bool {
+	for _, ex := range st.extractors {
+		if ex.kind != "header" {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRequest builds the step's request against base with vars substituted.
+func (st *Step) NewRequest(base *url.URL, vars map[string]string) (*http.Request, error) {
+	ref, err := url.Parse(Expand(st.Path, vars))
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if st.Body != "" {
+		body = strings.NewReader(Expand(st.Body, vars))
+	}
+	req, err := http.NewRequest(st.Method, base.ResolveReference(ref).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if st.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range st.Headers {
+		req.Header.Set(k, Expand(v, vars))
+	}
+	return req, nil
+}
+
+// Check validates the response status and stores extracted values in vars.
+func (st *Step) Check(resp *http.Response, body []byte, vars map[string]string) error {
+	if st.Expect != 0 && resp.StatusCode != st.Expect {
+		return fmt.Errorf("%s: status %d, want %d", st.Name, resp.StatusCode, st.Expect)
+	}
+	if st.Expect == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("%s: status %d", st.Name, resp.StatusCode)
+	}
+	for name, ex := range st.extractors {
+		v, err := ex.extract(resp.Header, body)
+		if err != nil {
+			return fmt.Errorf("%s: extracting %s: %w", st.Name, name, err)
+		}
+		vars[name] = v
+	}
+	return nil
+}
+
+type extractor struct {
+	kind string // json, header or regex
+	arg  string
+	re   *regexp.Regexp
+}
+
+func parseExtractor(src string) (extractor, error) {
+	kind, arg, ok := strings.Cut(src, ":")
+	if !ok || arg == "" {
+		return extractor{}, fmt.Errorf("source %q is not kind:argument", src)
+	}
+	ex := extractor{kind: kind, arg: arg}
+	switch kind {
+	case "json", "header":
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return extractor{}, err
+		}
+		if re.NumSubexp() < 1 {
+			return extractor{}, fmt.Errorf("regex %q has no capture group", arg)
+		}
+		ex.re = re
+	default:
+		return extractor{}, fmt.Errorf("unknown source kind %q", kind)
+	}
+	return ex, nil
+}
+
+func (ex extractor) extract(h http.Header, body []byte) (string, error) {
+	switch ex.kind {
+	case "header":
+		v := h.Get(ex.arg)
+		if v == "" {
+			return "", fmt.Errorf("header %s missing", ex.arg)
+		}
+		return v, nil
+	case "regex":
+		m := ex.re.FindSubmatch(body)
+		if m == nil {
+			return "", errors.New("no match")
+		}
+		return string(m[1]), nil
+	default:
+		return jsonPath(body, ex.arg)
+	}
+}
+
+// jsonPath walks a dot-separated path of object keys and array indexes.
+func jsonPath(body []byte, path string) (string, error) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return "", fmt.Errorf("key %q missing", key)
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("index %q out of range", key)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("cannot descend into %q", key)
+		}
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case nil:
+		return "", nil
+	default:
+		b, _ := json.Marshal(s)
+		return string(b), nil
+	}
+}
@@ -0,0 +1,133 @@
+package scenario
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const browse = `
+name: browse
+variables:
+  user: bench-${vu}
+steps:
+  - name: login
+    path: /login
+    body: '{"user":"${user}"}'
+    extract:
+      token: json:auth.token
+      session: header:X-Session
+      id: 'regex:"id":(\d+)'
+  - name: fetch
+    path: /users/${id}
+    headers:
+      Authorization: Bearer ${token}
+    expect: 200
+`
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(browse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Steps) != 2 || s.Steps[0].Method != http.MethodPost || s.Steps[1].Method != http.MethodGet {
+		t.Errorf("unexpected steps %+v", s.Steps)
+	}
+	if !s.Steps[0].NeedsBody() || s.Steps[1].NeedsBody() {
+		t.Error("NeedsBody is wrong")
+	}
+	if vars := s.InitialVars(7); vars["user"] != "bench-7" {
+		t.Errorf("initial vars = %v", vars)
+	}
+
+	s, err = Parse([]byte("login: {path: /login, body: '{}', extract: {token: 'json:token'}}\nsteps: [{path: /}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := s.Login; l.Name != "login" || l.Method != http.MethodPost || !l.NeedsBody() {
+		t.Errorf("unexpected login %+v", l)
+	}
+
+	for _, bad := range []string{
+		"steps: []",
+		"steps: [{name: x}]",
+		"steps: [{path: /, extract: {a: 'xml:b'}}]",
+		"steps: [{path: /, extract: {a: 'regex:nogroup'}}]",
+		"steps: [{path: /, unknown: 1}]",
+		"login: {body: x}\nsteps: [{path: /}]",
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestStepsRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != `{"user":"bench-3"}` {
+				http.Error(w, "bad login", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("X-Session", "s1")
+			io.WriteString(w, `{"auth":{"token":"t0k"},"user":{"id":42}}`)
+		case "/users/42":
+			if r.Header.Get("Authorization") != "Bearer t0k" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := Parse([]byte(browse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse(srv.URL)
+	vars := s.InitialVars(3)
+	for i := range s.Steps {
+		st := &s.Steps[i]
+		req, err := st.NewRequest(base, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err := st.Check(resp, body, vars); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vars["token"] != "t0k" || vars["session"] != "s1" || vars["id"] != "42" {
+		t.Errorf("extracted vars = %v", vars)
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	body := []byte(`{"items":[{"id":1},{"id":2,"tags":["a"]}],"n":null}`)
+	for path, want := range map[string]string{
+		"items.1.id":     "2",
+		"items.1.tags.0": "a",
+		"items.0":        `{"id":1}`,
+		"n":              "",
+	} {
+		got, err := jsonPath(body, path)
+		if err != nil || got != want {
+			t.Errorf("jsonPath(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+	for _, path := range []string{"missing", "items.5", "items.x", "items.0.id.deeper"} {
+		if _, err := jsonPath(body, path); err == nil {
+			t.Errorf("jsonPath(%q) succeeded", path)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package selfprof profiles the benchmark client rather than the server.
+// At high concurrency the load generator can become the bottleneck, and a
+// run where the client burns every core or allocates heavily per request
+// says more about the client than about FasterAPI.
+//
+// A nil *Session is valid and does nothing, which lets tools call it
+// unconditionally whether or not profiling was requested.
+package selfprof
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"syscall"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+// Session covers one run of the client.
+type Session struct {
+	cpu      *os.File
+	heapPath string
+
+	start     time.Time
+	startCPU  time.Duration
+	startMem  runtime.MemStats
+	elapsed   time.Duration
+	cpuTime   time.Duration
+	mallocs   uint64
+	allocated uint64
+	gcs       uint32
+}
+
+// Start begins a session, writing a CPU profile to cpuPath and, at Stop, a
+// heap profile to heapPath. Either path may be empty.
+func Start(cpuPath, heapPath string) (*Session, error) {
+	s := &Session{heapPath: heapPath}
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.cpu = f
+	}
+	runtime.ReadMemStats(&s.startMem)
+	s.startCPU = cpuTime()
+	s.start = time.Now()
+	return s, nil
+}
+
+// Stop ends the session and writes the profiles.
+func (s *Session) Stop() error {
+	if s == nil {
+		return nil
+	}
+	s.elapsed = time.Since(s.start)
+	s.cpuTime = cpuTime() - s.startCPU
+	var end runtime.MemStats
+	runtime.ReadMemStats(&end)
+	s.mallocs = end.Mallocs - s.startMem.Mallocs
+	s.allocated = end.TotalAlloc - s.startMem.TotalAlloc
+	s.gcs = end.NumGC - s.startMem.NumGC
+
+	if s.cpu != nil {
+		pprof.StopCPUProfile()
+		if err := s.cpu.Close(); err != nil {
+			return err
+		}
+	}
+	if s.heapPath != "" {
+		f, err := os.Create(s.heapPath)
+		if err != nil {
+			return err
+		}
+		// Up-to-date statistics rather than those of the last GC
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+// Annotate prints the client's CPU use and allocations per request to w
+// and records them in the config of every result. requests is how many
+// requests the run sent, failed ones included.
+func (s *Session) Annotate(w io.Writer, results []report.Result, requests int64) {
+	if s == nil {
+		return
+	}
+	cores := runtime.NumCPU()
+	util := 0.0
+	if s.elapsed > 0 {
+		util = s.cpuTime.Seconds() / s.elapsed.Seconds() * 100
+	}
+	perReq := func(v uint64) float64 {
+		if requests == 0 {
+			return 0
+		}
+		return float64(v) / float64(requests)
+	}
+	fmt.Fprintf(w, "\nClient: %.0f%% CPU of %d cores, %.1f allocs and %.0f bytes per request, %d GCs\n",
+		util, cores, perReq(s.mallocs), perReq(s.allocated), s.gcs)
+	if util > float64(cores)*100*0.9 {
+		fmt.Fprintln(w, "Client: CPU saturated; the load generator may be the bottleneck")
+	}
+	for _, r := range results {
+		r.Config["client_cpu_pct"] = strconv.FormatFloat(util, 'f', 0, 64)
+		r.Config["client_cores"] = strconv.Itoa(cores)
+		r.Config["client_allocs_per_req"] = strconv.FormatFloat(perReq(s.mallocs), 'f', 1, 64)
+		r.Config["client_bytes_per_req"] = strconv.FormatFloat(perReq(s.allocated), 'f', 0, 64)
+		r.Config["client_gcs"] = strconv.FormatUint(uint64(s.gcs), 10)
+	}
+}
+
+// cpuTime returns the user and system CPU time the process has used.
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
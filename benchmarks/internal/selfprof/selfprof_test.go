@@ -0,0 +1,48 @@
+package selfprof
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"benchmarks/internal/report"
+)
+
+var sink [][]byte
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	cpu, heap := filepath.Join(dir, "cpu.out"), filepath.Join(dir, "heap.out")
+	s, err := Start(cpu, heap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 1000 {
+		sink = append(sink, make([]byte, 64))
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{cpu, heap} {
+		if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+			t.Errorf("profile %s not written: %v", path, err)
+		}
+	}
+
+	results := []report.Result{{Config: map[string]string{}}}
+	var buf bytes.Buffer
+	s.Annotate(&buf, results, 1000)
+	if !strings.Contains(buf.String(), "allocs") || results[0].Config["client_allocs_per_req"] == "0.0" {
+		t.Errorf("annotated %v:\n%s", results[0].Config, buf.String())
+	}
+}
+
+func TestNilSession(t *testing.T) {
+	var s *Session
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	s.Annotate(&bytes.Buffer{}, nil, 0)
+}
@@ -0,0 +1,398 @@
+// Package srvstat samples the target server's resource use while a
+// benchmark runs, so a report can say what the RPS cost the server: CPU,
+// resident memory, threads or goroutines, and garbage collection.
+//
+// Two sources are supported and may be combined: /proc/<pid> for a server
+// on the same host, and a stats URL serving either Go expvar JSON
+// (/debug/vars) or the Prometheus text format (/metrics). Where both know a
+// figure, /proc wins.
+//
+// A nil *Sampler is valid and does nothing.
+package srvstat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+// Interval is how often a running Sampler takes a sample.
+const Interval = time.Second
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc/<pid>/stat. It is
+// 100 on every mainstream Linux build.
+const clockTicks = 100
+
+// unknown marks a figure no source provided.
+const unknown = -1
+
+// Sample is a snapshot of cumulative and current figures. Any field may be
+// unknown (-1) when no source reports it.
+type Sample struct {
+	At         time.Time
+	CPU        time.Duration // cumulative user and system time
+	RSS        int64         // bytes; Go runtime Sys when only expvar is available
+	Threads    int64
+	Goroutines int64
+	GCs        int64         // cumulative
+	GCPause    time.Duration // cumulative
+}
+
+func newSample() Sample {
+	return Sample{At: time.Now(), CPU: unknown, RSS: unknown, Threads: unknown, Goroutines: unknown, GCs: unknown, GCPause: unknown}
+}
+
+// Sampler samples a server in the background between Start and Stop.
+type Sampler struct {
+	pid    int
+	url    string
+	client *http.Client
+
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	samples []Sample
+	failed  int
+	lastErr error
+}
+
+// Start takes a first sample of the server with the given pid and stats
+// URL, either of which may be unset, and keeps sampling every Interval
+// until Stop. It fails if the first sample cannot be taken, so a wrong
+// pid or URL is reported before the run rather than after.
+func Start(pid int, url string) (*Sampler, error) {
+	if pid <= 0 && url == "" {
+		return nil, errors.New("srvstat: no pid or stats URL to sample")
+	}
+	s := &Sampler{
+		pid:    pid,
+		url:    url,
+		client: &http.Client{Timeout: Interval},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	first, err := s.take()
+	if err != nil {
+		return nil, err
+	}
+	s.samples = append(s.samples, first)
+	go s.loop()
+	return s, nil
+}
+
+func (s *Sampler) loop() {
+	defer close(s.done)
+	tick := time.NewTicker(Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-tick.C:
+			s.record()
+		}
+	}
+}
+
+// record takes a sample, or counts the failure. A server that has died or
+// stopped answering mid-run is worth knowing about, not fatal.
+func (s *Sampler) record() {
+	sample, err := s.take()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failed++
+		s.lastErr = err
+		return
+	}
+	s.samples = append(s.samples, sample)
+}
+
+// Stop takes a final sample and stops sampling.
+func (s *Sampler) Stop() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.record()
+}
+
+func (s *Sampler) take() (Sample, error) {
+	sample := newSample()
+	if s.pid > 0 {
+		if err := readProc(s.pid, &sample); err != nil {
+			return sample, err
+		}
+	}
+	if s.url != "" {
+		if err := s.scrape(&sample); err != nil {
+			return sample, err
+		}
+	}
+	return sample, nil
+}
+
+// readProc fills sample from /proc/<pid>/stat.
+func readProc(pid int, sample *Sample) error {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return err
+	}
+	return parseStat(b, os.Getpagesize(), sample)
+}
+
+// parseStat reads utime, stime, num_threads and rss (fields 14, 15, 20 and
+// 24 of proc(5)). The command name in field 2 may contain spaces and
+// parentheses, so fields are counted from the last ')'.
+func parseStat(b []byte, pageSize int, sample *Sample) error {
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return errors.New("srvstat: malformed /proc stat")
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	field := func(n int) (int64, error) {
+		// fields[0] is field 3, the process state
+		if n-3 >= len(fields) {
+			return 0, errors.New("srvstat: short /proc stat")
+		}
+		return strconv.ParseInt(fields[n-3], 10, 64)
+	}
+	var vals [4]int64
+	for j, n := range []int{14, 15, 20, 24} {
+		v, err := field(n)
+		if err != nil {
+			return err
+		}
+		vals[j] = v
+	}
+	sample.CPU = time.Duration(vals[0]+vals[1]) * time.Second / clockTicks
+	sample.Threads = vals[2]
+	sample.RSS = vals[3] * int64(pageSize)
+	return nil
+}
+
+// scrape fills the figures still unknown in sample from the stats URL,
+// telling expvar JSON from Prometheus text by the first byte.
+func (s *Sampler) scrape(sample *Sample) error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("srvstat: %s: %s", s.url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if b := bytes.TrimSpace(body); len(b) > 0 && b[0] == '{' {
+		return parseExpvar(b, sample)
+	}
+	return parsePrometheus(body, sample)
+}
+
+// parseExpvar reads the memstats every Go program publishes through
+// expvar, and a "goroutines" variable if the server publishes one.
+func parseExpvar(b []byte, sample *Sample) error {
+	var vars struct {
+		Goroutines *int64 `json:"goroutines"`
+		MemStats   *struct {
+			Sys          int64
+			NumGC        int64
+			PauseTotalNs int64
+		} `json:"memstats"`
+	}
+	if err := json.Unmarshal(b, &vars); err != nil {
+		return fmt.Errorf("srvstat: expvar: %w", err)
+	}
+	if vars.Goroutines != nil {
+		fill(&sample.Goroutines, *vars.Goroutines)
+	}
+	if m := vars.MemStats; m != nil {
+		fill(&sample.RSS, m.Sys)
+		fill(&sample.GCs, m.NumGC)
+		fillDuration(&sample.GCPause, time.Duration(m.PauseTotalNs))
+	}
+	return nil
+}
+
+// parsePrometheus reads the standard process and Go collector series. Only
+// unlabelled samples are needed, so the text format is scanned by hand.
+func parsePrometheus(b []byte, sample *Sample) error {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || strings.ContainsRune(line, '{') {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "process_cpu_seconds_total":
+			fillDuration(&sample.CPU, time.Duration(v*float64(time.Second)))
+		case "process_resident_memory_bytes":
+			fill(&sample.RSS, int64(v))
+		case "go_goroutines":
+			fill(&sample.Goroutines, int64(v))
+		case "go_threads":
+			fill(&sample.Threads, int64(v))
+		case "go_gc_duration_seconds_count":
+			fill(&sample.GCs, int64(v))
+		case "go_gc_duration_seconds_sum":
+			fillDuration(&sample.GCPause, time.Duration(v*float64(time.Second)))
+		}
+	}
+	return sc.Err()
+}
+
+func fill(dst *int64, v int64) {
+	if *dst == unknown {
+		*dst = v
+	}
+}
+
+func fillDuration(dst *time.Duration, v time.Duration) {
+	if *dst == unknown {
+		*dst = v
+	}
+}
+
+// Summary condenses the samples of a run. Fields are -1 when unknown.
+type Summary struct {
+	Samples        int
+	Failed         int
+	LastErr        error
+	CPUAvg         float64 // percent of one core over the whole run
+	CPUPeak        float64 // highest percent between two samples
+	RSSStart       int64   // at the first sample, to measure growth from
+	RSSPeak        int64
+	ThreadsPeak    int64
+	GoroutinesPeak int64
+	GCs            int64
+	GCPause        time.Duration
+}
+
+// Summary returns the figures collected so far.
+func (s *Sampler) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return summarize(s.samples, s.failed, s.lastErr)
+}
+
+func summarize(samples []Sample, failed int, lastErr error) Summary {
+	sum := Summary{
+		Samples: len(samples), Failed: failed, LastErr: lastErr,
+		CPUAvg: unknown, CPUPeak: unknown,
+		RSSStart: unknown, RSSPeak: unknown, ThreadsPeak: unknown, GoroutinesPeak: unknown,
+		GCs: unknown, GCPause: unknown,
+	}
+	if len(samples) == 0 {
+		return sum
+	}
+	for _, x := range samples {
+		sum.RSSPeak = max(sum.RSSPeak, x.RSS)
+		sum.ThreadsPeak = max(sum.ThreadsPeak, x.Threads)
+		sum.GoroutinesPeak = max(sum.GoroutinesPeak, x.Goroutines)
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	sum.RSSStart = first.RSS
+	if pct, ok := cpuPercent(first, last); ok {
+		sum.CPUAvg = pct
+	}
+	for i := 1; i < len(samples); i++ {
+		// /proc counts CPU in 10ms ticks, too coarse for the sliver
+		// between the last tick and Stop
+		if samples[i].At.Sub(samples[i-1].At) < Interval/2 {
+			continue
+		}
+		if pct, ok := cpuPercent(samples[i-1], samples[i]); ok {
+			sum.CPUPeak = max(sum.CPUPeak, pct)
+		}
+	}
+	if sum.CPUPeak == unknown {
+		sum.CPUPeak = sum.CPUAvg
+	}
+	if first.GCs != unknown && last.GCs != unknown {
+		sum.GCs = last.GCs - first.GCs
+	}
+	if first.GCPause != unknown && last.GCPause != unknown {
+		sum.GCPause = last.GCPause - first.GCPause
+	}
+	return sum
+}
+
+func cpuPercent(a, b Sample) (float64, bool) {
+	wall := b.At.Sub(a.At)
+	if a.CPU == unknown || b.CPU == unknown || wall <= 0 {
+		return 0, false
+	}
+	return float64(b.CPU-a.CPU) / float64(wall) * 100, true
+}
+
+// Annotate prints the server's resource use to w and records it in the
+// config of every result.
+func (s *Sampler) Annotate(w io.Writer, results []report.Result) {
+	if s == nil {
+		return
+	}
+	sum := s.Summary()
+	var parts []string
+	set := func(key, value string) {
+		for _, r := range results {
+			r.Config[key] = value
+		}
+	}
+	if sum.CPUAvg != unknown {
+		parts = append(parts, fmt.Sprintf("%.0f%% CPU (peak %.0f%%)", sum.CPUAvg, sum.CPUPeak))
+		set("server_cpu_pct", strconv.FormatFloat(sum.CPUAvg, 'f', 0, 64))
+		set("server_cpu_peak_pct", strconv.FormatFloat(sum.CPUPeak, 'f', 0, 64))
+	}
+	if sum.RSSPeak != unknown {
+		parts = append(parts, fmt.Sprintf("%.1f MB RSS peak", float64(sum.RSSPeak)/1e6))
+		set("server_rss_peak_bytes", strconv.FormatInt(sum.RSSPeak, 10))
+	}
+	if sum.ThreadsPeak != unknown {
+		parts = append(parts, fmt.Sprintf("%d threads peak", sum.ThreadsPeak))
+		set("server_threads_peak", strconv.FormatInt(sum.ThreadsPeak, 10))
+	}
+	if sum.GoroutinesPeak != unknown {
+		parts = append(parts, fmt.Sprintf("%d goroutines peak", sum.GoroutinesPeak))
+		set("server_goroutines_peak", strconv.FormatInt(sum.GoroutinesPeak, 10))
+	}
+	if sum.GCs != unknown {
+		parts = append(parts, fmt.Sprintf("%d GCs", sum.GCs))
+		set("server_gcs", strconv.FormatInt(sum.GCs, 10))
+	}
+	if sum.GCPause != unknown {
+		parts = append(parts, fmt.Sprintf("%v GC pause", sum.GCPause))
+		set("server_gc_pause", sum.GCPause.String())
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no figures reported")
+	}
+	fmt.Fprintf(w, "\nServer: %s\n", strings.Join(parts, ", "))
+	if sum.Failed > 0 {
+		fmt.Fprintf(w, "Server: %d samples failed, last: %v\n", sum.Failed, sum.LastErr)
+	}
+}
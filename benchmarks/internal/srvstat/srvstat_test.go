@@ -0,0 +1,116 @@
+package srvstat
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+func TestParseStat(t *testing.T) {
+	// A command name with spaces and a ')' must not shift the fields
+	stat := "4242 (my (srv) x) S 1 4242 4242 0 -1 4194560 100 0 0 0 250 150 0 0 20 0 12 0 1000 123456789 300 18446744073709551615"
+	s := newSample()
+	if err := parseStat([]byte(stat), 4096, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.CPU != 4*time.Second || s.Threads != 12 || s.RSS != 300*4096 {
+		t.Fatalf("cpu %v threads %d rss %d", s.CPU, s.Threads, s.RSS)
+	}
+	if err := parseStat([]byte("1 (x) S 1 2"), 4096, &s); err == nil {
+		t.Fatal("short stat accepted")
+	}
+}
+
+func TestParsePrometheus(t *testing.T) {
+	text := `# HELP go_goroutines Number of goroutines.
+# TYPE go_goroutines gauge
+go_goroutines 57
+go_gc_duration_seconds{quantile="0.5"} 0.0001
+go_gc_duration_seconds_sum 0.25
+go_gc_duration_seconds_count 40
+process_cpu_seconds_total 12.5
+process_resident_memory_bytes 3.2e+07
+`
+	s := newSample()
+	if err := parsePrometheus([]byte(text), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Goroutines != 57 || s.GCs != 40 || s.GCPause != 250*time.Millisecond || s.CPU != 12500*time.Millisecond || s.RSS != 32e6 {
+		t.Fatalf("%+v", s)
+	}
+	if s.Threads != unknown {
+		t.Fatalf("threads = %d, want unknown", s.Threads)
+	}
+}
+
+func TestParseExpvarKeepsProcFigures(t *testing.T) {
+	s := newSample()
+	s.RSS = 1000 // as if from /proc
+	vars := `{"cmdline":["srv"],"goroutines":9,"memstats":{"Sys":5000,"NumGC":3,"PauseTotalNs":1500}}`
+	if err := parseExpvar([]byte(vars), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.RSS != 1000 || s.Goroutines != 9 || s.GCs != 3 || s.GCPause != 1500 {
+		t.Fatalf("%+v", s)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	at := time.Now()
+	sample := func(sec int, cpu time.Duration, rss, gcs int64) Sample {
+		s := newSample()
+		s.At, s.CPU, s.RSS, s.GCs = at.Add(time.Duration(sec)*time.Second), cpu, rss, gcs
+		return s
+	}
+	sum := summarize([]Sample{
+		sample(0, 10*time.Second, 100, 5),
+		sample(1, 10500*time.Millisecond, 300, 7),
+		sample(2, 12*time.Second, 200, 8),
+	}, 0, nil)
+	if sum.CPUAvg != 100 || sum.CPUPeak != 150 {
+		t.Errorf("cpu avg %v peak %v, want 100 and 150", sum.CPUAvg, sum.CPUPeak)
+	}
+	if sum.RSSStart != 100 || sum.RSSPeak != 300 || sum.GCs != 3 {
+		t.Errorf("rss start %d peak %d gcs %d", sum.RSSStart, sum.RSSPeak, sum.GCs)
+	}
+	if sum.GoroutinesPeak != unknown || sum.GCPause != unknown {
+		t.Errorf("goroutines %d pause %v, want unknown", sum.GoroutinesPeak, sum.GCPause)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("go_goroutines 4\ngo_gc_duration_seconds_count 1\n"))
+	}))
+	defer srv.Close()
+
+	s, err := Start(os.Getpid(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	results := []report.Result{{Config: map[string]string{}}}
+	var out bytes.Buffer
+	s.Annotate(&out, results)
+	for _, key := range []string{"server_cpu_pct", "server_rss_peak_bytes", "server_threads_peak", "server_goroutines_peak", "server_gcs"} {
+		if _, ok := results[0].Config[key]; !ok {
+			t.Errorf("config lacks %s", key)
+		}
+	}
+	if !strings.Contains(out.String(), "4 goroutines peak") {
+		t.Errorf("output %q", out.String())
+	}
+
+	if _, err := Start(0, srv.URL+"/missing\x7f"); err == nil {
+		t.Error("bad URL accepted")
+	}
+	var nilSampler *Sampler
+	nilSampler.Stop()
+	nilSampler.Annotate(&out, results)
+}
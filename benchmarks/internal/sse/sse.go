@@ -0,0 +1,120 @@
+// Package sse parses text/event-stream bodies as described in the HTML
+// Living Standard's Server-Sent Events section.
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Event is one dispatched message. Type defaults to "message".
+type Event struct {
+	Type string
+	ID   string
+	Data string
+}
+
+// DefaultRetry is the reconnection time until a server sets one, in the
+// range browsers' EventSource uses.
+const DefaultRetry = 3 * time.Second
+
+// Reader yields events from a stream. LastID tracks the most recent id
+// field, which clients send back as Last-Event-ID when reconnecting, and
+// Retry the reconnection time, set by retry fields even in blocks without
+// data. Both belong to the connection rather than one stream, so a client
+// carries them over to the Reader of the next.
+type Reader struct {
+	sc     *bufio.Scanner
+	LastID string
+	Retry  time.Duration
+}
+
+// NewReader returns a Reader over r with Retry at DefaultRetry. Lines
+// longer than 1MB are rejected.
+func NewReader(r io.Reader) *Reader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	sc.Split(scanLines)
+	return &Reader{sc: sc, Retry: DefaultRetry}
+}
+
+// Next blocks until a complete event arrives. It returns io.EOF when the
+// stream ends; a trailing event without its blank line is discarded, as the
+// spec requires.
+func (r *Reader) Next() (Event, error) {
+	var ev Event
+	var data []byte
+	hasData := false
+	for r.sc.Scan() {
+		line := r.sc.Bytes()
+		if len(line) == 0 {
+			if !hasData {
+				ev = Event{}
+				continue
+			}
+			if ev.Type == "" {
+				ev.Type = "message"
+			}
+			ev.ID = r.LastID
+			ev.Data = string(bytes.TrimSuffix(data, []byte{'\n'}))
+			return ev, nil
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = bytes.TrimPrefix(value, []byte{' '})
+		}
+		switch string(field) {
+		case "event":
+			ev.Type = string(value)
+		case "data":
+			data = append(data, value...)
+			data = append(data, '\n')
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				r.LastID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(string(value)); err == nil && ms >= 0 {
+				r.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := r.sc.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+// scanLines splits on CRLF, LF or a lone CR.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		switch b {
+		case '\n':
+			return i + 1, data[:i], nil
+		case '\r':
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if atEOF {
+				return i + 1, data[:i], nil
+			}
+			// Need one more byte to tell CR from CRLF
+			return 0, nil, nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,74 @@
+package sse
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	stream := ": comment\r\n" +
+		"event: time\r\nid: 1\r\ndata: {\"t\":1}\r\n\r\n" +
+		"data: line one\ndata: line two\n\n" +
+		"retry: 2500\rdata:no-space\r\r" +
+		"retry: 4000\n\n" +
+		"id\ndata\n\n" +
+		"data: unterminated"
+
+	want := []Event{
+		{Type: "time", ID: "1", Data: `{"t":1}`},
+		{Type: "message", ID: "1", Data: "line one\nline two"},
+		{Type: "message", ID: "1", Data: "no-space"},
+		{Type: "message", ID: "", Data: ""},
+	}
+	r := NewReader(strings.NewReader(stream))
+	if r.Retry != DefaultRetry {
+		t.Errorf("initial retry = %v, want %v", r.Retry, DefaultRetry)
+	}
+	for i, w := range want {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if got != w {
+			t.Errorf("event %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("after last event: %v, want io.EOF", err)
+	}
+	// The retry field stands alone in its block, which dispatches nothing
+	if r.Retry != 4*time.Second {
+		t.Errorf("retry = %v, want 4s", r.Retry)
+	}
+}
+
+func TestReaderSkipsEmptyBlocks(t *testing.T) {
+	r := NewReader(strings.NewReader("event: ping\n\n\n: keepalive\n\ndata: x\n\n"))
+	ev, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The event field without data is dropped and must not leak into the
+	// next event
+	if ev.Type != "message" || ev.Data != "x" {
+		t.Fatalf("got %+v", ev)
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	event := "event: tick\nid: 42\ndata: {\"message\":\"Hello, World!\"}\n\n"
+	stream := strings.Repeat(event, 1000)
+	b.SetBytes(int64(len(event)))
+	b.ReportAllocs()
+	var r *Reader
+	for i := 0; b.Loop(); i++ {
+		if i%1000 == 0 {
+			r = NewReader(strings.NewReader(stream))
+		}
+		if _, err := r.Next(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
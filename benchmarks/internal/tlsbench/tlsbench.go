@@ -0,0 +1,177 @@
+// Package tlsbench holds the -tls flags shared by bench_http and
+// bench_http2 and counts the handshakes a run performs, split into full and
+// resumed, so handshake cost can be read separately from request cost.
+package tlsbench
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Options configures the client side of TLS runs.
+type Options struct {
+	Enabled    bool
+	MinVersion uint16
+	Ciphers    []uint16 // TLS 1.2 and below only; Go fixes the 1.3 suites
+	Resume     bool
+	Insecure   bool
+
+	handshakes atomic.Int64
+	resumed    atomic.Int64
+}
+
+// Register adds the TLS flags to fs.
+func (o *Options) Register(fs *flag.FlagSet) {
+	o.MinVersion = tls.VersionTLS12
+	fs.BoolVar(&o.Enabled, "tls", false, "connect over TLS (http:// URLs are switched to https://)")
+	fs.Func("tls-min", "minimum TLS version: 1.0, 1.1, 1.2 or 1.3 (default 1.2)", func(s string) error {
+		v, ok := versions[s]
+		if !ok {
+			return fmt.Errorf("unknown TLS version %q", s)
+		}
+		o.MinVersion = v
+		return nil
+	})
+	fs.Func("tls-ciphers", "comma-separated cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (TLS 1.2 and below; Go does not make 1.3 suites configurable)", func(s string) error {
+		ids, err := ParseCiphers(s)
+		o.Ciphers = ids
+		return err
+	})
+	fs.BoolVar(&o.Resume, "tls-resume", true, "allow session resumption; false forces a full handshake on every connection")
+	fs.BoolVar(&o.Insecure, "insecure", true, "skip certificate verification (FasterAPI's bundled certs are self-signed)")
+}
+
+// ParseCiphers maps comma-separated suite names to their IDs.
+func ParseCiphers(s string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// URL returns rawURL with http:// switched to https:// when TLS is on.
+func (o *Options) URL(rawURL string) string {
+	if o.Enabled && strings.HasPrefix(rawURL, "http://") {
+		return "https://" + strings.TrimPrefix(rawURL, "http://")
+	}
+	return rawURL
+}
+
+// Config returns a client config that counts every handshake it completes.
+// sessions sizes the resumption cache; it should cover the connection pool.
+func (o *Options) Config(sessions int) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:         o.MinVersion,
+		CipherSuites:       o.Ciphers,
+		InsecureSkipVerify: o.Insecure,
+		// Runs for resumed handshakes too, unlike VerifyPeerCertificate
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			o.handshakes.Add(1)
+			if cs.DidResume {
+				o.resumed.Add(1)
+			}
+			return nil
+		},
+	}
+	if o.Resume {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(sessions)
+	} else {
+		cfg.SessionTicketsDisabled = true
+	}
+	return cfg
+}
+
+// Handshakes returns the handshake totals so far.
+func (o *Options) Handshakes() (total, resumed int64) {
+	return o.handshakes.Load(), o.resumed.Load()
+}
+
+// Describe prints the TLS settings.
+func (o *Options) Describe(w io.Writer) {
+	if !o.Enabled {
+		return
+	}
+	fmt.Fprintf(w, "TLS: min %s, resumption %s\n", versionName(o.MinVersion), onOff(o.Resume))
+	if len(o.Ciphers) > 0 {
+		fmt.Fprintf(w, "TLS cipher suites: %s\n", cipherNames(o.Ciphers))
+	}
+}
+
+// Annotate prints the handshake totals to w and records them in the config
+// of every result. The rate is over the combined elapsed time of results.
+func (o *Options) Annotate(w io.Writer, results []report.Result) {
+	if !o.Enabled {
+		return
+	}
+	var elapsed time.Duration
+	for _, r := range results {
+		elapsed += r.Elapsed
+	}
+	total, resumed := o.Handshakes()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(total) / elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "\nTLS handshakes: %d (%d full, %d resumed), %.2f/sec\n", total, total-resumed, resumed, rate)
+
+	for _, r := range results {
+		r.Config["tls_min"] = versionName(o.MinVersion)
+		r.Config["tls_resume"] = strconv.FormatBool(o.Resume)
+		if len(o.Ciphers) > 0 {
+			r.Config["tls_ciphers"] = cipherNames(o.Ciphers)
+		}
+		r.Config["tls_handshakes"] = strconv.FormatInt(total, 10)
+		r.Config["tls_full"] = strconv.FormatInt(total-resumed, 10)
+		r.Config["tls_resumed"] = strconv.FormatInt(resumed, 10)
+		r.Config["tls_handshakes_per_sec"] = strconv.FormatFloat(rate, 'f', 2, 64)
+	}
+}
+
+func versionName(v uint16) string {
+	for name, id := range versions {
+		if id == v {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func cipherNames(ids []uint16) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = tls.CipherSuiteName(id)
+	}
+	return strings.Join(names, ",")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,73 @@
+package tlsbench
+
+import (
+	"crypto/tls"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"benchmarks/internal/report"
+)
+
+func TestRegister(t *testing.T) {
+	var o Options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.Register(fs)
+	err := fs.Parse([]string{"-tls", "-tls-min", "1.3", "-tls-resume=false",
+		"-tls-ciphers", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Enabled || o.Resume || o.MinVersion != tls.VersionTLS13 || len(o.Ciphers) != 2 {
+		t.Fatalf("options = %+v", &o)
+	}
+	if got := o.URL("http://localhost:8070/json"); got != "https://localhost:8070/json" {
+		t.Errorf("URL = %q", got)
+	}
+
+	for _, args := range [][]string{{"-tls-min", "1.4"}, {"-tls-ciphers", "TLS_NOPE"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		new(Options).Register(fs)
+		if err := fs.Parse(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func TestHandshakeCounts(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	for _, resume := range []bool{true, false} {
+		o := &Options{Enabled: true, MinVersion: tls.VersionTLS12, Resume: resume, Insecure: true}
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   o.Config(4),
+			DisableKeepAlives: true,
+		}}
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+
+		total, resumed := o.Handshakes()
+		if total != 3 {
+			t.Errorf("resume=%v: %d handshakes, want 3", resume, total)
+		}
+		if resume && resumed != 2 || !resume && resumed != 0 {
+			t.Errorf("resume=%v: %d resumed", resume, resumed)
+		}
+
+		results := []report.Result{{Elapsed: time.Second, Config: map[string]string{}}}
+		o.Annotate(io.Discard, results)
+		if results[0].Config["tls_handshakes_per_sec"] != "3.00" {
+			t.Errorf("config = %v", results[0].Config)
+		}
+	}
+}
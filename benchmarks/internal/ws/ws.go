@@ -0,0 +1,312 @@
+// Package ws is a minimal RFC 6455 WebSocket client: just enough framing to
+// drive echo and broadcast benchmarks. It works over any byte stream, so the
+// same Conn serves both HTTP/1.1 upgrades and RFC 8441 streams over HTTP/2.
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Opcodes from RFC 6455 section 5.2.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// MaxMessageSize bounds reassembled messages so a misbehaving server cannot
+// exhaust client memory.
+const MaxMessageSize = 16 << 20
+
+// ErrClosed is returned once the peer has sent a close frame.
+var ErrClosed = errors.New("ws: connection closed by peer")
+
+// Conn is a client-side WebSocket connection. Writes are serialised, so one
+// reader may run alongside any number of writers; pongs sent by ReadMessage
+// share the same lock.
+type Conn struct {
+	rw  io.ReadWriter
+	br  *bufio.Reader
+	raw net.Conn // nil when running over a non-socket stream
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// NewConn wraps an already-upgraded stream. Frames written are masked, as
+// RFC 6455 requires of clients.
+func NewConn(rw io.ReadWriter) *Conn {
+	c := &Conn{rw: rw, br: bufio.NewReaderSize(rw, 4096)}
+	c.raw, _ = rw.(net.Conn)
+	return c
+}
+
+// Dial performs the HTTP/1.1 upgrade handshake against a ws:// or wss://
+// URL. header may carry extra request headers such as Authorization.
+func Dial(ctx context.Context, rawURL string, header http.Header, tlsConfig *tls.Config) (*Conn, error) {
+	var d net.Dialer
+	return DialWith(ctx, d.DialContext, rawURL, header, tlsConfig)
+}
+
+// DialWith is Dial opening the TCP connection with dial.
+func DialWith(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), rawURL string, header http.Header, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		cfg := tlsConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("ws: unsupported scheme %q", u.Scheme)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	c := NewConn(conn)
+	if err := c.handshake(u, header); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) handshake(u *url.URL, header http.Header) error {
+	key := Key()
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Host:       u.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(c.rw); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(c.br, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("ws: handshake failed with status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != Accept(key) {
+		return errors.New("ws: bad Sec-WebSocket-Accept")
+	}
+	return nil
+}
+
+// Key returns a random Sec-WebSocket-Key.
+func Key() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// Accept computes the Sec-WebSocket-Accept value the server must echo.
+func Accept(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// SetDeadline sets read and write deadlines when the underlying stream is a
+// socket; it is a no-op otherwise.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if c.raw == nil {
+		return nil
+	}
+	return c.raw.SetDeadline(t)
+}
+
+// WriteMessage sends payload as a single masked frame.
+func (c *Conn) WriteMessage(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	n := len(payload)
+	buf := c.wbuf[:0]
+	buf = append(buf, 0x80|op)
+	switch {
+	case n < 126:
+		buf = append(buf, 0x80|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, 0x80|126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0x80|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	buf = append(buf, mask[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	for i := range payload {
+		buf[start+i] ^= mask[i&3]
+	}
+	c.wbuf = buf
+	_, err := c.rw.Write(buf)
+	return err
+}
+
+// ReadMessage returns the next data message, reassembling fragments.
+// Pings are answered automatically; a close frame is acknowledged and
+// reported as ErrClosed. The returned slice is only valid until the next
+// call.
+func (c *Conn) ReadMessage() (op byte, payload []byte, err error) {
+	var msg []byte
+	msgOp := byte(0)
+	for {
+		fin, fop, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.WriteMessage(OpClose, nil)
+			return OpClose, data, ErrClosed
+		case OpContinuation:
+			if msgOp == 0 {
+				return 0, nil, errors.New("ws: unexpected continuation frame")
+			}
+		default:
+			if msgOp != 0 {
+				return 0, nil, errors.New("ws: interleaved data frames")
+			}
+			msgOp = fop
+		}
+		if len(msg)+len(data) > MaxMessageSize {
+			return 0, nil, errors.New("ws: message too large")
+		}
+		if fin && msg == nil {
+			return msgOp, data, nil
+		}
+		msg = append(msg, data...)
+		if fin {
+			return msgOp, msg, nil
+		}
+	}
+}
+
+// Close sends a normal-closure frame without waiting for the reply.
+func (c *Conn) Close() error {
+	err := c.WriteMessage(OpClose, []byte{0x03, 0xE8})
+	if closer, ok := c.rw.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, data []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > MaxMessageSize {
+		return false, 0, nil, errors.New("ws: frame too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(c.br, data); err != nil {
+		return
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i&3]
+		}
+	}
+	return fin, op, data, nil
+}
+
+// IsClosed reports whether err means the peer closed the connection.
+func IsClosed(err error) bool {
+	return errors.Is(err, ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
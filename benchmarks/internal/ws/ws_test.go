@@ -0,0 +1,123 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer upgrades by hand and echoes every data frame back unmasked,
+// splitting messages into two fragments to exercise reassembly.
+func echoServer(t testing.TB) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		brw.WriteString("Sec-WebSocket-Accept: " + Accept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		brw.Flush()
+
+		c := &Conn{rw: conn, br: brw.Reader}
+		for {
+			fin, op, data, err := c.readFrame()
+			if err != nil || op == OpClose {
+				return
+			}
+			if !fin {
+				t.Error("client sent a fragmented frame")
+			}
+			half := len(data) / 2
+			w := bufio.NewWriter(conn)
+			writeServerFrame(w, false, op, data[:half])
+			writeServerFrame(w, true, OpContinuation, data[half:])
+			w.Flush()
+		}
+	}))
+}
+
+func writeServerFrame(w *bufio.Writer, fin bool, op byte, data []byte) {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	w.WriteByte(b0)
+	switch n := len(data); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	default:
+		w.Write([]byte{126, byte(n >> 8), byte(n)})
+	}
+	w.Write(data)
+}
+
+func TestAccept(t *testing.T) {
+	// Example from RFC 6455 section 1.3.
+	if got := Accept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Accept = %q", got)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, size := range []int{0, 5, 300, 70000} {
+		msg := bytes.Repeat([]byte{'x'}, size)
+		if err := c.WriteMessage(OpBinary, msg); err != nil {
+			t.Fatal(err)
+		}
+		op, got, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if op != OpBinary || !bytes.Equal(got, msg) {
+			t.Fatalf("size %d: got op %d, %d bytes", size, op, len(got))
+		}
+	}
+}
+
+func TestDialRejectsBadScheme(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+	if _, err := Dial(context.Background(), srv.URL, nil, nil); err == nil {
+		t.Fatal("Dial accepted an http:// URL")
+	}
+}
+
+func BenchmarkEcho(b *testing.B) {
+	srv := echoServer(b)
+	defer srv.Close()
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	msg := []byte("Hello, World!")
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := c.WriteMessage(OpText, msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := c.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
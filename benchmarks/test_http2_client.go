@@ -1,95 +1,255 @@
+//go:build ignore
+
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
+	"benchmarks/internal/conformance"
+	"benchmarks/internal/h2check"
 	"golang.org/x/net/http2"
 )
 
 func main() {
-	url := "http://localhost:8080/"
+	target := flag.String("url", "http://localhost:8080/", "server base URL; case paths resolve against it")
+	cases := flag.String("cases", "", "YAML file of test cases (default: the built-in smoke tests)")
+	timeout := flag.Duration("timeout", 5*time.Second, "per-case timeout")
+	parallel := flag.Int("parallel", 1, "run up to this many cases at once, each on connections of its own; cases marked serial run alone")
+	echoPath := flag.String("echo-path", "/echo", "path that answers POST with the request body, for the built-in cases sending bodies (empty leaves them out) and the h2c Upgrade and concurrent stream checks")
+	push := flag.String("push", "", "path of a page the server pushes resources with; enables push and checks the promises and pushed responses")
+	wsPath := flag.String("ws", "", "path of a WebSocket echo endpoint; checks RFC 8441 WebSocket over HTTP/2 with Extended CONNECT")
+	stream := flag.String("stream", "", "path of a large streamed response to read slowly, checking flow control and, with -server-pid or -server-stats, server memory")
+	streamRate := flag.Float64("stream-rate", 32, "MB/s at most at which -stream reads")
+	maxGrowth := flag.Float64("max-growth", 64, "MB the server's RSS may grow by during -stream")
+	serverPID := flag.Int("server-pid", 0, "pid of the server on this host, to sample its RSS from /proc during -stream")
+	serverStats := flag.String("server-stats", "", "the server's expvar (/debug/vars) or Prometheus (/metrics) URL, to sample its memory during -stream")
+	slow := flag.Duration("slow", 0, "also check that the server times out clients stalling midway through a request within this long, e.g. 30s; each of the four checks takes about that long")
+	idle := flag.Duration("idle", 0, "also hold a connection idle this long, e.g. 2m, then as long again with keepalive PINGs, checking the server answers them or closes with GOAWAY")
+	fuzz := flag.Int("fuzz", 0, "send malformed frames: the fixed cases of bad padding, stream IDs, sizes and settings, then this many random frames")
+	fuzzSeed := flag.Int64("fuzz-seed", 0, "seed for the -fuzz frames, to replay a failure (default: the current time)")
+	shutdown := flag.String("shutdown", "", "finally shut the server down with requests in flight, by POSTing to this admin URL or sending this signal (TERM, INT) to -server-pid, and check it drains gracefully")
+	useTLS := flag.Bool("tls", false, "connect with TLS and ALPN h2 instead of h2c, and check ALPN, the certificate and HTTP/1.1 fallback (implied by an https -url)")
+	caFile := flag.String("ca", "", "PEM file of roots to verify the server's certificate against with -tls (default: the system roots)")
+	formatFlag := flag.String("format", "text", "text, or junit, tap or json for CI (progress then goes to stderr)")
+	protocol := flag.Bool("protocol", true, "also run frame-level checks of SETTINGS, flow control, GOAWAY handling and the h2c Upgrade handshake")
+	flag.Parse()
 
-	// Create HTTP/2 transport with h2c (HTTP/2 cleartext)
-	transport := &http2.Transport{
-		AllowHTTP: true,
-		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
-			// Use regular TCP connection for h2c
-			return net.Dial(network, addr)
-		},
+	format, err := conformance.ParseFormat(*formatFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	base, err := url.Parse(*target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var tlsConfig *tls.Config
+	if *useTLS || base.Scheme == "https" {
+		base.Scheme = "https"
+		if tlsConfig, err = clientTLS(base.Hostname(), *caFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	var stop func() error
+	if *shutdown != "" {
+		if stop, err = shutdownFunc(*shutdown, *serverPID); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	suite := conformance.Default(*echoPath)
+	if *cases != "" {
+		if suite, err = conformance.Load(*cases); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
 	}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   5 * time.Second,
+	// Each case gets a transport, and so connections, of its own
+	newClient := func() *http.Client {
+		if tlsConfig != nil {
+			return &http.Client{Transport: &http2.Transport{TLSClientConfig: tlsConfig}}
+		}
+		// Create HTTP/2 transport with h2c (HTTP/2 cleartext)
+		return &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				// Use regular TCP connection for h2c
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}}
 	}
 
-	fmt.Println("Testing HTTP/2 server at", url)
-	fmt.Println()
+	info := format.Info()
+	fmt.Fprintln(info, "Testing HTTP/2 server at", base)
+	fmt.Fprintln(info)
 
-	// Test 1: Simple GET
-	fmt.Println("Test 1: Simple GET /")
-	resp, err := client.Get(url)
-	if err != nil {
-		fmt.Printf("  ✗ FAIL: %v\n", err)
-		return
-	}
-	body, _ := io.ReadAll(resp.Body)
-	resp.Body.Close()
-	fmt.Printf("  Status: %d\n", resp.StatusCode)
-	fmt.Printf("  Body: %s\n", string(body))
-	fmt.Printf("  Protocol: %s\n", resp.Proto)
-	if resp.StatusCode == 200 {
-		fmt.Println("  ✓ PASS")
-	}
-	fmt.Println()
+	start := time.Now()
+	results := suite.RunParallel(context.Background(), *parallel, *timeout, newClient, base, func(i int, res conformance.Result) {
+		c := suite.Cases[i]
+		fmt.Fprintf(info, "Test %d: %s\n", i+1, c.Name)
+		if res.Status != 0 {
+			fmt.Fprintf(info, "  Status: %d\n", res.Status)
+			fmt.Fprintf(info, "  Body: %s\n", res.BodyText())
+			fmt.Fprintf(info, "  Protocol: %s\n", res.Protocol)
+		}
+		if c.Concurrent > 1 || *parallel > 1 {
+			fmt.Fprintf(info, "  Total time: %.3fs\n", res.Elapsed.Seconds())
+		}
+		printOutcome(info, res)
+	})
+	if *parallel > 1 {
+		fmt.Fprintf(info, "Ran %d cases, up to %d at once, in %.3fs\n\n", len(results), *parallel, time.Since(start).Seconds())
+	}
 
-	// Test 2: JSON endpoint
-	fmt.Println("Test 2: GET /json")
-	resp, err = client.Get(url + "json")
-	if err != nil {
-		fmt.Printf("  ✗ FAIL: %v\n", err)
-		return
+	if *protocol {
+		frames := h2check.Target{Addr: hostPort(base), Path: base.RequestURI(), Echo: *echoPath, Push: *push, WebSocket: *wsPath, Timeout: *timeout,
+			Stream: *stream, StreamRate: *streamRate * 1e6, MaxGrowth: int64(*maxGrowth * 1e6),
+			ServerPID: *serverPID, ServerStats: *serverStats, SlowLimit: *slow, Idle: *idle, Fuzz: *fuzz, FuzzSeed: *fuzzSeed, Shutdown: stop, TLS: tlsConfig, Log: info,
+		}
+		checks := slices.Concat(h2check.Checks, h2check.Upgrades)
+		if tlsConfig != nil {
+			checks = slices.Concat(h2check.Checks, h2check.TLSChecks)
+		}
+		if *push != "" {
+			checks = append(checks, h2check.Push)
+		}
+		if *wsPath != "" {
+			checks = append(checks, h2check.WebSocket)
+		}
+		if *stream != "" {
+			checks = append(checks, h2check.Stream)
+		}
+		if *fuzz > 0 {
+			if frames.FuzzSeed == 0 {
+				frames.FuzzSeed = time.Now().UnixNano()
+			}
+			checks = append(checks, h2check.Malformed...)
+			checks = append(checks, h2check.Fuzz)
+		}
+		if *slow > 0 {
+			checks = append(checks, h2check.Timeouts...)
+		}
+		if *idle > 0 {
+			checks = append(checks, h2check.Idle)
+		}
+		// Last, since it stops the server
+		if stop != nil {
+			checks = append(checks, h2check.Shutdown)
+		}
+		for _, check := range checks {
+			fmt.Fprintf(info, "Test %d: %s\n", len(results)+1, check.Name)
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			err := check.Run(ctx, frames)
+			cancel()
+			res := conformance.Result{Name: check.Name, Err: err, Elapsed: time.Since(start)}
+			results = append(results, res)
+			printOutcome(info, res)
+		}
+	}
+
+	if err := conformance.Write(os.Stdout, format, "test_http2_client", results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	failed := 0
+	for _, res := range results {
+		if !res.Passed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(info, "✗✗✗ %d of %d tests failed ✗✗✗\n", failed, len(results))
+		os.Exit(1)
 	}
-	body, _ = io.ReadAll(resp.Body)
-	resp.Body.Close()
-	fmt.Printf("  Status: %d\n", resp.StatusCode)
-	fmt.Printf("  Body: %s\n", string(body))
-	if resp.StatusCode == 200 {
-		fmt.Println("  ✓ PASS")
+	fmt.Fprintln(info, "✓✓✓ All tests passed! ✓✓✓")
+}
+
+func printOutcome(info io.Writer, res conformance.Result) {
+	if res.Passed() {
+		fmt.Fprintln(info, "  ✓ PASS")
+	} else {
+		fmt.Fprintf(info, "  ✗ FAIL: %v\n", res.Err)
 	}
-	fmt.Println()
+	fmt.Fprintln(info)
+}
 
-	// Test 3: Concurrent requests
-	fmt.Println("Test 3: 10 concurrent requests")
-	start := time.Now()
-	done := make(chan bool, 10)
-	for i := 0; i < 10; i++ {
-		go func(id int) {
-			resp, err := client.Get(url)
-			if err == nil && resp.StatusCode == 200 {
-				io.Copy(io.Discard, resp.Body)
-				resp.Body.Close()
-				fmt.Printf("  Request %d: ✓\n", id)
-			} else {
-				fmt.Printf("  Request %d: ✗\n", id)
-			}
-			done <- true
-		}(i)
+// hostPort returns the address to dial for u, with the default port for
+// its scheme if it names none.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
 	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
 
-	// Wait for all
-	for i := 0; i < 10; i++ {
-		<-done
+// clientTLS returns the config that verifies the server as host, against
+// the roots in caFile or else the system's.
+func clientTLS(host, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: host}
+	if caFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RootCAs = x509.NewCertPool()
+	if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates", caFile)
 	}
-	elapsed := time.Since(start)
-	fmt.Printf("  Total time: %.3fs\n", elapsed.Seconds())
-	fmt.Println("  ✓ PASS")
+	return cfg, nil
+}
 
-	fmt.Println()
-	fmt.Println("✓✓✓ All tests passed! ✓✓✓")
+// shutdownFunc returns what -shutdown asks for: a POST to an admin URL, or
+// a signal to the server's pid.
+func shutdownFunc(spec string, pid int) (func() error, error) {
+	if strings.Contains(spec, "://") {
+		return func() error {
+			resp, err := http.Post(spec, "", nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				return fmt.Errorf("%s: %s", spec, resp.Status)
+			}
+			return nil
+		}, nil
+	}
+	signals := map[string]syscall.Signal{"TERM": syscall.SIGTERM, "INT": syscall.SIGINT}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(spec), "SIG")]
+	if !ok {
+		return nil, fmt.Errorf("-shutdown %q is neither a URL nor TERM or INT", spec)
+	}
+	if pid <= 0 {
+		return nil, fmt.Errorf("-shutdown %s needs -server-pid", spec)
+	}
+	return func() error {
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		return p.Signal(sig)
+	}, nil
 }